@@ -1,20 +1,28 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
+	"net/http"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 	"utopia-server/internal/api"
 	"utopia-server/internal/auth"
 	"utopia-server/internal/client"
 	"utopia-server/internal/config"
 	"utopia-server/internal/controller"
 	"utopia-server/internal/database"
+	"utopia-server/internal/history"
+	"utopia-server/internal/metrics"
 	"utopia-server/internal/node"
+	"utopia-server/internal/pki"
+	"utopia-server/internal/registry"
 	"utopia-server/internal/scheduler"
+	"utopia-server/internal/secrets"
 	"utopia-server/internal/tunnel"
 
 	"github.com/go-sql-driver/mysql"
@@ -72,42 +80,146 @@ func main() {
 		log.Fatalf("could not start tunnel service: %v", err)
 	}
 	defer tunnelService.Stop()
+	if !cfg.FRP.EnforceNodeIdentity {
+		log.Println("warning: frp.enforce_node_identity is off; any frpc holding frp.token can open another node's control tunnel")
+	}
 
 	authStore := auth.NewMySQLStore(db)
 	authService := auth.NewService(authStore, cfg)
 
+	// Per-node agent secrets are encrypted with the master key
+	var secretBox *secrets.Box
+	if cfg.Secrets.Key != "" {
+		key, err := secrets.ParseKey(cfg.Secrets.Key)
+		if err != nil {
+			log.Fatalf("invalid secrets key: %v", err)
+		}
+		if secretBox, err = secrets.NewBox(key); err != nil {
+			log.Fatalf("could not create secrets box: %v", err)
+		}
+	} else {
+		log.Println("warning: secrets.key is not set; nodes get no agent secret and agents are called with frp.agent_token")
+	}
+	sharedAgentToken := cfg.FRP.AgentToken
+	if !cfg.Agent.AllowSharedToken {
+		sharedAgentToken = ""
+	}
+	agentAuth := secrets.NewAgentAuthenticator(secretBox, sharedAgentToken)
+
+	// The internal CA issues agent certificates for mutual TLS
+	var ca *pki.CA
+	if cfg.PKI.CACert != "" || cfg.PKI.CAKey != "" {
+		if ca, err = pki.LoadOrCreateCA(cfg.PKI.CACert, cfg.PKI.CAKey); err != nil {
+			log.Fatalf("could not load internal CA: %v", err)
+		}
+	} else {
+		log.Println("warning: pki.ca_cert is not set; agents are called over plain HTTP")
+	}
+	agentTLS := pki.NewTransports(ca, time.Duration(cfg.PKI.ClientCertTTL)*time.Second)
+
 	nodeStore := node.NewMySQLStore(db)
-	nodeService := node.NewService(nodeStore)
+	nodeService := node.NewService(nodeStore, secretBox, ca, time.Duration(cfg.PKI.CertTTL)*time.Second)
+
+	// The shared agent token opens every agent; make it obvious while it is still needed
+	if nodes, err := nodeStore.ListNodes(); err != nil {
+		log.Printf("warning: could not list nodes to check agent secrets: %v", err)
+	} else {
+		var legacy int
+		for _, n := range nodes {
+			if n.AgentSecret == "" {
+				legacy++
+			}
+		}
+		switch {
+		case legacy > 0 && sharedAgentToken != "":
+			log.Printf("WARNING: %d nodes have no agent secret and are called with the shared frp.agent_token, which is accepted by every agent; rotate their credentials and set agent.allow_shared_token to false", legacy)
+		case legacy > 0:
+			log.Printf("WARNING: %d nodes have no agent secret and agent.allow_shared_token is false; they cannot be called until their credentials are rotated", legacy)
+		case sharedAgentToken != "":
+			log.Println("warning: agent.allow_shared_token is on but every node has an agent secret; set it to false")
+		}
+	}
+
+	// Import the static node inventory, if configured
+	if cfg.Inventory.Path != "" {
+		inventory, err := node.LoadInventory(cfg.Inventory.Path)
+		if err != nil {
+			log.Fatalf("could not load node inventory: %v", err)
+		}
+		result, err := nodeService.ImportInventory(inventory)
+		if err != nil {
+			log.Fatalf("could not import node inventory: %v", err)
+		}
+		log.Printf("Node inventory imported: %d created, %d updated, %d skipped", len(result.Created), len(result.Updated), len(result.Skipped))
+		for hostname, reason := range result.Skipped {
+			log.Printf("warning: inventory node %s skipped: %s", hostname, reason)
+		}
+	}
 
 	gpuClaimStore := controller.NewMySQLStore(db)
 
 	// Create the scheduler
 	sched := scheduler.NewScheduler(nodeStore)
 
+	// Registry credentials are encrypted with the same master key
+	registryService := registry.NewService(registry.NewMySQLStore(db), secretBox)
+
 	// Create and run the controller in a separate goroutine
-	agentClient := client.NewAgentClient(cfg.FRP)
+	agentClient := client.NewAgentClient(cfg.Agent, agentAuth, agentTLS, registryService)
 	ctrl := controller.NewController(gpuClaimStore, sched, nodeStore, agentClient)
+
+	// Background services stop when stopCh is closed; services lets shutdown
+	// wait for them before the database is closed.
 	stopCh := make(chan struct{})
-	defer close(stopCh)
+	var services sync.WaitGroup
+	runService := func(run func(stopCh <-chan struct{})) {
+		services.Add(1)
+		go func() {
+			defer services.Done()
+			run(stopCh)
+		}()
+	}
+
 	log.Println("Starting controller...")
-	go ctrl.Run(stopCh)
+	runService(ctrl.Run)
+
+	// Remove or adopt containers that no claim points to
+	containerGC := controller.NewContainerGC(gpuClaimStore, nodeStore, agentClient, cfg.GC)
+	runService(containerGC.Run)
+
+	// Pull images onto nodes ahead of time at the admin's request
+	prepuller := controller.NewPrepuller(controller.NewMySQLPrepullStore(db), nodeStore, agentClient, cfg.Prepull)
+	runService(prepuller.Run)
 
 	// Setup and run discovery service
 	log.Println("Starting discovery service...")
-	discoveryService := node.NewDiscoveryService(
-		fmt.Sprintf("http://localhost:%d", cfg.FRP.DashboardPort),
-		cfg.FRP.DashboardUser,
-		cfg.FRP.DashboardPwd,
-		nodeStore,
-	)
-	go discoveryService.Run(stopCh)
+	discoveryService := node.NewDiscoveryService(cfg.FRP, nodeStore, node.MetricsSink)
+	runService(discoveryService.Run)
+
+	// Setup and run metrics history service
+	historyService := history.NewService(history.NewMySQLStore(db), cfg.History)
+	runService(historyService.Run)
 
 	// Setup and run health check service
 	log.Println("Starting health check service...")
-	healthCheckService := node.NewHealthCheckService(nodeStore, cfg.FRP)
-	go healthCheckService.Run(stopCh)
+	healthCheckService := node.NewHealthCheckService(nodeStore, agentAuth, agentTLS, cfg.Health, historyService)
+	runService(healthCheckService.Run)
+
+	// Export fleet and claim metrics on /metrics, on a separate listener so that
+	// they are not reachable through the public API port
+	metrics.Default.MustRegister(healthCheckService, ctrl)
+	if cfg.Metrics.Addr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Default.Handler())
+			log.Printf("Metrics server listening on %s", cfg.Metrics.Addr)
+			if err := http.ListenAndServe(cfg.Metrics.Addr, mux); err != nil {
+				log.Fatalf("could not start metrics server: %v", err)
+			}
+		}()
+	}
 
-	server := api.NewServer(cfg.Server, authService, nodeService, gpuClaimStore, agentClient)
+	server := api.NewServer(cfg.Server, authService, nodeService, gpuClaimStore, agentClient, historyService, discoveryService, healthCheckService, containerGC, prepuller, registryService, cfg.Inventory.Path)
 
 	log.Println("Starting API server...")
 	go func() {
@@ -119,8 +231,14 @@ func main() {
 	}()
 
 	// Wait for interrupt signal to gracefully shutdown the server
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
 	log.Println("Shutting down server...")
+
+	// Let the background services finish their current pass (the health
+	// check service flushes queued writes) before the deferred db.Close runs.
+	close(stopCh)
+	services.Wait()
+	log.Println("Background services stopped")
 }
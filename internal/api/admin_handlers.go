@@ -0,0 +1,381 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"utopia-server/internal/models"
+	"utopia-server/internal/node"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GpuSummary aggregates the GPU state of a single node.
+type GpuSummary struct {
+	Total       int `json:"total"`
+	Available   int `json:"available"`
+	Busy        int `json:"busy"`
+	Quarantined int `json:"quarantined"`
+}
+
+// AdminNodeView is the admin representation of a node.
+type AdminNodeView struct {
+	ID           string                `json:"id"`
+	LegacyID     int64                 `json:"legacyId,omitempty"`
+	Hostname     string                `json:"hostname"`
+	Status       string                `json:"status"`
+	Labels       map[string]string     `json:"labels"`
+	ControlPort  int                   `json:"controlPort"`
+	Address      string                `json:"address,omitempty"`
+	ExpectedGpus int                   `json:"expectedGpus,omitempty"`
+	LastSeen     time.Time             `json:"lastSeen"`
+	Inventory    *models.NodeInventory `json:"inventory,omitempty"`
+	AgentAPI     string                `json:"agentApi"` // negotiated agent API version, empty if incompatible
+	GpuSummary   GpuSummary            `json:"gpuSummary"`
+	Gpus         []models.GpuInfo      `json:"gpus,omitempty"`
+	System       *models.SystemMetrics `json:"system,omitempty"`
+	ClaimsHosted int                   `json:"claimsHosted"`
+
+	// AgentAuth is "signed" when the server signs requests with the node's
+	// agent secret and "shared-token" when it falls back to frp.agent_token.
+	AgentAuth           string     `json:"agentAuth"`
+	CredentialsIssuedAt *time.Time `json:"credentialsIssuedAt,omitempty"`
+	// CertificateExpiresAt is set when the agent is called over mutual TLS.
+	CertificateExpiresAt *time.Time `json:"certificateExpiresAt,omitempty"`
+	// ClaimedMachineID is set on nodes that registered with another node's
+	// machine ID but without its credential; merge them into that node to confirm.
+	ClaimedMachineID string `json:"claimedMachineId,omitempty"`
+	// RequestedAddress is the direct address the agent asked for at registration.
+	// It takes effect only once an admin sets it as the node's address.
+	RequestedAddress string `json:"requestedAddress,omitempty"`
+}
+
+type UpdateNodeRequest struct {
+	Hostname *string           `json:"hostname"`
+	Labels   map[string]string `json:"labels"`
+	// Address sets the node's direct agent address; an empty string clears it.
+	Address *string `json:"address"`
+}
+
+func newAdminNodeView(node *models.Node, claimsHosted int) AdminNodeView {
+	summary := GpuSummary{Total: len(node.Gpus)}
+	for _, gpu := range node.Gpus {
+		if gpu.Busy {
+			summary.Busy++
+		} else if gpu.Health == models.GpuHealthQuarantined {
+			summary.Quarantined++
+		} else {
+			summary.Available++
+		}
+	}
+
+	agentAPI, _ := node.AgentAPI()
+	agentAuth := "shared-token"
+	if node.AgentSecret != "" {
+		agentAuth = "signed"
+	}
+
+	return AdminNodeView{
+		ID:                   node.ID,
+		LegacyID:             node.LegacyID,
+		Hostname:             node.Hostname,
+		Status:               node.Status,
+		Labels:               node.Labels,
+		ControlPort:          node.ControlPort,
+		Address:              node.Address,
+		ExpectedGpus:         node.ExpectedGpus,
+		LastSeen:             node.LastSeen,
+		Inventory:            node.Inventory,
+		AgentAPI:             agentAPI,
+		AgentAuth:            agentAuth,
+		CredentialsIssuedAt:  node.CredentialsIssuedAt,
+		CertificateExpiresAt: node.CertificateExpiresAt,
+		ClaimedMachineID:     node.ClaimedMachineID,
+		RequestedAddress:     node.RequestedAddress,
+		GpuSummary:           summary,
+		ClaimsHosted:         claimsHosted,
+	}
+}
+
+// activeClaimsByNode counts the Scheduled, Running and Terminating claims hosted on each node.
+func (s *Server) activeClaimsByNode() (map[string]int, error) {
+	claims, err := s.GpuClaimStore.ListByPhase(models.GpuClaimPhaseScheduled, models.GpuClaimPhaseRunning, models.GpuClaimPhaseTerminating)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, claim := range claims {
+		counts[claim.Status.NodeName]++
+	}
+	return counts, nil
+}
+
+// parseNodeID validates the :id path parameter, which may be a node UUID or a
+// legacy integer ID.
+func parseNodeID(c *gin.Context) (string, bool) {
+	id := c.Param("id")
+	if !node.IsValidNodeRef(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		return "", false
+	}
+	return id, true
+}
+
+func (s *Server) handleAdminListNodes(c *gin.Context) {
+	nodes, err := s.nodeService.ListNodes()
+	if err != nil {
+		log.Printf("Error listing nodes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list nodes"})
+		return
+	}
+
+	claimCounts, err := s.activeClaimsByNode()
+	if err != nil {
+		log.Printf("Error counting claims per node: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list gpu claims"})
+		return
+	}
+
+	views := make([]AdminNodeView, 0, len(nodes))
+	for _, node := range nodes {
+		views = append(views, newAdminNodeView(node, claimCounts[node.ID]))
+	}
+
+	c.JSON(http.StatusOK, views)
+}
+
+func (s *Server) handleAdminGetNode(c *gin.Context) {
+	id, ok := parseNodeID(c)
+	if !ok {
+		return
+	}
+
+	node, err := s.nodeService.GetNode(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		return
+	}
+
+	claimCounts, err := s.activeClaimsByNode()
+	if err != nil {
+		log.Printf("Error counting claims per node: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list gpu claims"})
+		return
+	}
+
+	view := newAdminNodeView(node, claimCounts[node.ID])
+	view.Gpus = node.Gpus
+	view.System = node.System
+	c.JSON(http.StatusOK, view)
+}
+
+func (s *Server) handleAdminUpdateNode(c *gin.Context) {
+	id, ok := parseNodeID(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if req.Hostname != nil && *req.Hostname == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hostname must not be empty"})
+		return
+	}
+	if req.Address != nil && *req.Address != "" && !node.IsValidAddress(*req.Address) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "address must be in host:port form"})
+		return
+	}
+
+	existing, err := s.nodeService.GetNode(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		return
+	}
+
+	node, err := s.nodeService.UpdateNodeMetadata(existing.ID, req.Hostname, req.Labels, req.Address)
+	if err != nil {
+		log.Printf("Error updating node %s: %v", existing.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update node"})
+		return
+	}
+
+	claimCounts, err := s.activeClaimsByNode()
+	if err != nil {
+		log.Printf("Error counting claims per node: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list gpu claims"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newAdminNodeView(node, claimCounts[node.ID]))
+}
+
+func (s *Server) handleAdminDeleteNode(c *gin.Context) {
+	id, ok := parseNodeID(c)
+	if !ok {
+		return
+	}
+
+	node, err := s.nodeService.GetNode(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		return
+	}
+
+	claimCounts, err := s.activeClaimsByNode()
+	if err != nil {
+		log.Printf("Error counting claims per node: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list gpu claims"})
+		return
+	}
+	if claimCounts[node.ID] > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "node still hosts active gpu claims"})
+		return
+	}
+
+	if err := s.nodeService.DeleteNode(node.ID); err != nil {
+		log.Printf("Error deleting node %s: %v", node.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete node"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// handleAdminRotateNodeCredentials 为节点签发新的节点凭据和 agent 密钥，旧凭据立即失效。
+// 明文只在响应中返回一次，需要由管理员下发给节点上的 agent。
+func (s *Server) handleAdminRotateNodeCredentials(c *gin.Context) {
+	id, ok := parseNodeID(c)
+	if !ok {
+		return
+	}
+
+	existing, err := s.nodeService.GetNode(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		return
+	}
+
+	node, creds, err := s.nodeService.RotateCredentials(existing.ID)
+	if err != nil {
+		log.Printf("Error rotating credentials of node %s: %v", existing.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate node credentials"})
+		return
+	}
+	log.Printf("Rotated credentials of node %s (%s)", node.Hostname, node.ID)
+
+	c.JSON(http.StatusOK, s.credentialsResponse(node, creds))
+}
+
+type MergeNodesRequest struct {
+	DuplicateIDs []string `json:"duplicate_ids" binding:"required"`
+}
+
+// handleAdminMergeNodes 将重复节点合并到 :id 指定的节点：
+// 迁移重复节点上的 GpuClaim，合并标签与机器标识，然后删除重复节点。
+func (s *Server) handleAdminMergeNodes(c *gin.Context) {
+	targetRef, ok := parseNodeID(c)
+	if !ok {
+		return
+	}
+
+	var req MergeNodesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	target, err := s.nodeService.GetNode(targetRef)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		return
+	}
+	duplicates := make([]*models.Node, 0, len(req.DuplicateIDs))
+	for _, duplicateRef := range req.DuplicateIDs {
+		duplicate, err := s.nodeService.GetNode(duplicateRef)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("duplicate node %s not found", duplicateRef)})
+			return
+		}
+		if duplicate.ID == target.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot merge a node into itself"})
+			return
+		}
+		duplicates = append(duplicates, duplicate)
+	}
+
+	for _, duplicate := range duplicates {
+		if _, err := s.nodeService.MergeNode(target.ID, duplicate.ID); err != nil {
+			log.Printf("Error merging node %s into %s: %v", duplicate.ID, target.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to merge nodes"})
+			return
+		}
+	}
+
+	s.handleAdminGetNode(c)
+}
+
+type GarbageCollectNodesRequest struct {
+	OfflineForSeconds int   `json:"offline_for_seconds" binding:"required,min=1"`
+	DryRun            *bool `json:"dry_run"`
+}
+
+type GarbageCollectNodesResponse struct {
+	DryRun  bool            `json:"dryRun"`
+	Removed []AdminNodeView `json:"removed"`
+	Skipped []AdminNodeView `json:"skipped"`
+}
+
+// handleAdminGarbageCollectNodes 清理长时间处于 Offline/Registering 状态的节点。
+// 默认只做演练（dry_run），仍承载活跃 GpuClaim 的节点会被跳过。
+func (s *Server) handleAdminGarbageCollectNodes(c *gin.Context) {
+	var req GarbageCollectNodesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	dryRun := req.DryRun == nil || *req.DryRun
+
+	stale, err := s.nodeService.StaleNodes(time.Duration(req.OfflineForSeconds) * time.Second)
+	if err != nil {
+		log.Printf("Error listing stale nodes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list nodes"})
+		return
+	}
+
+	claimCounts, err := s.activeClaimsByNode()
+	if err != nil {
+		log.Printf("Error counting claims per node: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list gpu claims"})
+		return
+	}
+
+	resp := GarbageCollectNodesResponse{
+		DryRun:  dryRun,
+		Removed: []AdminNodeView{},
+		Skipped: []AdminNodeView{},
+	}
+	for _, node := range stale {
+		view := newAdminNodeView(node, claimCounts[node.ID])
+		if view.ClaimsHosted > 0 {
+			resp.Skipped = append(resp.Skipped, view)
+			continue
+		}
+		if !dryRun {
+			if err := s.nodeService.DeleteNode(node.ID); err != nil {
+				log.Printf("Error deleting stale node %s: %v", node.ID, err)
+				resp.Skipped = append(resp.Skipped, view)
+				continue
+			}
+			log.Printf("Garbage collected stale node %s (%s)", node.Hostname, node.ID)
+		}
+		resp.Removed = append(resp.Removed, view)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
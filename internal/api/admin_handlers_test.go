@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"utopia-server/internal/auth"
+	"utopia-server/internal/client"
+	"utopia-server/internal/config"
+	"utopia-server/internal/controller"
+	"utopia-server/internal/models"
+	"utopia-server/internal/node"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// adminTestServer is a Server backed by in-memory stores, with a token for an
+// admin and for a developer.
+type adminTestServer struct {
+	server     *Server
+	nodeStore  node.Store
+	claimStore controller.GpuClaimStore
+	adminToken string
+	devToken   string
+}
+
+func newAdminTestServer(t *testing.T) *adminTestServer {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.JWT.SecretKey = "test-secret"
+	cfg.JWT.TokenTTL = 3600
+
+	authStore := auth.NewMemStore()
+	authService := auth.NewService(authStore, cfg)
+	token := func(username, roleName string) string {
+		role, err := authStore.GetRoleByName(roleName)
+		require.NoError(t, err)
+		user := &models.User{Username: username, RoleID: role.ID}
+		require.NoError(t, authStore.CreateUser(user))
+		token, err := authService.GenerateToken(user)
+		require.NoError(t, err)
+		return token
+	}
+
+	nodeStore := node.NewMemStore()
+	claimStore := controller.NewMemStore()
+	server := NewServer(cfg.Server, authService, node.NewService(nodeStore, nil, nil, 0), claimStore, client.NewFakeAgent(), nil, nil, nil, nil, nil, nil, "")
+	return &adminTestServer{
+		server:     server,
+		nodeStore:  nodeStore,
+		claimStore: claimStore,
+		adminToken: token("admin", models.RoleAdmin),
+		devToken:   token("dev", "developer"),
+	}
+}
+
+func (s *adminTestServer) do(method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.server.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminNodeHandlers_RequireAdmin(t *testing.T) {
+	s := newAdminTestServer(t)
+	n := &models.Node{Hostname: "gpu-node-01", Status: "Online"}
+	require.NoError(t, s.nodeStore.CreateNode(n))
+
+	assert.Equal(t, http.StatusUnauthorized, s.do(http.MethodGet, "/api/admin/nodes", "").Code)
+	assert.Equal(t, http.StatusForbidden, s.do(http.MethodGet, "/api/admin/nodes", s.devToken).Code)
+	assert.Equal(t, http.StatusForbidden, s.do(http.MethodDelete, "/api/admin/nodes/"+n.ID, s.devToken).Code)
+	assert.Equal(t, http.StatusOK, s.do(http.MethodGet, "/api/admin/nodes", s.adminToken).Code)
+
+	_, err := s.nodeStore.GetNode(n.ID)
+	assert.NoError(t, err, "a developer cannot delete nodes")
+}
+
+func TestAdminNodeHandlers_ParseNodeID(t *testing.T) {
+	s := newAdminTestServer(t)
+	n := &models.Node{Hostname: "gpu-node-01", Status: "Online", LegacyID: 42}
+	require.NoError(t, s.nodeStore.CreateNode(n))
+
+	for _, ref := range []string{n.ID, strconv.FormatInt(n.LegacyID, 10)} {
+		rec := s.do(http.MethodGet, "/api/admin/nodes/"+ref, s.adminToken)
+		require.Equal(t, http.StatusOK, rec.Code, ref)
+		var view AdminNodeView
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &view))
+		assert.Equal(t, n.ID, view.ID, ref)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, s.do(http.MethodGet, "/api/admin/nodes/gpu-node-01", s.adminToken).Code)
+	assert.Equal(t, http.StatusNotFound, s.do(http.MethodGet, "/api/admin/nodes/"+uuid.NewString(), s.adminToken).Code)
+	assert.Equal(t, http.StatusNotFound, s.do(http.MethodGet, "/api/admin/nodes/7", s.adminToken).Code)
+}
+
+func TestAdminNodeHandlers_DeleteRefusesNodeWithActiveClaims(t *testing.T) {
+	s := newAdminTestServer(t)
+	n := &models.Node{Hostname: "gpu-node-01", Status: "Online", LegacyID: 42}
+	require.NoError(t, s.nodeStore.CreateNode(n))
+	claim := &models.GpuClaim{ID: "claim-1", Status: models.GpuClaimStatus{Phase: models.GpuClaimPhaseRunning, NodeName: n.ID}}
+	require.NoError(t, s.claimStore.CreateGpuClaim(claim))
+
+	assert.Equal(t, http.StatusConflict, s.do(http.MethodDelete, "/api/admin/nodes/"+n.ID, s.adminToken).Code)
+	assert.Equal(t, http.StatusConflict, s.do(http.MethodDelete, "/api/admin/nodes/42", s.adminToken).Code)
+	_, err := s.nodeStore.GetNode(n.ID)
+	require.NoError(t, err)
+
+	claim.Status.Phase = models.GpuClaimPhaseCompleted
+	require.NoError(t, s.claimStore.Update(claim))
+	assert.Equal(t, http.StatusNoContent, s.do(http.MethodDelete, "/api/admin/nodes/42", s.adminToken).Code)
+	assert.Equal(t, http.StatusNotFound, s.do(http.MethodGet, "/api/admin/nodes/"+n.ID, s.adminToken).Code)
+}
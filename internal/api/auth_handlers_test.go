@@ -14,7 +14,9 @@ import (
 	"utopia-server/internal/config"
 	"utopia-server/internal/controller"
 	"utopia-server/internal/database"
+	"utopia-server/internal/history"
 	"utopia-server/internal/node"
+	"utopia-server/internal/secrets"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -39,12 +41,13 @@ func TestAuthAPI(t *testing.T) {
 	authService := auth.NewService(authStore, cfg)
 
 	nodeStore := node.NewMySQLStore(testDB)
-	nodeService := node.NewService(nodeStore)
+	nodeService := node.NewService(nodeStore, nil, nil, 0)
 
 	gpuClaimStore := controller.NewMySQLStore(testDB)
-	agentClient := client.NewAgentClient(cfg.FRP)
+	agentClient := client.NewAgentClient(cfg.Agent, secrets.NewAgentAuthenticator(nil, cfg.FRP.AgentToken), nil, nil)
 
-	server := NewServer(cfg.Server, authService, nodeService, gpuClaimStore, agentClient)
+	historyService := history.NewService(history.NewMySQLStore(testDB), cfg.History)
+	server := NewServer(cfg.Server, authService, nodeService, gpuClaimStore, agentClient, historyService, nil, nil, nil, nil, nil, "")
 
 	// Start a test server
 	testServer := httptest.NewServer(server.Router)
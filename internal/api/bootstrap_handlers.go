@@ -0,0 +1,82 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"utopia-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateBootstrapTokenRequest struct {
+	Description string `json:"description"`
+	SingleUse   bool   `json:"single_use"`
+	TTLSeconds  int    `json:"ttl_seconds"`
+}
+
+type CreateBootstrapTokenResponse struct {
+	Token string                 `json:"token"`
+	Info  *models.BootstrapToken `json:"info"`
+}
+
+func (s *Server) handleCreateBootstrapToken(c *gin.Context) {
+	var req CreateBootstrapTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if req.TTLSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ttl_seconds must not be negative"})
+		return
+	}
+	if !req.SingleUse && req.TTLSeconds == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bootstrap token must be single-use or have an expiry"})
+		return
+	}
+
+	createdBy := ""
+	if user, ok := c.MustGet("user").(*models.User); ok {
+		createdBy = user.Username
+	}
+
+	token, info, err := s.nodeService.CreateBootstrapToken(req.Description, createdBy, req.SingleUse, time.Duration(req.TTLSeconds)*time.Second)
+	if err != nil {
+		log.Printf("Error creating bootstrap token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create bootstrap token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, CreateBootstrapTokenResponse{Token: token, Info: info})
+}
+
+func (s *Server) handleListBootstrapTokens(c *gin.Context) {
+	tokens, err := s.nodeService.ListBootstrapTokens()
+	if err != nil {
+		log.Printf("Error listing bootstrap tokens: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list bootstrap tokens"})
+		return
+	}
+	if tokens == nil {
+		tokens = []*models.BootstrapToken{}
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (s *Server) handleRevokeBootstrapToken(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token ID"})
+		return
+	}
+
+	if err := s.nodeService.RevokeBootstrapToken(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "bootstrap token not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleAdminOrphanContainers reports the containers the garbage collector
+// would adopt or remove on its next pass, without changing anything.
+func (s *Server) handleAdminOrphanContainers(c *gin.Context) {
+	report, err := s.containerGC.Collect(c.Request.Context(), true)
+	if err != nil {
+		log.Printf("Error collecting orphaned containers: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to collect orphaned containers"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
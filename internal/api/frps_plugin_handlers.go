@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// frps 服务器插件协议的请求与响应，参见 frp 文档 doc/server_plugin.md。
+type frpsPluginRequest struct {
+	Version string          `json:"version"`
+	Op      string          `json:"op"`
+	Content json.RawMessage `json:"content"`
+}
+
+type frpsPluginResponse struct {
+	Reject       bool   `json:"reject"`
+	RejectReason string `json:"reject_reason,omitempty"`
+	Unchange     bool   `json:"unchange"`
+}
+
+type frpsUserInfo struct {
+	User  string            `json:"user"`
+	Metas map[string]string `json:"metas"`
+	RunID string            `json:"run_id"`
+}
+
+type frpsLoginContent struct {
+	Hostname      string            `json:"hostname"`
+	RunID         string            `json:"run_id"`
+	Metas         map[string]string `json:"metas"`
+	ClientAddress string            `json:"client_address"`
+}
+
+type frpsNewProxyContent struct {
+	User       frpsUserInfo `json:"user"`
+	ProxyName  string       `json:"proxy_name"`
+	ProxyType  string       `json:"proxy_type"`
+	RemotePort int          `json:"remote_port"`
+}
+
+type frpsCloseProxyContent struct {
+	User      frpsUserInfo `json:"user"`
+	ProxyName string       `json:"proxy_name"`
+}
+
+// LoopbackOnlyMiddleware 只允许来自本机的请求，用于仅供 frps 调用的内部接口。
+// 这里使用 TCP 连接的对端地址而不是 ClientIP，避免被 X-Forwarded-For 头伪造。
+// 因此服务器前面不能有部署在同一主机上的反向代理：经它转发的外部请求的对端地址也是本机，会被放行。
+func (s *Server) LoopbackOnlyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+		if err != nil || !net.ParseIP(host).IsLoopback() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// handleFrpsPlugin 实现 frps 的 HTTP 服务器插件接口，处理 Login、NewProxy 和 CloseProxy。
+// 不订阅 Ping：frpc 每次心跳都会触发一次插件调用，而轮询已能发现服务器重启后未知的连接。
+// 响应中 reject 为 true 时 frps 会拒绝该操作；unchange 为 true 表示不修改请求内容。
+func (s *Server) handleFrpsPlugin(c *gin.Context) {
+	var req frpsPluginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	allow := frpsPluginResponse{Unchange: true}
+
+	switch req.Op {
+	case "Login":
+		var content frpsLoginContent
+		if err := json.Unmarshal(req.Content, &content); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid login content"})
+			return
+		}
+		if err := s.discovery.ClientLogin(content.RunID, content.Metas); err != nil {
+			c.JSON(http.StatusOK, frpsPluginResponse{Reject: true, RejectReason: err.Error()})
+			return
+		}
+		log.Printf("frpc %s logged in from %s (run_id %s)", content.Hostname, content.ClientAddress, content.RunID)
+
+	case "NewProxy":
+		var content frpsNewProxyContent
+		if err := json.Unmarshal(req.Content, &content); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid new proxy content"})
+			return
+		}
+		if err := s.discovery.ProxyOpened(content.User.RunID, content.User.Metas, content.ProxyName, content.RemotePort); err != nil {
+			c.JSON(http.StatusOK, frpsPluginResponse{Reject: true, RejectReason: err.Error()})
+			return
+		}
+
+	case "CloseProxy":
+		var content frpsCloseProxyContent
+		if err := json.Unmarshal(req.Content, &content); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid close proxy content"})
+			return
+		}
+		s.discovery.ProxyClosed(content.User.RunID, content.ProxyName)
+
+	default:
+		// 未订阅的操作一律放行，避免 frps 升级后新增的操作被意外拒绝。
+	}
+
+	c.JSON(http.StatusOK, allow)
+}
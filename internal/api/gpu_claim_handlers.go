@@ -1,9 +1,13 @@
 package api
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
+	"utopia-server/internal/client"
+	"utopia-server/internal/controller"
 	"utopia-server/internal/models"
 
 	"github.com/gin-gonic/gin"
@@ -35,6 +39,11 @@ func (s *Server) handleCreateGpuClaim(c *gin.Context) {
 		return
 	}
 
+	if spec.MinCudaVersion != "" && !models.IsValidVersion(spec.MinCudaVersion) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "minCudaVersion must be a dotted version such as 12.1"})
+		return
+	}
+
 	// Create and populate the claim object
 	claim := &models.GpuClaim{
 		ID:        uuid.NewString(),
@@ -53,3 +62,183 @@ func (s *Server) handleCreateGpuClaim(c *gin.Context) {
 
 	c.JSON(http.StatusAccepted, claim)
 }
+
+// handleListGpuClaims lists the caller's claims, newest first. Admins see every claim.
+func (s *Server) handleListGpuClaims(c *gin.Context) {
+	userID := c.MustGet("user").(*models.User).Username
+	if isAdmin(c) {
+		userID = ""
+	}
+
+	claims, err := s.GpuClaimStore.ListGpuClaims(userID)
+	if err != nil {
+		log.Printf("Error listing GPU claims: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list gpu claims"})
+		return
+	}
+	c.JSON(http.StatusOK, claims)
+}
+
+func (s *Server) handleGetGpuClaim(c *gin.Context) {
+	claim, ok := s.claimForRequest(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, claim)
+}
+
+// handleGetGpuClaimContainer returns the live state of the claim's container from the node agent.
+func (s *Server) handleGetGpuClaimContainer(c *gin.Context) {
+	claim, ok := s.claimForRequest(c)
+	if !ok {
+		return
+	}
+	targetNode, ok := s.claimNode(c, claim)
+	if !ok {
+		return
+	}
+
+	info, err := s.agentClient.InspectContainer(c.Request.Context(), targetNode, claim.Status.ContainerID)
+	if err != nil {
+		respondAgentError(c, err, "failed to inspect container")
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// handleGetGpuClaimStats returns the resource usage of the claim's container.
+func (s *Server) handleGetGpuClaimStats(c *gin.Context) {
+	claim, ok := s.claimForRequest(c)
+	if !ok {
+		return
+	}
+	targetNode, ok := s.claimNode(c, claim)
+	if !ok {
+		return
+	}
+
+	stats, err := s.agentClient.ContainerStats(c.Request.Context(), targetNode, claim.Status.ContainerID)
+	if err != nil {
+		respondAgentError(c, err, "failed to get container stats")
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// handleRestartGpuClaim restarts the container of a Running claim. Completed
+// and Failed claims have released their GPUs to the scheduler, so restarting
+// their containers could double-book the GPUs; submit a new claim instead.
+func (s *Server) handleRestartGpuClaim(c *gin.Context) {
+	claim, ok := s.claimForRequest(c)
+	if !ok {
+		return
+	}
+	if claim.Status.Phase != models.GpuClaimPhaseRunning {
+		c.JSON(http.StatusConflict, gin.H{"error": "gpu claim in phase " + string(claim.Status.Phase) + " cannot be restarted"})
+		return
+	}
+	targetNode, ok := s.claimNode(c, claim)
+	if !ok {
+		return
+	}
+
+	if err := s.agentClient.RestartContainer(c.Request.Context(), targetNode, claim.Status.ContainerID); err != nil {
+		respondAgentError(c, err, "failed to restart container")
+		return
+	}
+
+	c.JSON(http.StatusOK, claim)
+}
+
+// handleDeleteGpuClaim deletes a claim. A claim without a container is deleted
+// at once (204); otherwise it becomes Terminating and the controller stops and
+// removes the container before deleting the claim (202).
+func (s *Server) handleDeleteGpuClaim(c *gin.Context) {
+	claim, ok := s.claimForRequest(c)
+	if !ok {
+		return
+	}
+
+	if claim.Status.ContainerID == "" {
+		if err := s.GpuClaimStore.DeleteGpuClaim(claim.ID); err != nil && !errors.Is(err, controller.ErrGpuClaimNotFound) {
+			log.Printf("Error deleting GPU claim %s: %v", claim.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete gpu claim"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if claim.Status.Phase != models.GpuClaimPhaseTerminating {
+		claim.Status.Phase = models.GpuClaimPhaseTerminating
+		if err := s.GpuClaimStore.Update(claim); err != nil {
+			log.Printf("Error marking GPU claim %s as terminating: %v", claim.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete gpu claim"})
+			return
+		}
+	}
+	c.JSON(http.StatusAccepted, claim)
+}
+
+// claimForRequest loads the claim named by the :id path parameter. Claims of
+// other users are reported as not found unless the caller is an admin.
+func (s *Server) claimForRequest(c *gin.Context) (*models.GpuClaim, bool) {
+	claim, err := s.GpuClaimStore.GetGpuClaim(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, controller.ErrGpuClaimNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "gpu claim not found"})
+			return nil, false
+		}
+		log.Printf("Error getting GPU claim %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get gpu claim"})
+		return nil, false
+	}
+
+	user := c.MustGet("user").(*models.User)
+	if claim.UserID != user.Username && !isAdmin(c) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "gpu claim not found"})
+		return nil, false
+	}
+	return claim, true
+}
+
+// claimNode returns the online node running the claim's container.
+func (s *Server) claimNode(c *gin.Context, claim *models.GpuClaim) (*models.Node, bool) {
+	if claim.Status.ContainerID == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "gpu claim has no container"})
+		return nil, false
+	}
+	targetNode, err := s.nodeService.GetNode(claim.Status.NodeName)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "node of gpu claim no longer exists"})
+		return nil, false
+	}
+	if targetNode.Status != models.NodeStatusOnline || targetNode.Endpoint() == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Node is not online"})
+		return nil, false
+	}
+	return targetNode, true
+}
+
+// isAdmin reports whether the authenticated user holds the admin role.
+func isAdmin(c *gin.Context) bool {
+	role, ok := c.Value("role").(*models.Role)
+	return ok && role.Name == models.RoleAdmin
+}
+
+// respondAgentError maps an agent error to a response: 503 when the agent is
+// unreachable, 404 when it does not know the container, 501 when its API
+// version lacks the call (v0 agents), 502 otherwise.
+func respondAgentError(c *gin.Context, err error, message string) {
+	switch {
+	case client.IsUnreachable(err):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "node agent is unreachable"})
+	case client.IsNotFound(err):
+		c.JSON(http.StatusNotFound, gin.H{"error": "container not found on node"})
+	case errors.Is(err, client.ErrUnsupportedOperation):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "node agent does not support this operation"})
+	default:
+		log.Printf("%s: %v", message, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": message})
+	}
+}
@@ -15,8 +15,10 @@ import (
 	"utopia-server/internal/config"
 	"utopia-server/internal/controller"
 	"utopia-server/internal/database"
+	"utopia-server/internal/history"
 	"utopia-server/internal/models"
 	"utopia-server/internal/node"
+	"utopia-server/internal/secrets"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -91,9 +93,10 @@ func TestGpuClaimAPI(t *testing.T) {
 	gpuClaimStore := controller.NewMySQLStore(testDB)
 	nodeStore := node.NewMySQLStore(testDB)
 	authService := auth.NewService(authStore, cfg)
-	nodeService := node.NewService(nodeStore)
-	agentClient := client.NewAgentClient(cfg.FRP)
-	server := NewServer(cfg.Server, authService, nodeService, gpuClaimStore, agentClient)
+	nodeService := node.NewService(nodeStore, nil, nil, 0)
+	agentClient := client.NewAgentClient(cfg.Agent, secrets.NewAgentAuthenticator(nil, cfg.FRP.AgentToken), nil, nil)
+	historyService := history.NewService(history.NewMySQLStore(testDB), cfg.History)
+	server := NewServer(cfg.Server, authService, nodeService, gpuClaimStore, agentClient, historyService, nil, nil, nil, nil, nil, "")
 
 	// Start a test server
 	testServer := httptest.NewServer(server.Router)
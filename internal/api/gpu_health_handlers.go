@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"utopia-server/internal/models"
+	"utopia-server/internal/node"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleAdminListGpuHealth lists the server-side health state of GPUs. The
+// optional node and state query parameters narrow the result, e.g.
+// ?state=Quarantined.
+func (s *Server) handleAdminListGpuHealth(c *gin.Context) {
+	nodeID := c.Query("node")
+	if nodeID != "" {
+		existing, err := s.nodeService.GetNode(nodeID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+			return
+		}
+		nodeID = existing.ID
+	}
+
+	state := c.Query("state")
+	switch state {
+	case "", models.GpuHealthHealthy, models.GpuHealthSuspect, models.GpuHealthQuarantined:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "state must be Healthy, Suspect or Quarantined"})
+		return
+	}
+
+	states, err := s.nodeService.ListGpuHealth(nodeID)
+	if err != nil {
+		log.Printf("Error listing GPU health: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list gpu health"})
+		return
+	}
+
+	filtered := make([]*models.GpuHealth, 0, len(states))
+	for _, gpu := range states {
+		if state == "" || gpu.State == state {
+			filtered = append(filtered, gpu)
+		}
+	}
+	c.JSON(http.StatusOK, filtered)
+}
+
+// handleAdminClearGpuQuarantine returns a quarantined (or suspect) GPU to
+// Healthy so it can be scheduled again.
+func (s *Server) handleAdminClearGpuQuarantine(c *gin.Context) {
+	id, ok := parseNodeID(c)
+	if !ok {
+		return
+	}
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid gpu index"})
+		return
+	}
+
+	existing, err := s.nodeService.GetNode(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		return
+	}
+
+	cleared, err := s.health.ClearGpuQuarantine(existing.ID, index)
+	if err != nil {
+		if errors.Is(err, node.ErrGpuHealthNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error clearing quarantine of GPU %d on node %s: %v", index, existing.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to clear gpu quarantine"})
+		return
+	}
+
+	c.JSON(http.StatusOK, cleared)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"utopia-server/internal/history"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultHistoryWindow 是未指定 from 时查询的时间跨度。
+const defaultHistoryWindow = time.Hour
+
+// handleGetNodeMetricsHistory 返回节点的指标历史。
+// 查询参数 from、to 接受 RFC3339 时间或 Unix 秒，默认为最近一小时；step 为分桶秒数，缺省时自动选择。
+func (s *Server) handleGetNodeMetricsHistory(c *gin.Context) {
+	id, ok := parseNodeID(c)
+	if !ok {
+		return
+	}
+
+	to := time.Now()
+	if raw := c.Query("to"); raw != "" {
+		t, err := parseTimeParam(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultHistoryWindow)
+	if raw := c.Query("from"); raw != "" {
+		t, err := parseTimeParam(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+			return
+		}
+		from = t
+	}
+	step := 0
+	if raw := c.Query("step"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "step must be a positive number of seconds"})
+			return
+		}
+		step = n
+	}
+
+	node, err := s.nodeService.GetNode(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		return
+	}
+
+	result, err := s.history.Query(node.ID, from, to, step)
+	if err != nil {
+		if errors.Is(err, history.ErrInvalidRange) || errors.Is(err, history.ErrTooManyPoints) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error querying metrics history for node %s: %v", node.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query metrics history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// parseTimeParam 解析 RFC3339 时间或 Unix 秒。
+func parseTimeParam(raw string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
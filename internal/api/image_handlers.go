@@ -0,0 +1,105 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"utopia-server/internal/controller"
+	"utopia-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PrepullRequest struct {
+	Image string `json:"image" binding:"required"`
+	// NodeIDs selects nodes explicitly. When empty, every online node whose
+	// labels include Labels is selected.
+	NodeIDs []string          `json:"node_ids"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// handleAdminStartPrepull starts a job that pulls an image on the selected
+// nodes ahead of time. The pulls run in the background; poll the job for progress.
+func (s *Server) handleAdminStartPrepull(c *gin.Context) {
+	var req PrepullRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	var nodes []*models.Node
+	if len(req.NodeIDs) > 0 {
+		for _, ref := range req.NodeIDs {
+			n, err := s.nodeService.GetNode(ref)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("node %s not found", ref)})
+				return
+			}
+			nodes = append(nodes, n)
+		}
+	} else {
+		all, err := s.nodeService.ListNodes()
+		if err != nil {
+			log.Printf("Error listing nodes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list nodes"})
+			return
+		}
+		for _, n := range all {
+			if n.Status == models.NodeStatusOnline && hasLabels(n, req.Labels) {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	if len(nodes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no nodes selected"})
+		return
+	}
+
+	createdBy := ""
+	if user, ok := c.MustGet("user").(*models.User); ok {
+		createdBy = user.Username
+	}
+	job, err := s.prepuller.Start(req.Image, createdBy, nodes)
+	if err != nil {
+		log.Printf("Error starting prepull of %s: %v", req.Image, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start prepull job"})
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (s *Server) handleAdminListPrepulls(c *gin.Context) {
+	jobs, err := s.prepuller.ListJobs(c.Query("status"))
+	if err != nil {
+		log.Printf("Error listing prepull jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list prepull jobs"})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+func (s *Server) handleAdminGetPrepull(c *gin.Context) {
+	job, err := s.prepuller.GetJob(c.Param("id"))
+	if errors.Is(err, controller.ErrPrepullJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "prepull job not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting prepull job %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get prepull job"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// hasLabels reports whether every key/value pair of selector is set on the node.
+func hasLabels(n *models.Node, selector map[string]string) bool {
+	for key, value := range selector {
+		if n.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"utopia-server/internal/node"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleAdminImportInventory imports a YAML node inventory. The inventory is
+// taken from the request body, or re-read from inventory.path when the body is
+// empty.
+func (s *Server) handleAdminImportInventory(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+		return
+	}
+
+	var inventory *node.Inventory
+	if len(body) > 0 {
+		inventory, err = node.ParseInventory(body)
+	} else if s.inventoryPath != "" {
+		inventory, err = node.LoadInventory(s.inventoryPath)
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "request body is empty and no inventory path is configured"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := s.nodeService.ImportInventory(inventory)
+	if err != nil {
+		log.Printf("Error importing node inventory: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to import inventory"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// handleAdminInventoryReport lists inventory nodes that never came online or
+// whose GPU count does not match the inventory.
+func (s *Server) handleAdminInventoryReport(c *gin.Context) {
+	report, err := s.nodeService.InventoryReport()
+	if err != nil {
+		log.Printf("Error building inventory report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list nodes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
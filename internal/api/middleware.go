@@ -95,9 +95,61 @@ func (s *Server) RBACMiddleware() gin.HandlerFunc {
 			}
 		}
 
+		if !role.Policies.AllowsImage(spec.Image) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied: image registry is not allowed for your role"})
+			return
+		}
+
 		// Pass the parsed spec to the handler via context
 		c.Set("spec", &spec)
 
 		c.Next()
 	}
 }
+
+// AdminMiddleware only lets users holding the admin role through.
+// It must run after AuthMiddleware.
+func (s *Server) AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roleVal, exists := c.Get("role")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "role not found in context"})
+			return
+		}
+		role, ok := roleVal.(*models.Role)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "invalid role type in context"})
+			return
+		}
+
+		if role.Name != models.RoleAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied: admin role required"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// NodeAuthMiddleware authenticates node agents by the per-node credential
+// issued at registration. The node ID is taken from the :id path parameter
+// and the authenticated node is stored in the context under "node".
+func (s *Server) NodeAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		credential := strings.TrimPrefix(authHeader, "Bearer ")
+		if authHeader == "" || credential == authHeader {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "node credential required"})
+			return
+		}
+
+		node, err := s.nodeService.AuthenticateNode(c.Param("id"), credential)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid node credential"})
+			return
+		}
+
+		c.Set("node", node)
+		c.Next()
+	}
+}
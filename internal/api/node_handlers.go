@@ -1,17 +1,36 @@
 package api
 
 import (
+	"errors"
 	"log"
 	"net/http"
-	"strconv"
+	"strings"
 	"utopia-server/internal/models"
+	"utopia-server/internal/node"
+	"utopia-server/internal/pki"
 
 	"github.com/gin-gonic/gin"
 )
 
+// handleNodeRegister 注册一个节点。
+// 请求必须携带管理员签发的注册令牌：Authorization: Bearer <bootstrap token>。
+// 携带的机器标识与已有节点匹配、且出示了该节点当前的凭据时，返回该节点（200）而不是新建节点（201）。
 func (s *Server) handleNodeRegister(c *gin.Context) {
+	bootstrapToken := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if bootstrapToken == "" || bootstrapToken == c.GetHeader("Authorization") {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "bootstrap token required"})
+		return
+	}
+
 	var req struct {
-		Hostname string `json:"hostname"`
+		Hostname  string                `json:"hostname"`
+		MachineID string                `json:"machine_id"`
+		GpuUUIDs  []string              `json:"gpu_uuids"`
+		Address   string                `json:"address"`   // 可选的直连地址 host:port
+		Inventory *models.NodeInventory `json:"inventory"` // 可选的软硬件清单
+		CSR       string                `json:"csr"`       // 可选的 PEM 证书签名请求，用于双向 TLS
+		// NodeToken 是重新注册时出示的当前节点凭据，机器标识匹配的节点只有在凭据正确时才会被复用。
+		NodeToken string `json:"node_token"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -24,21 +43,121 @@ func (s *Server) handleNodeRegister(c *gin.Context) {
 		return
 	}
 
-	newNode, err := s.nodeService.CreateNode(req.Hostname)
+	if req.Address != "" && !node.IsValidAddress(req.Address) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "address must be in host:port form"})
+		return
+	}
+
+	newNode, creds, created, err := s.nodeService.RegisterNode(bootstrapToken, node.Registration{
+		Hostname:  req.Hostname,
+		MachineID: node.MachineIdentity(req.MachineID, req.GpuUUIDs),
+		Address:   req.Address,
+		Inventory: req.Inventory,
+		CSR:       []byte(req.CSR),
+		NodeToken: req.NodeToken,
+	})
 	if err != nil {
+		if errors.Is(err, node.ErrInvalidBootstrapToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, node.ErrCertificatesDisabled) || errors.Is(err, pki.ErrInvalidCSR) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		log.Printf("Error creating node: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create node"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"node_id": newNode.ID})
+	status := http.StatusCreated
+	if !created {
+		status = http.StatusOK
+	}
+	c.JSON(status, s.credentialsResponse(newNode, creds))
 }
 
-func (s *Server) handleGetNodeStatus(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+// credentialsResponse 是注册和轮换凭据时返回给节点的响应体。agent_secret 只在服务器配置了主密钥时出现。
+func (s *Server) credentialsResponse(n *models.Node, creds node.Credentials) gin.H {
+	resp := gin.H{"node_id": n.ID, "node_token": creds.NodeToken, "api_version": negotiatedAgentAPI(n)}
+	if creds.AgentSecret != "" {
+		resp["agent_secret"] = creds.AgentSecret
+	}
+	if creds.Certificate != nil {
+		s.addCertificate(resp, creds.Certificate)
+	}
+	return resp
+}
+
+// addCertificate 把签发给节点的证书、CA 证书和建议的续签时间加入响应体。
+func (s *Server) addCertificate(resp gin.H, cert *pki.Certificate) {
+	resp["certificate"] = string(cert.PEM)
+	resp["ca_certificate"] = string(s.nodeService.CACertificate())
+	resp["certificate_expires_at"] = cert.NotAfter
+	resp["certificate_renew_after"] = cert.RenewAfter()
+}
+
+// handleNodeRenewCertificate 为节点重新签发双向 TLS 证书。节点由 NodeAuthMiddleware 认证，
+// agent 应在上次响应中的 certificate_renew_after 之后调用。
+func (s *Server) handleNodeRenewCertificate(c *gin.Context) {
+	var req struct {
+		CSR string `json:"csr" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	authenticated := c.MustGet("node").(*models.Node)
+	renewed, cert, err := s.nodeService.RenewCertificate(authenticated.ID, []byte(req.CSR))
+	if err != nil {
+		if errors.Is(err, node.ErrCertificatesDisabled) || errors.Is(err, pki.ErrInvalidCSR) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error renewing certificate of node %s: %v", authenticated.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to renew certificate"})
+		return
+	}
+	log.Printf("Renewed certificate of node %s (%s), serial %s", renewed.Hostname, renewed.ID, cert.Serial)
+
+	resp := gin.H{"node_id": renewed.ID}
+	s.addCertificate(resp, cert)
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleNodeHeartbeat 接收 agent 主动上报的指标。节点由 NodeAuthMiddleware 认证。
+func (s *Server) handleNodeHeartbeat(c *gin.Context) {
+	var metrics models.NodeMetrics
+	if err := c.ShouldBindJSON(&metrics); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	authenticated := c.MustGet("node").(*models.Node)
+	updated, err := s.health.ReportHeartbeat(authenticated, &metrics)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		log.Printf("Error recording heartbeat for node %s: %v", authenticated.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record heartbeat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": updated.Status, "api_version": negotiatedAgentAPI(updated)})
+}
+
+// negotiatedAgentAPI 返回与节点 agent 协商出的 API 版本，没有共同版本时返回空字符串并记录警告。
+func negotiatedAgentAPI(n *models.Node) string {
+	version, ok := n.AgentAPI()
+	if !ok {
+		log.Printf("Node %s (%s) agent supports API versions %v, none of which the server supports (%v); it will not be scheduled",
+			n.Hostname, n.ID, n.Inventory.APIVersions, models.SupportedAgentAPIs)
+	}
+	return version
+}
+
+func (s *Server) handleGetNodeStatus(c *gin.Context) {
+	id, ok := parseNodeID(c)
+	if !ok {
 		return
 	}
 
@@ -48,14 +167,14 @@ func (s *Server) handleGetNodeStatus(c *gin.Context) {
 		return
 	}
 
-	if node.Status != models.NodeStatusOnline || node.ControlPort == 0 {
+	if node.Status != models.NodeStatusOnline || node.Endpoint() == "" {
 		c.JSON(http.StatusConflict, gin.H{"error": "Node is not online"})
 		return
 	}
 
-	metrics, err := s.agentClient.GetNodeMetrics(node)
+	metrics, err := s.agentClient.GetNodeMetrics(c.Request.Context(), node)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to get node metrics from agent"})
+		respondAgentError(c, err, "failed to get node metrics from agent")
 		return
 	}
 
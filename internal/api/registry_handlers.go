@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"utopia-server/internal/auth"
+	"utopia-server/internal/registry"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateRegistryCredentialRequest struct {
+	Registry string `json:"registry" binding:"required"`
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type UpdateRegistryCredentialRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type SetAllowedRegistriesRequest struct {
+	// AllowedRegistries replaces the role's allowlist; null removes it.
+	AllowedRegistries []string `json:"allowed_registries"`
+}
+
+func (s *Server) handleAdminListRegistryCredentials(c *gin.Context) {
+	creds, err := s.registry.ListCredentials()
+	if err != nil {
+		log.Printf("Error listing registry credentials: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list registry credentials"})
+		return
+	}
+	c.JSON(http.StatusOK, creds)
+}
+
+func (s *Server) handleAdminCreateRegistryCredential(c *gin.Context) {
+	var req CreateRegistryCredentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	cred, err := s.registry.CreateCredential(req.Registry, req.Username, req.Password)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, cred)
+	case errors.Is(err, registry.ErrInvalidRegistry), errors.Is(err, registry.ErrNoSecretsKey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, registry.ErrCredentialExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error creating registry credential for %s: %v", req.Registry, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create registry credential"})
+	}
+}
+
+func (s *Server) handleAdminUpdateRegistryCredential(c *gin.Context) {
+	var req UpdateRegistryCredentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	cred, err := s.registry.UpdateCredential(c.Param("id"), req.Username, req.Password)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, cred)
+	case errors.Is(err, registry.ErrCredentialNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "registry credential not found"})
+	case errors.Is(err, registry.ErrNoSecretsKey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error updating registry credential %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update registry credential"})
+	}
+}
+
+func (s *Server) handleAdminDeleteRegistryCredential(c *gin.Context) {
+	err := s.registry.DeleteCredential(c.Param("id"))
+	if errors.Is(err, registry.ErrCredentialNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "registry credential not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting registry credential %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete registry credential"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminGetRole(c *gin.Context) {
+	role, err := s.authService.GetRoleByName(c.Param("name"))
+	if errors.Is(err, auth.ErrRoleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "role not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting role %s: %v", c.Param("name"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get role"})
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// handleAdminSetAllowedRegistries replaces the registries a role may pull
+// GpuClaim images from. Roles with allow_all are not restricted.
+func (s *Server) handleAdminSetAllowedRegistries(c *gin.Context) {
+	var req SetAllowedRegistriesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	role, err := s.authService.SetAllowedRegistries(c.Param("name"), req.AllowedRegistries)
+	if errors.Is(err, auth.ErrRoleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "role not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating allowed registries of role %s: %v", c.Param("name"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update role"})
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
@@ -7,7 +7,10 @@ import (
 	"utopia-server/internal/client"
 	"utopia-server/internal/config"
 	"utopia-server/internal/controller"
+	"utopia-server/internal/history"
 	"utopia-server/internal/node"
+	"utopia-server/internal/registry"
+	"utopia-server/internal/tunnel"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,11 +22,18 @@ type Server struct {
 	authService   *auth.Service
 	nodeService   *node.Service
 	GpuClaimStore controller.GpuClaimStore
-	agentClient   *client.AgentClient
+	agentClient   client.Agent
+	history       *history.Service
+	discovery     *node.DiscoveryService
+	health        *node.HealthCheckService
+	containerGC   *controller.ContainerGC
+	prepuller     *controller.Prepuller
+	registry      *registry.Service
+	inventoryPath string
 }
 
 // NewServer creates a new API server.
-func NewServer(config config.ServerConfig, authService *auth.Service, nodeService *node.Service, gpuClaimStore controller.GpuClaimStore, agentClient *client.AgentClient) *Server {
+func NewServer(config config.ServerConfig, authService *auth.Service, nodeService *node.Service, gpuClaimStore controller.GpuClaimStore, agentClient client.Agent, historyService *history.Service, discoveryService *node.DiscoveryService, healthService *node.HealthCheckService, containerGC *controller.ContainerGC, prepuller *controller.Prepuller, registryService *registry.Service, inventoryPath string) *Server {
 	router := gin.Default() // gin.Default() includes Logger and Recovery middleware.
 
 	server := &Server{
@@ -33,6 +43,13 @@ func NewServer(config config.ServerConfig, authService *auth.Service, nodeServic
 		nodeService:   nodeService,
 		GpuClaimStore: gpuClaimStore,
 		agentClient:   agentClient,
+		history:       historyService,
+		discovery:     discoveryService,
+		health:        healthService,
+		containerGC:   containerGC,
+		prepuller:     prepuller,
+		registry:      registryService,
+		inventoryPath: inventoryPath,
 	}
 
 	router.Static("/ui", "./web/ui")
@@ -40,6 +57,8 @@ func NewServer(config config.ServerConfig, authService *auth.Service, nodeServic
 		c.Redirect(http.StatusMovedPermanently, "/ui")
 	})
 
+	router.POST(tunnel.PluginPath, server.LoopbackOnlyMiddleware(), server.handleFrpsPlugin)
+
 	server.setupRoutes()
 
 	return server
@@ -57,20 +76,54 @@ func (s *Server) setupRoutes() {
 	gpuClaims := api.Group("/gpu-claims")
 	gpuClaims.Use(s.AuthMiddleware()) // Protect this group
 	gpuClaims.POST("", s.RBACMiddleware(), s.handleCreateGpuClaim)
+	gpuClaims.GET("", s.handleListGpuClaims)
+	gpuClaims.GET("/:id", s.handleGetGpuClaim)
+	gpuClaims.GET("/:id/container", s.handleGetGpuClaimContainer)
+	gpuClaims.GET("/:id/stats", s.handleGetGpuClaimStats)
+	gpuClaims.POST("/:id/restart", s.handleRestartGpuClaim)
+	gpuClaims.DELETE("/:id", s.handleDeleteGpuClaim)
 
 	// Node routes
 	nodes := api.Group("/nodes")
-	nodes.POST("/register", s.handleNodeRegister) // No auth middleware
+	nodes.POST("/register", s.handleNodeRegister) // Authenticated by bootstrap token
 	nodes.GET("/ping", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "pong"})
 	})
 	nodes.GET("/:id/status", s.AuthMiddleware(), s.handleGetNodeStatus)
+	nodes.GET("/:id/metrics", s.AuthMiddleware(), s.AdminMiddleware(), s.handleGetNodeMetricsHistory)
+	nodes.POST("/:id/heartbeat", s.NodeAuthMiddleware(), s.handleNodeHeartbeat)          // Authenticated by node credential
+	nodes.POST("/:id/certificate", s.NodeAuthMiddleware(), s.handleNodeRenewCertificate) // Authenticated by node credential
 
 	// Admin routes
 	admin := api.Group("/admin")
+	admin.Use(s.AuthMiddleware(), s.AdminMiddleware())
 	admin.GET("/ping", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "pong"})
 	})
+	admin.GET("/nodes", s.handleAdminListNodes)
+	admin.POST("/nodes/gc", s.handleAdminGarbageCollectNodes)
+	admin.GET("/nodes/:id", s.handleAdminGetNode)
+	admin.PATCH("/nodes/:id", s.handleAdminUpdateNode)
+	admin.DELETE("/nodes/:id", s.handleAdminDeleteNode)
+	admin.POST("/nodes/:id/merge", s.handleAdminMergeNodes)
+	admin.POST("/nodes/:id/credentials/rotate", s.handleAdminRotateNodeCredentials)
+	admin.POST("/nodes/:id/gpus/:index/unquarantine", s.handleAdminClearGpuQuarantine)
+	admin.GET("/gpus", s.handleAdminListGpuHealth)
+	admin.GET("/containers/orphans", s.handleAdminOrphanContainers)
+	admin.POST("/images/prepull", s.handleAdminStartPrepull)
+	admin.GET("/images/prepull", s.handleAdminListPrepulls)
+	admin.GET("/images/prepull/:id", s.handleAdminGetPrepull)
+	admin.GET("/registry-credentials", s.handleAdminListRegistryCredentials)
+	admin.POST("/registry-credentials", s.handleAdminCreateRegistryCredential)
+	admin.PUT("/registry-credentials/:id", s.handleAdminUpdateRegistryCredential)
+	admin.DELETE("/registry-credentials/:id", s.handleAdminDeleteRegistryCredential)
+	admin.GET("/roles/:name", s.handleAdminGetRole)
+	admin.PUT("/roles/:name/allowed-registries", s.handleAdminSetAllowedRegistries)
+	admin.POST("/inventory", s.handleAdminImportInventory)
+	admin.GET("/inventory", s.handleAdminInventoryReport)
+	admin.POST("/bootstrap-tokens", s.handleCreateBootstrapToken)
+	admin.GET("/bootstrap-tokens", s.handleListBootstrapTokens)
+	admin.DELETE("/bootstrap-tokens/:id", s.handleRevokeBootstrapToken)
 }
 
 // Run starts the API server.
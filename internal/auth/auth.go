@@ -79,6 +79,37 @@ func (s *Service) GetUserWithRole(username string) (*models.User, *models.Role,
 	return s.store.GetUserWithRole(username)
 }
 
+// GetRoleByName retrieves a role by name.
+func (s *Service) GetRoleByName(name string) (*models.Role, error) {
+	return s.store.GetRoleByName(name)
+}
+
+// SetAllowedRegistries replaces the allowed_registries policy of a role and
+// returns the updated role. A nil patterns removes the policy, so the role may
+// use images from any registry; an empty list allows none.
+func (s *Service) SetAllowedRegistries(roleName string, patterns []string) (*models.Role, error) {
+	role, err := s.store.GetRoleByName(roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make(models.Policies, len(role.Policies)+1)
+	for key, value := range role.Policies {
+		policies[key] = value
+	}
+	if patterns == nil {
+		delete(policies, models.PolicyAllowedRegistries)
+	} else {
+		policies[models.PolicyAllowedRegistries] = patterns
+	}
+
+	if err := s.store.UpdateRolePolicies(roleName, policies); err != nil {
+		return nil, err
+	}
+	role.Policies = policies
+	return role, nil
+}
+
 // CheckPassword checks if the provided password is correct for the user.
 func (s *Service) CheckPassword(user *models.User, password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
@@ -71,7 +71,7 @@ func (s *mysqlStore) GetRoleByName(name string) (*models.Role, error) {
 	err := row.Scan(&role.ID, &role.Name, &policies)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("role not found")
+			return nil, ErrRoleNotFound
 		}
 		return nil, fmt.Errorf("failed to get role by name: %w", err)
 	}
@@ -83,3 +83,17 @@ func (s *mysqlStore) GetRoleByName(name string) (*models.Role, error) {
 
 	return &role, nil
 }
+
+func (s *mysqlStore) UpdateRolePolicies(name string, policies models.Policies) error {
+	result, err := s.db.Exec("UPDATE roles SET policies = ? WHERE name = ?", policies, name)
+	if err != nil {
+		return fmt.Errorf("failed to update role policies: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		// MySQL reports no affected rows when the policies did not change, so check the role exists.
+		if _, err := s.GetRoleByName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
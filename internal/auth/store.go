@@ -7,25 +7,35 @@ import (
 	"utopia-server/internal/models"
 )
 
+// ErrRoleNotFound is returned when a role does not exist.
+var ErrRoleNotFound = errors.New("role not found")
+
 // Store defines the interface for user data storage.
 type Store interface {
 	CreateUser(user *models.User) error
 	GetUserByUsername(username string) (*models.User, error)
 	GetUserWithRole(username string) (*models.User, *models.Role, error)
 	GetRoleByName(name string) (*models.Role, error)
+	// UpdateRolePolicies replaces the policies of the named role.
+	UpdateRolePolicies(name string, policies models.Policies) error
 }
 
 // memStore is an in-memory implementation of the Store interface for testing.
 type memStore struct {
 	mu        sync.RWMutex
 	users     map[string]*models.User
+	roles     map[string]*models.Role
 	idCounter int
 }
 
 // NewMemStore creates a new in-memory store.
 func NewMemStore() Store {
 	return &memStore{
-		users:     make(map[string]*models.User),
+		users: make(map[string]*models.User),
+		roles: map[string]*models.Role{
+			"admin":     {ID: 1, Name: "admin"},
+			"developer": {ID: 2, Name: "developer"},
+		},
 		idCounter: 1,
 	}
 }
@@ -57,25 +67,43 @@ func (s *memStore) GetUserByUsername(username string) (*models.User, error) {
 }
 
 // GetUserWithRole retrieves a user and their role from the in-memory store.
-// NOTE: This is a simplified implementation for testing and does not populate the role.
+// Users whose RoleID matches no known role get a role with only the ID set.
 func (s *memStore) GetUserWithRole(username string) (*models.User, *models.Role, error) {
 	user, err := s.GetUserByUsername(username)
 	if err != nil {
 		return nil, nil, err
 	}
-	// In-memory store doesn't have a concept of roles, return a dummy one.
+	for _, name := range []string{models.RoleAdmin, "developer"} {
+		if role, err := s.GetRoleByName(name); err == nil && role.ID == user.RoleID {
+			return user, role, nil
+		}
+	}
 	return user, &models.Role{ID: user.RoleID}, nil
 }
 
 // GetRoleByName retrieves a role by name from the in-memory store.
-// NOTE: This is a simplified implementation for testing.
+// NOTE: This is a simplified implementation for testing; only the admin and developer roles exist.
 func (s *memStore) GetRoleByName(name string) (*models.Role, error) {
-	// In-memory store doesn't have a concept of roles, return a dummy one.
-	if name == "developer" {
-		return &models.Role{ID: 2, Name: "developer"}, nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	role, exists := s.roles[name]
+	if !exists {
+		return nil, ErrRoleNotFound
 	}
-	if name == "admin" {
-		return &models.Role{ID: 1, Name: "admin"}, nil
+	copied := *role
+	return &copied, nil
+}
+
+// UpdateRolePolicies replaces the policies of a role in the in-memory store.
+func (s *memStore) UpdateRolePolicies(name string, policies models.Policies) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	role, exists := s.roles[name]
+	if !exists {
+		return ErrRoleNotFound
 	}
-	return nil, errors.New("role not found")
+	role.Policies = policies
+	return nil
 }
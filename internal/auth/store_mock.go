@@ -49,3 +49,9 @@ func (m *MockStore) GetRoleByName(name string) (*models.Role, error) {
 	}
 	return role, args.Error(1)
 }
+
+// UpdateRolePolicies mocks the UpdateRolePolicies method.
+func (m *MockStore) UpdateRolePolicies(name string, policies models.Policies) error {
+	args := m.Called(name, policies)
+	return args.Error(0)
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"utopia-server/internal/models"
+)
+
+// Agent is the server's view of a node agent. Every call takes a context so
+// callers can bound it and cancel it on shutdown.
+type Agent interface {
+	// CreateContainer starts the claim's container on the node and returns its ID.
+	CreateContainer(ctx context.Context, node *models.Node, claim *models.GpuClaim) (string, error)
+	// StopContainer stops a running container. Stopping a stopped container is not an error.
+	StopContainer(ctx context.Context, node *models.Node, containerID string) error
+	// RemoveContainer deletes a stopped container.
+	RemoveContainer(ctx context.Context, node *models.Node, containerID string) error
+	// RestartContainer stops and starts a container.
+	RestartContainer(ctx context.Context, node *models.Node, containerID string) error
+	// InspectContainer returns the current state of a container.
+	InspectContainer(ctx context.Context, node *models.Node, containerID string) (*models.ContainerInfo, error)
+	// ListContainers returns every container the agent manages, running or not.
+	ListContainers(ctx context.Context, node *models.Node) ([]models.ContainerInfo, error)
+	// ContainerStats returns the current resource usage of a running container.
+	ContainerStats(ctx context.Context, node *models.Node, containerID string) (*models.ContainerStats, error)
+	// GetNodeMetrics returns the agent's live metrics.
+	GetNodeMetrics(ctx context.Context, node *models.Node) (*models.NodeMetrics, error)
+	// PullImage starts pulling an image in the background and returns its progress.
+	// Starting a pull that is already running or done returns the existing pull.
+	PullImage(ctx context.Context, node *models.Node, image string) (*models.ImagePullStatus, error)
+	// ImagePullStatus returns the progress of a pull started by PullImage.
+	ImagePullStatus(ctx context.Context, node *models.Node, image string) (*models.ImagePullStatus, error)
+}
+
+// PullSecrets looks up the registry credentials an agent needs to pull an image.
+type PullSecrets interface {
+	// PullSecret returns nil if the image can be pulled anonymously.
+	PullSecret(image string) (*models.PullSecret, error)
+}
+
+// UnreachableError means the request did not get an answer from the agent:
+// the connection failed, timed out, or the tunnel in front of the agent
+// reported a gateway error. Idempotent calls are retried on this error.
+type UnreachableError struct {
+	Op  string
+	Err error
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("agent unreachable during %s: %v", e.Op, e.Err)
+}
+
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// RejectedError means the agent answered but refused the request.
+type RejectedError struct {
+	Op         string
+	StatusCode int
+	Message    string // response body, truncated
+}
+
+func (e *RejectedError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("agent rejected %s with status code %d", e.Op, e.StatusCode)
+	}
+	return fmt.Sprintf("agent rejected %s with status code %d: %s", e.Op, e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err means the agent does not know the container.
+func IsNotFound(err error) bool {
+	var rejected *RejectedError
+	return errors.As(err, &rejected) && rejected.StatusCode == http.StatusNotFound
+}
+
+// IsUnreachable reports whether err is, or wraps, an UnreachableError.
+func IsUnreachable(err error) bool {
+	var unreachable *UnreachableError
+	return errors.As(err, &unreachable)
+}
+
+// IsRejected reports whether err is, or wraps, a RejectedError.
+func IsRejected(err error) bool {
+	var rejected *RejectedError
+	return errors.As(err, &rejected)
+}
@@ -2,85 +2,405 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
 
 	"utopia-server/internal/config"
+	"utopia-server/internal/metrics"
 	"utopia-server/internal/models"
+	"utopia-server/internal/pki"
+	"utopia-server/internal/secrets"
 )
 
+// ErrIncompatibleAgent is returned when the agent does not speak any API
+// version supported by the server.
+var ErrIncompatibleAgent = errors.New("agent does not support any API version known to the server")
+
+// ErrUnsupportedOperation is returned when the negotiated agent API version
+// lacks the endpoint a call needs.
+var ErrUnsupportedOperation = errors.New("agent API version does not support this operation")
+
+// ErrInsecurePullSecret is returned when an image needs registry credentials
+// but the node is reached without mutual TLS, where the credentials would
+// travel in plaintext to whatever answers on the node's address.
+var ErrInsecurePullSecret = errors.New("node has no certificate; refusing to send registry credentials without mutual TLS")
+
+// agentPaths holds the agent endpoint paths of each API version. An empty
+// path means the version lacks the endpoint and calls needing it fail with
+// ErrUnsupportedOperation. v0 agents only serve POST /containers and the
+// metrics endpoint, so their containers cannot be listed, inspected or stopped.
+var agentPaths = map[string]struct {
+	create     string
+	containers string // listing and per-container calls
+	metrics    string
+	imagePull  string
+}{
+	models.AgentAPIV0: {create: "/containers", metrics: "/api/v1/metrics"},
+	models.AgentAPIV1: {create: "/api/v1/containers", containers: "/api/v1/containers", metrics: "/api/v1/metrics", imagePull: "/api/v1/images/pull"},
+}
+
+// maxErrorBody bounds how much of a rejected response is kept in RejectedError.
+const maxErrorBody = 1024
+
+// AgentClient talks to node agents over HTTP, or mutual TLS for nodes that
+// hold a certificate. It implements Agent.
 type AgentClient struct {
-	httpClient *http.Client
-	config     config.FRPConfig
+	auth        *secrets.AgentAuthenticator
+	tls         *pki.Transports
+	pullSecrets PullSecrets
+	agent       config.AgentConfig
 }
 
-func NewAgentClient(cfg config.FRPConfig) *AgentClient {
+var _ Agent = (*AgentClient)(nil)
+
+// NewAgentClient creates an AgentClient. auth signs each request with the
+// node's agent secret; if nil, requests carry no credentials. tls provides
+// the mutual TLS transports; if nil, nodes with a certificate cannot be reached.
+// pullSecrets supplies credentials for private images; if nil, every image is
+// pulled anonymously.
+func NewAgentClient(agentCfg config.AgentConfig, auth *secrets.AgentAuthenticator, tls *pki.Transports, pullSecrets PullSecrets) *AgentClient {
 	return &AgentClient{
-		httpClient: &http.Client{},
-		config:     cfg,
+		auth:        auth,
+		tls:         tls,
+		pullSecrets: pullSecrets,
+		agent:       agentCfg,
 	}
 }
 
-func (c *AgentClient) CreateContainer(node *models.Node, claim *models.GpuClaim) (string, error) {
-	url := fmt.Sprintf("http://localhost:%d/containers", node.ControlPort)
+// CreateContainer sends the claim ID as an idempotency key: an agent that
+// already created a container for the claim returns it instead of starting a
+// second one. This makes the call safe to retry when the agent is unreachable.
+func (c *AgentClient) CreateContainer(ctx context.Context, node *models.Node, claim *models.GpuClaim) (string, error) {
+	version, ok := node.AgentAPI()
+	if !ok {
+		return "", ErrIncompatibleAgent
+	}
+	url := node.Endpoint() + agentPaths[version].create
+
+	// Quarantined GPUs are passed along so the agent never places the container on them.
+	// The claim ID label lets the container garbage collector match containers to claims.
+	// Private images carry the registry credentials, if any are configured.
+	request := struct {
+		models.GpuClaimSpec
+		ExcludeGpus    []int              `json:"exclude_gpus,omitempty"`
+		Labels         map[string]string  `json:"labels"`
+		IdempotencyKey string             `json:"idempotency_key"`
+		PullSecret     *models.PullSecret `json:"pull_secret,omitempty"`
+	}{
+		GpuClaimSpec:   claim.Spec,
+		Labels:         map[string]string{models.ContainerLabelClaimID: claim.ID},
+		IdempotencyKey: claim.ID,
+	}
+	for _, gpu := range node.Gpus {
+		if gpu.Health == models.GpuHealthQuarantined {
+			request.ExcludeGpus = append(request.ExcludeGpus, gpu.ID)
+		}
+	}
+	pullSecret, err := c.pullSecret(node, claim.Spec.Image)
+	if err != nil {
+		return "", err
+	}
+	request.PullSecret = pullSecret
 
-	body, err := json.Marshal(claim.Spec)
+	body, err := json.Marshal(request)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal claim spec: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	var result struct {
+		ContainerID string `json:"container_id"`
+	}
+	err = c.retry(ctx, func() error {
+		return c.do(ctx, "create_container", node, http.MethodPost, url, body, c.agent.CreateTimeout, &result)
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return "", err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.config.AgentToken)
+	return result.ContainerID, nil
+}
 
-	resp, err := c.httpClient.Do(req)
+func (c *AgentClient) StopContainer(ctx context.Context, node *models.Node, containerID string) error {
+	url, err := containerURL(node, containerID, "/stop")
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
+	return c.retry(ctx, func() error {
+		return c.do(ctx, "stop_container", node, http.MethodPost, url, nil, c.agent.CreateTimeout, nil)
+	})
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to create container, status code: %d", resp.StatusCode)
+// RemoveContainer is retried; a retry after a lost response sees a not-found
+// error, which callers removing a container can treat as success.
+func (c *AgentClient) RemoveContainer(ctx context.Context, node *models.Node, containerID string) error {
+	url, err := containerURL(node, containerID, "")
+	if err != nil {
+		return err
 	}
+	return c.retry(ctx, func() error {
+		return c.do(ctx, "remove_container", node, http.MethodDelete, url, nil, c.agent.Timeout, nil)
+	})
+}
 
-	var result struct {
-		ContainerID string `json:"container_id"`
+// RestartContainer is not retried, so a lost response never restarts the
+// workload twice.
+func (c *AgentClient) RestartContainer(ctx context.Context, node *models.Node, containerID string) error {
+	url, err := containerURL(node, containerID, "/restart")
+	if err != nil {
+		return err
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+	return c.do(ctx, "restart_container", node, http.MethodPost, url, nil, c.agent.CreateTimeout, nil)
+}
+
+func (c *AgentClient) InspectContainer(ctx context.Context, node *models.Node, containerID string) (*models.ContainerInfo, error) {
+	url, err := containerURL(node, containerID, "")
+	if err != nil {
+		return nil, err
+	}
+	var info models.ContainerInfo
+	err = c.retry(ctx, func() error {
+		return c.do(ctx, "inspect_container", node, http.MethodGet, url, nil, c.agent.Timeout, &info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (c *AgentClient) ListContainers(ctx context.Context, node *models.Node) ([]models.ContainerInfo, error) {
+	version, ok := node.AgentAPI()
+	if !ok {
+		return nil, ErrIncompatibleAgent
+	}
+	if agentPaths[version].containers == "" {
+		return nil, ErrUnsupportedOperation
 	}
+	url := node.Endpoint() + agentPaths[version].containers
 
-	return result.ContainerID, nil
+	var containers []models.ContainerInfo
+	err := c.retry(ctx, func() error {
+		return c.do(ctx, "list_containers", node, http.MethodGet, url, nil, c.agent.Timeout, &containers)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return containers, nil
 }
 
-func (c *AgentClient) GetNodeMetrics(node *models.Node) (map[string]interface{}, error) {
-	url := fmt.Sprintf("http://localhost:%d/api/v1/metrics", node.ControlPort)
+func (c *AgentClient) ContainerStats(ctx context.Context, node *models.Node, containerID string) (*models.ContainerStats, error) {
+	url, err := containerURL(node, containerID, "/stats")
+	if err != nil {
+		return nil, err
+	}
+	var stats models.ContainerStats
+	err = c.retry(ctx, func() error {
+		return c.do(ctx, "container_stats", node, http.MethodGet, url, nil, c.agent.Timeout, &stats)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+func (c *AgentClient) GetNodeMetrics(ctx context.Context, node *models.Node) (*models.NodeMetrics, error) {
+	url, err := MetricsURL(node)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.config.AgentToken)
+	var nodeMetrics models.NodeMetrics
+	err = c.retry(ctx, func() error {
+		return c.do(ctx, "get_metrics", node, http.MethodGet, url, nil, c.agent.Timeout, &nodeMetrics)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &nodeMetrics, nil
+}
 
-	resp, err := c.httpClient.Do(req)
+func (c *AgentClient) PullImage(ctx context.Context, node *models.Node, image string) (*models.ImagePullStatus, error) {
+	url, err := imagePullURL(node)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, err
+	}
+	pullSecret, err := c.pullSecret(node, image)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(struct {
+		Image      string             `json:"image"`
+		PullSecret *models.PullSecret `json:"pull_secret,omitempty"`
+	}{Image: image, PullSecret: pullSecret})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get metrics, status code: %d", resp.StatusCode)
+	var status models.ImagePullStatus
+	err = c.retry(ctx, func() error {
+		return c.do(ctx, "pull_image", node, http.MethodPost, url, body, c.agent.Timeout, &status)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return &status, nil
+}
 
-	var metrics map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&metrics); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+func (c *AgentClient) ImagePullStatus(ctx context.Context, node *models.Node, image string) (*models.ImagePullStatus, error) {
+	url, err := imagePullURL(node)
+	if err != nil {
+		return nil, err
 	}
+	url += "?image=" + neturl.QueryEscape(image)
 
-	return metrics, nil
+	var status models.ImagePullStatus
+	err = c.retry(ctx, func() error {
+		return c.do(ctx, "image_pull_status", node, http.MethodGet, url, nil, c.agent.Timeout, &status)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// pullSecret returns the credentials the agent on node needs to pull image,
+// or nil. Credentials are only sent over mutual TLS unless the config
+// explicitly allows plain HTTP.
+func (c *AgentClient) pullSecret(node *models.Node, image string) (*models.PullSecret, error) {
+	if c.pullSecrets == nil {
+		return nil, nil
+	}
+	secret, err := c.pullSecrets.PullSecret(image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up pull secret for %s: %w", image, err)
+	}
+	if secret != nil && !node.HasCertificate() && !c.agent.AllowInsecurePullSecrets {
+		return nil, fmt.Errorf("%w: node %s, image %s", ErrInsecurePullSecret, node.ID, image)
+	}
+	return secret, nil
+}
+
+// MetricsURL returns the metrics endpoint for the node's agent API version.
+// The health check service probes it directly, with its own timeout and
+// without retries.
+func MetricsURL(node *models.Node) (string, error) {
+	version, ok := node.AgentAPI()
+	if !ok {
+		return "", ErrIncompatibleAgent
+	}
+	return node.Endpoint() + agentPaths[version].metrics, nil
+}
+
+// imagePullURL returns the image pull endpoint for the node's agent API version.
+func imagePullURL(node *models.Node) (string, error) {
+	version, ok := node.AgentAPI()
+	if !ok {
+		return "", ErrIncompatibleAgent
+	}
+	if agentPaths[version].imagePull == "" {
+		return "", ErrUnsupportedOperation
+	}
+	return node.Endpoint() + agentPaths[version].imagePull, nil
+}
+
+// containerURL builds the URL of a single container, followed by action
+// (e.g. "/stop"), for the node's agent API version.
+func containerURL(node *models.Node, containerID, action string) (string, error) {
+	version, ok := node.AgentAPI()
+	if !ok {
+		return "", ErrIncompatibleAgent
+	}
+	if agentPaths[version].containers == "" {
+		return "", ErrUnsupportedOperation
+	}
+	if containerID == "" {
+		return "", errors.New("container ID is required")
+	}
+	return node.Endpoint() + agentPaths[version].containers + "/" + neturl.PathEscape(containerID) + action, nil
+}
+
+// retry runs an idempotent call, retrying up to MaxRetries times while the
+// agent is unreachable. The wait starts at RetryBackoff seconds and doubles.
+func (c *AgentClient) retry(ctx context.Context, call func() error) error {
+	backoff := time.Duration(c.agent.RetryBackoff) * time.Second
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if err == nil || !IsUnreachable(err) || attempt >= c.agent.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// do sends a single authenticated request to the node's agent and decodes the JSON response into
+// out, unless out is nil. timeout is in seconds; zero means no limit beyond ctx.
+func (c *AgentClient) do(ctx context.Context, op string, node *models.Node, method, url string, body []byte, timeout int, out interface{}) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// Signing each attempt gives retries a fresh timestamp and nonce.
+	if err := c.auth.Authenticate(req, node, body); err != nil {
+		return err
+	}
+
+	transport, err := c.tls.RoundTripper(node)
+	if err != nil {
+		return err
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		metrics.AgentRequestErrors.Inc(op, "transport")
+		return &UnreachableError{Op: op, Err: err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300, resp.StatusCode == http.StatusNotModified:
+		// The agent answers 304 when a container is already in the requested state.
+	case resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout:
+		// frps answers with a gateway error when the tunnel to the agent is down.
+		metrics.AgentRequestErrors.Inc(op, "status")
+		return &UnreachableError{Op: op, Err: fmt.Errorf("status code: %d", resp.StatusCode)}
+	default:
+		metrics.AgentRequestErrors.Inc(op, "status")
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return &RejectedError{Op: op, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		metrics.AgentRequestErrors.Inc(op, "decode")
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,213 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+	"utopia-server/internal/config"
+	"utopia-server/internal/models"
+	"utopia-server/internal/secrets"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestNode(t *testing.T, handler http.HandlerFunc) *models.Node {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	controlPort, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	return &models.Node{ControlPort: controlPort}
+}
+
+func TestAgentClient_RetriesIdempotentCalls(t *testing.T) {
+	var calls atomic.Int32
+	node := newTestNode(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"cpu_usage_percent": 12.5}`))
+	})
+	agent := NewAgentClient(config.AgentConfig{Timeout: 1, MaxRetries: 3}, nil, nil, nil)
+
+	metrics, err := agent.GetNodeMetrics(context.Background(), node)
+	require.NoError(t, err)
+	assert.Equal(t, 12.5, metrics.CPUUsagePercent)
+	assert.EqualValues(t, 3, calls.Load())
+}
+
+func TestAgentClient_TypedErrors(t *testing.T) {
+	var calls atomic.Int32
+	node := newTestNode(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "image not allowed", http.StatusForbidden)
+	})
+	agent := NewAgentClient(config.AgentConfig{Timeout: 1, MaxRetries: 3}, nil, nil, nil)
+
+	_, err := agent.GetNodeMetrics(context.Background(), node)
+	var rejected *RejectedError
+	require.ErrorAs(t, err, &rejected)
+	assert.Equal(t, http.StatusForbidden, rejected.StatusCode)
+	assert.Equal(t, "image not allowed", rejected.Message)
+	assert.EqualValues(t, 1, calls.Load(), "rejected calls are not retried")
+
+	_, err = agent.CreateContainer(context.Background(), &models.Node{ControlPort: 1}, &models.GpuClaim{})
+	assert.True(t, IsUnreachable(err))
+	assert.False(t, IsRejected(err))
+
+	_, err = agent.CreateContainer(context.Background(), &models.Node{Inventory: &models.NodeInventory{APIVersions: []string{"v9"}}}, &models.GpuClaim{})
+	assert.ErrorIs(t, err, ErrIncompatibleAgent)
+}
+
+func TestAgentClient_ContainerLifecycle(t *testing.T) {
+	var requests []string
+	node := newTestNode(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.Method + " " + r.URL.Path {
+		case "POST /api/v1/containers/abc/stop":
+			w.WriteHeader(http.StatusNotModified)
+		case "DELETE /api/v1/containers/abc", "POST /api/v1/containers/abc/restart":
+			w.WriteHeader(http.StatusNoContent)
+		case "GET /api/v1/containers/abc":
+			w.Write([]byte(`{"id": "abc", "state": "exited", "exit_code": 2, "gpus": [0, 1]}`))
+		case "GET /api/v1/containers":
+			w.Write([]byte(`[{"id": "abc", "state": "running"}]`))
+		case "GET /api/v1/containers/abc/stats":
+			w.Write([]byte(`{"container_id": "abc", "memory_used_mb": 512}`))
+		default:
+			http.Error(w, "no such container", http.StatusNotFound)
+		}
+	})
+	node.Inventory = &models.NodeInventory{APIVersions: []string{models.AgentAPIV1}}
+	agent := NewAgentClient(config.AgentConfig{Timeout: 1}, nil, nil, nil)
+	ctx := context.Background()
+
+	require.NoError(t, agent.StopContainer(ctx, node, "abc"), "304 means already stopped")
+	require.NoError(t, agent.RemoveContainer(ctx, node, "abc"))
+	require.NoError(t, agent.RestartContainer(ctx, node, "abc"))
+
+	info, err := agent.InspectContainer(ctx, node, "abc")
+	require.NoError(t, err)
+	assert.True(t, info.Finished())
+	assert.Equal(t, 2, info.ExitCode)
+	assert.Equal(t, []int{0, 1}, info.Gpus)
+
+	containers, err := agent.ListContainers(ctx, node)
+	require.NoError(t, err)
+	require.Len(t, containers, 1)
+	assert.Equal(t, models.ContainerStateRunning, containers[0].State)
+
+	stats, err := agent.ContainerStats(ctx, node, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, 512, stats.MemoryUsedMB)
+
+	_, err = agent.InspectContainer(ctx, node, "missing")
+	assert.True(t, IsNotFound(err))
+
+	// v0 agents can only create containers, so lifecycle calls never reach them.
+	node.Inventory = nil
+	sent := len(requests)
+	assert.ErrorIs(t, agent.StopContainer(ctx, node, "abc"), ErrUnsupportedOperation)
+	_, err = agent.ListContainers(ctx, node)
+	assert.ErrorIs(t, err, ErrUnsupportedOperation)
+	assert.False(t, IsRejected(err))
+	assert.Len(t, requests, sent)
+	_, _ = agent.CreateContainer(ctx, node, &models.GpuClaim{})
+	assert.Equal(t, "POST /containers", requests[len(requests)-1])
+}
+
+func TestAgentClient_CreateContainerIsIdempotent(t *testing.T) {
+	var calls atomic.Int32
+	var keys []string
+	node := newTestNode(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			IdempotencyKey string            `json:"idempotency_key"`
+			Labels         map[string]string `json:"labels"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		keys = append(keys, body.IdempotencyKey)
+		assert.Equal(t, "claim-1", body.Labels[models.ContainerLabelClaimID])
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		w.Write([]byte(`{"container_id": "abc"}`))
+	})
+	agent := NewAgentClient(config.AgentConfig{CreateTimeout: 1, MaxRetries: 3}, nil, nil, nil)
+
+	containerID, err := agent.CreateContainer(context.Background(), node, &models.GpuClaim{ID: "claim-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "abc", containerID)
+	assert.Equal(t, []string{"claim-1", "claim-1"}, keys, "retries carry the same key")
+}
+
+func TestAgentClient_SignsRequestsWithAgentSecret(t *testing.T) {
+	box, err := secrets.NewBox(make([]byte, secrets.KeySize))
+	require.NoError(t, err)
+	sealed, err := box.Seal([]byte("agent-secret"), secrets.AgentSecretAD("node-1"))
+	require.NoError(t, err)
+
+	var verifyErr error
+	var authorization string
+	node := newTestNode(t, func(w http.ResponseWriter, r *http.Request) {
+		_, verifyErr = secrets.VerifyRequest(r, []byte("agent-secret"), time.Now())
+		authorization = r.Header.Get("Authorization")
+		w.Write([]byte(`{"container_id": "c-1"}`))
+	})
+	node.ID = "node-1"
+	node.AgentSecret = sealed
+	agent := NewAgentClient(config.AgentConfig{Timeout: 1}, secrets.NewAgentAuthenticator(box, "shared"), nil, nil)
+
+	claim := &models.GpuClaim{ID: "claim-1", Spec: models.GpuClaimSpec{Image: "pytorch"}}
+	_, err = agent.CreateContainer(context.Background(), node, claim)
+	require.NoError(t, err)
+	assert.NoError(t, verifyErr)
+	assert.Empty(t, authorization, "signed nodes must not receive the shared token")
+}
+
+type staticPullSecrets map[string]*models.PullSecret
+
+func (s staticPullSecrets) PullSecret(image string) (*models.PullSecret, error) {
+	return s[models.ImageRegistry(image)], nil
+}
+
+func TestAgentClient_SendsPullSecretForPrivateImages(t *testing.T) {
+	var received []*models.PullSecret
+	node := newTestNode(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			PullSecret *models.PullSecret `json:"pull_secret"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		received = append(received, body.PullSecret)
+		w.Write([]byte(`{"container_id": "c-1"}`))
+	})
+	secret := &models.PullSecret{Registry: "nvcr.io", Username: "$oauthtoken", Password: "key"}
+	private := &models.GpuClaim{ID: "claim-1", Spec: models.GpuClaimSpec{Image: "nvcr.io/nvidia/pytorch:24.01-py3"}}
+	public := &models.GpuClaim{ID: "claim-1", Spec: models.GpuClaimSpec{Image: "ubuntu:22.04"}}
+
+	// The test node has no certificate: credentials are withheld unless plain HTTP is allowed.
+	agent := NewAgentClient(config.AgentConfig{CreateTimeout: 1}, nil, nil, staticPullSecrets{"nvcr.io": secret})
+	_, err := agent.CreateContainer(context.Background(), node, private)
+	assert.ErrorIs(t, err, ErrInsecurePullSecret)
+	_, err = agent.CreateContainer(context.Background(), node, public)
+	require.NoError(t, err)
+	assert.Equal(t, []*models.PullSecret{nil}, received, "the private image is never requested")
+
+	received = nil
+	agent = NewAgentClient(config.AgentConfig{CreateTimeout: 1, AllowInsecurePullSecrets: true}, nil, nil, staticPullSecrets{"nvcr.io": secret})
+	for _, claim := range []*models.GpuClaim{private, public} {
+		_, err := agent.CreateContainer(context.Background(), node, claim)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []*models.PullSecret{secret, nil}, received)
+}
@@ -0,0 +1,277 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"utopia-server/internal/models"
+)
+
+// FakeAgent is an in-memory Agent for tests. Set CreateErr, LostCreateErr,
+// LifecycleErr, MetricsErr, PullErr and Metrics before handing it to the code under test.
+// Like a real agent, it uses the claim ID as the idempotency key of CreateContainer.
+// Image pulls are tracked per node and stay "pulling" until SetPullStatus changes them.
+type FakeAgent struct {
+	CreateErr error
+	// LostCreateErr is returned by CreateContainer after the container has been
+	// created, as if the response never reached the server.
+	LostCreateErr error
+	// LifecycleErr is returned by every call on an existing container and by ListContainers.
+	LifecycleErr error
+	MetricsErr   error
+	Metrics      *models.NodeMetrics
+	// PullErr is returned by PullImage and ImagePullStatus.
+	PullErr error
+
+	mu          sync.Mutex
+	nextID      int
+	createCalls int
+	containers  map[string]*fakeContainer
+	pulls       map[string]*models.ImagePullStatus // keyed by node ID and image
+}
+
+type fakeContainer struct {
+	claimID  string
+	info     models.ContainerInfo
+	restarts int
+}
+
+var _ Agent = (*FakeAgent)(nil)
+
+// NewFakeAgent creates a FakeAgent with no containers.
+func NewFakeAgent() *FakeAgent {
+	return &FakeAgent{
+		containers: make(map[string]*fakeContainer),
+		pulls:      make(map[string]*models.ImagePullStatus),
+	}
+}
+
+func (f *FakeAgent) CreateContainer(ctx context.Context, node *models.Node, claim *models.GpuClaim) (string, error) {
+	if f.CreateErr != nil {
+		return "", f.CreateErr
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.createCalls++
+	for id, c := range f.containers {
+		if c.claimID == claim.ID {
+			if f.LostCreateErr != nil {
+				return "", f.LostCreateErr
+			}
+			return id, nil
+		}
+	}
+
+	f.nextID++
+	containerID := fmt.Sprintf("container-%d", f.nextID)
+	now := time.Now()
+	f.containers[containerID] = &fakeContainer{
+		claimID: claim.ID,
+		info: models.ContainerInfo{
+			ID:        containerID,
+			Image:     claim.Spec.Image,
+			Labels:    map[string]string{models.ContainerLabelClaimID: claim.ID},
+			State:     models.ContainerStateRunning,
+			CreatedAt: now,
+			StartedAt: &now,
+		},
+	}
+	if f.LostCreateErr != nil {
+		return "", f.LostCreateErr
+	}
+	return containerID, nil
+}
+
+func (f *FakeAgent) StopContainer(ctx context.Context, node *models.Node, containerID string) error {
+	return f.update(containerID, func(c *fakeContainer) {
+		if !c.info.Finished() {
+			c.info.State = models.ContainerStateExited
+			c.info.ExitCode = 137
+		}
+	})
+}
+
+func (f *FakeAgent) RemoveContainer(ctx context.Context, node *models.Node, containerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.get(containerID, "remove_container"); err != nil {
+		return err
+	}
+	delete(f.containers, containerID)
+	return nil
+}
+
+func (f *FakeAgent) RestartContainer(ctx context.Context, node *models.Node, containerID string) error {
+	return f.update(containerID, func(c *fakeContainer) {
+		c.info.State = models.ContainerStateRunning
+		c.info.ExitCode = 0
+		c.restarts++
+	})
+}
+
+func (f *FakeAgent) InspectContainer(ctx context.Context, node *models.Node, containerID string) (*models.ContainerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.get(containerID, "inspect_container")
+	if err != nil {
+		return nil, err
+	}
+	info := c.info
+	return &info, nil
+}
+
+func (f *FakeAgent) ListContainers(ctx context.Context, node *models.Node) ([]models.ContainerInfo, error) {
+	if f.LifecycleErr != nil {
+		return nil, f.LifecycleErr
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	containers := make([]models.ContainerInfo, 0, len(f.containers))
+	for _, c := range f.containers {
+		containers = append(containers, c.info)
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].ID < containers[j].ID })
+	return containers, nil
+}
+
+func (f *FakeAgent) ContainerStats(ctx context.Context, node *models.Node, containerID string) (*models.ContainerStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.get(containerID, "container_stats"); err != nil {
+		return nil, err
+	}
+	return &models.ContainerStats{ContainerID: containerID}, nil
+}
+
+func (f *FakeAgent) GetNodeMetrics(ctx context.Context, node *models.Node) (*models.NodeMetrics, error) {
+	if f.MetricsErr != nil {
+		return nil, f.MetricsErr
+	}
+	return f.Metrics, nil
+}
+
+func (f *FakeAgent) PullImage(ctx context.Context, node *models.Node, image string) (*models.ImagePullStatus, error) {
+	if f.PullErr != nil {
+		return nil, f.PullErr
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pull, ok := f.pulls[pullKey(node.ID, image)]
+	if !ok {
+		pull = &models.ImagePullStatus{Image: image, State: models.ImagePullStatePulling}
+		f.pulls[pullKey(node.ID, image)] = pull
+	}
+	status := *pull
+	return &status, nil
+}
+
+func (f *FakeAgent) ImagePullStatus(ctx context.Context, node *models.Node, image string) (*models.ImagePullStatus, error) {
+	if f.PullErr != nil {
+		return nil, f.PullErr
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pull, ok := f.pulls[pullKey(node.ID, image)]
+	if !ok {
+		return nil, &RejectedError{Op: "image_pull_status", StatusCode: http.StatusNotFound, Message: "no such pull"}
+	}
+	status := *pull
+	return &status, nil
+}
+
+// SetPullStatus simulates the progress of an image pull on a node.
+func (f *FakeAgent) SetPullStatus(nodeID string, status models.ImagePullStatus) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pulls[pullKey(nodeID, status.Image)] = &status
+}
+
+// Pulling reports whether PullImage was called for the image on the node.
+func (f *FakeAgent) Pulling(nodeID, image string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.pulls[pullKey(nodeID, image)]
+	return ok
+}
+
+func pullKey(nodeID, image string) string {
+	return nodeID + "\x00" + image
+}
+
+// SetContainerState simulates the container changing state on the node, for
+// example exiting on its own.
+func (f *FakeAgent) SetContainerState(containerID, state string, exitCode int) {
+	f.update(containerID, func(c *fakeContainer) {
+		c.info.State = state
+		c.info.ExitCode = exitCode
+	})
+}
+
+// AddContainer places a container on the fake node as if it had been created
+// outside the server.
+func (f *FakeAgent) AddContainer(info models.ContainerInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.containers[info.ID] = &fakeContainer{claimID: info.ClaimID(), info: info}
+}
+
+// Containers returns the containers on the fake node, keyed by container ID
+// with the claim ID as value.
+func (f *FakeAgent) Containers() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	containers := make(map[string]string, len(f.containers))
+	for id, c := range f.containers {
+		containers[id] = c.claimID
+	}
+	return containers
+}
+
+// CreateCalls returns how many times CreateContainer reached the agent.
+func (f *FakeAgent) CreateCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.createCalls
+}
+
+// Restarts returns how many times the container was restarted.
+func (f *FakeAgent) Restarts(containerID string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.containers[containerID]; ok {
+		return c.restarts
+	}
+	return 0
+}
+
+func (f *FakeAgent) update(containerID string, change func(c *fakeContainer)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.get(containerID, "update_container")
+	if err != nil {
+		return err
+	}
+	change(c)
+	return nil
+}
+
+// get must be called with f.mu held.
+func (f *FakeAgent) get(containerID, op string) (*fakeContainer, error) {
+	if f.LifecycleErr != nil {
+		return nil, f.LifecycleErr
+	}
+	c, ok := f.containers[containerID]
+	if !ok {
+		return nil, &RejectedError{Op: op, StatusCode: http.StatusNotFound, Message: "no such container"}
+	}
+	return c, nil
+}
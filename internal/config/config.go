@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"strings"
 
 	"github.com/spf13/viper"
@@ -9,10 +10,19 @@ import (
 
 // Config 存储了应用程序的所有配置。
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	Database DatabaseConfig `mapstructure:"database"`
-	JWT      JWTConfig      `mapstructure:"jwt"`
-	FRP      FRPConfig      `mapstructure:"frp"`
+	Server    ServerConfig    `mapstructure:"server"`
+	Database  DatabaseConfig  `mapstructure:"database"`
+	JWT       JWTConfig       `mapstructure:"jwt"`
+	FRP       FRPConfig       `mapstructure:"frp"`
+	Health    HealthConfig    `mapstructure:"health"`
+	History   HistoryConfig   `mapstructure:"history"`
+	Inventory InventoryConfig `mapstructure:"inventory"`
+	Agent     AgentConfig     `mapstructure:"agent"`
+	GC        GCConfig        `mapstructure:"gc"`
+	Secrets   SecretsConfig   `mapstructure:"secrets"`
+	PKI       PKIConfig       `mapstructure:"pki"`
+	Prepull   PrepullConfig   `mapstructure:"prepull"`
+	Metrics   MetricsConfig   `mapstructure:"metrics"`
 }
 
 // ServerConfig 存储了 API 服务器的配置。
@@ -41,6 +51,95 @@ type FRPConfig struct {
 	DashboardPwd  string `mapstructure:"dashboard_pwd"`
 	DashboardAddr string `mapstructure:"dashboard_addr"`
 	AgentToken    string `mapstructure:"agent_token"`
+	// PluginAddr 是 frps 调用服务器插件接口的地址，默认为 127.0.0.1:<server.port>。
+	PluginAddr string `mapstructure:"plugin_addr"`
+	// EnforceNodeIdentity 要求 frpc 登录时携带节点凭据，且只能打开以自己 ID 命名的控制隧道。
+	// 默认关闭，以免拒绝尚未配置凭据的现有 agent；所有 agent 升级后应开启。
+	EnforceNodeIdentity bool `mapstructure:"enforce_node_identity"`
+}
+
+// HealthConfig 存储了节点健康检查的配置，时间单位均为秒。
+type HealthConfig struct {
+	Interval         int `mapstructure:"interval"`
+	Timeout          int `mapstructure:"timeout"`
+	FailureThreshold int `mapstructure:"failure_threshold"` // 连续失败多少次后判定为 Offline
+	SuccessThreshold int `mapstructure:"success_threshold"` // Unknown 节点连续成功多少次后恢复为 Online
+	BackoffBase      int `mapstructure:"backoff_base"`      // 探测不健康节点的初始退避时间
+	BackoffMax       int `mapstructure:"backoff_max"`       // 退避时间上限
+	Workers          int `mapstructure:"workers"`           // 并发探测的 worker 数量
+	BatchSize        int `mapstructure:"batch_size"`        // 批量写回数据库的最大节点数
+	HeartbeatTimeout int `mapstructure:"heartbeat_timeout"` // 推送模式下超过该时间未收到心跳即计为一次失败
+	// GPU 健康状态机的阈值，单位为上报次数。
+	GpuSuspectThreshold  int `mapstructure:"gpu_suspect_threshold"`  // Suspect GPU 累计异常多少次后隔离
+	GpuRecoveryThreshold int `mapstructure:"gpu_recovery_threshold"` // Suspect GPU 连续正常多少次后恢复为 Healthy
+}
+
+// HistoryConfig 存储了节点指标历史的保留策略，时间单位均为秒。
+type HistoryConfig struct {
+	RawRetention    int            `mapstructure:"raw_retention"`    // 原始采样的保留时间
+	Rollups         []RollupConfig `mapstructure:"rollups"`          // 降采样层级，按精度从细到粗排列
+	CompactInterval int            `mapstructure:"compact_interval"` // 降采样与清理任务的执行间隔
+}
+
+// RollupConfig 描述一个降采样层级。
+type RollupConfig struct {
+	Resolution int `mapstructure:"resolution"` // 每个采样点覆盖的秒数
+	Retention  int `mapstructure:"retention"`  // 该层级的保留时间
+}
+
+// InventoryConfig 存储了静态节点清单的配置。
+type InventoryConfig struct {
+	Path string `mapstructure:"path"` // YAML 清单文件路径，为空时不导入
+}
+
+// AgentConfig 存储了服务器调用节点 agent 的超时与重试配置，时间单位均为秒。
+type AgentConfig struct {
+	Timeout       int `mapstructure:"timeout"`        // 普通请求的超时时间
+	CreateTimeout int `mapstructure:"create_timeout"` // 创建容器的超时时间，其中可能包含拉取镜像
+	MaxRetries    int `mapstructure:"max_retries"`    // 幂等请求在 agent 不可达时的最大重试次数
+	RetryBackoff  int `mapstructure:"retry_backoff"`  // 第一次重试前的等待时间，之后每次翻倍
+
+	// AllowSharedToken 允许没有 agent 密钥的节点继续使用共享的 frp.agent_token。
+	// 默认开启以兼容旧节点；所有节点轮换凭据后应关闭，此后这些节点将无法被调用。
+	AllowSharedToken bool `mapstructure:"allow_shared_token"`
+	// AllowInsecurePullSecrets 允许把私有仓库凭据以明文发给没有证书、通过 HTTP 访问的节点。
+	// 默认关闭：隧道不加密，也无法确认端口背后是预期的 agent。
+	AllowInsecurePullSecrets bool `mapstructure:"allow_insecure_pull_secrets"`
+}
+
+// GCConfig 存储了孤儿容器垃圾回收的配置，时间单位均为秒。
+type GCConfig struct {
+	Interval    int `mapstructure:"interval"`     // 两次回收之间的间隔，0 表示不定期回收
+	GracePeriod int `mapstructure:"grace_period"` // 无主容器创建后至少经过多久才会被删除
+}
+
+// PrepullConfig 存储了镜像预拉取任务的配置，时间单位均为秒。
+type PrepullConfig struct {
+	Interval int `mapstructure:"interval"` // 两次查询拉取进度之间的间隔
+	Timeout  int `mapstructure:"timeout"`  // 任务创建后超过该时间仍未完成的节点判定为失败
+}
+
+// MetricsConfig 存储了 Prometheus 指标端点的配置。
+type MetricsConfig struct {
+	// Addr 是单独提供 /metrics 的监听地址，默认只监听本机回环地址；为空时不导出指标。
+	Addr string `mapstructure:"addr"`
+}
+
+// SecretsConfig 存储了加密敏感数据所用的主密钥。
+type SecretsConfig struct {
+	// Key 是 base64 编码的 32 字节主密钥，用于加密节点的 agent 密钥。
+	// 为空时不签发 agent 密钥，服务器使用共享的 frp.agent_token 调用所有 agent。
+	Key string `mapstructure:"key"`
+}
+
+// PKIConfig 存储了内部 CA 的配置，时间单位均为秒。
+type PKIConfig struct {
+	// CACert 与 CAKey 是 CA 证书和私钥的 PEM 文件路径，两个文件都不存在时自动生成。
+	// 两者都为空时不启用双向 TLS，服务器通过 HTTP 访问所有 agent。
+	CACert        string `mapstructure:"ca_cert"`
+	CAKey         string `mapstructure:"ca_key"`
+	CertTTL       int    `mapstructure:"cert_ttl"`        // 签发给节点的证书有效期
+	ClientCertTTL int    `mapstructure:"client_cert_ttl"` // 服务器访问 agent 的客户端证书有效期，过去三分之二后自动轮换
 }
 
 // Load 从文件和环境变量中加载配置。
@@ -52,6 +151,37 @@ func Load() (*Config, error) {
 	v.SetDefault("server.addr", "0.0.0.0")
 	v.SetDefault("jwt.token_ttl", 3600) // 1 hour
 	v.SetDefault("frp.bind_port", 7000)
+	v.SetDefault("frp.enforce_node_identity", false)
+	v.SetDefault("health.interval", 15)
+	v.SetDefault("health.timeout", 5)
+	v.SetDefault("health.failure_threshold", 3)
+	v.SetDefault("health.success_threshold", 2)
+	v.SetDefault("health.backoff_base", 15)
+	v.SetDefault("health.backoff_max", 300)
+	v.SetDefault("health.workers", 8)
+	v.SetDefault("health.batch_size", 50)
+	v.SetDefault("health.heartbeat_timeout", 30)
+	v.SetDefault("health.gpu_suspect_threshold", 3)
+	v.SetDefault("health.gpu_recovery_threshold", 20)
+	v.SetDefault("history.raw_retention", 86400) // 1 day
+	v.SetDefault("history.rollups", []map[string]interface{}{
+		{"resolution": 300, "retention": 604800},   // 5 minutes, kept for 7 days
+		{"resolution": 3600, "retention": 7776000}, // 1 hour, kept for 90 days
+	})
+	v.SetDefault("history.compact_interval", 300)
+	v.SetDefault("agent.timeout", 10)
+	v.SetDefault("agent.create_timeout", 300)
+	v.SetDefault("agent.max_retries", 3)
+	v.SetDefault("agent.retry_backoff", 1)
+	v.SetDefault("agent.allow_shared_token", true)
+	v.SetDefault("agent.allow_insecure_pull_secrets", false)
+	v.SetDefault("gc.interval", 300)
+	v.SetDefault("gc.grace_period", 600)
+	v.SetDefault("pki.cert_ttl", 2592000)      // 30 days
+	v.SetDefault("pki.client_cert_ttl", 86400) // 1 day
+	v.SetDefault("prepull.interval", 5)
+	v.SetDefault("prepull.timeout", 3600)
+	v.SetDefault("metrics.addr", "127.0.0.1:9090")
 
 	// 设置配置文件
 	v.SetConfigName("config")
@@ -77,5 +207,75 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	// frps 与服务器运行在同一主机上，插件接口只接受来自本机的请求。
+	if cfg.FRP.PluginAddr == "" {
+		cfg.FRP.PluginAddr = net.JoinHostPort("127.0.0.1", cfg.Server.Port)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
+
+// intSetting 是 validate 检查的一个整数配置项。
+type intSetting struct {
+	key   string
+	value int
+}
+
+// validate 检查数值配置的取值范围。时间间隔、超时、阈值和 worker 数量为 0 或负数时，
+// 服务会在启动后才失败（例如 time.NewTicker panic）或永远不做任何事，因此在加载时直接拒绝。
+func (c *Config) validate() error {
+	positive := []intSetting{
+		{"jwt.token_ttl", c.JWT.TokenTTL},
+		{"health.interval", c.Health.Interval},
+		{"health.timeout", c.Health.Timeout},
+		{"health.failure_threshold", c.Health.FailureThreshold},
+		{"health.success_threshold", c.Health.SuccessThreshold},
+		{"health.backoff_base", c.Health.BackoffBase},
+		{"health.backoff_max", c.Health.BackoffMax},
+		{"health.workers", c.Health.Workers},
+		{"health.batch_size", c.Health.BatchSize},
+		{"health.heartbeat_timeout", c.Health.HeartbeatTimeout},
+		{"health.gpu_suspect_threshold", c.Health.GpuSuspectThreshold},
+		{"health.gpu_recovery_threshold", c.Health.GpuRecoveryThreshold},
+		{"history.raw_retention", c.History.RawRetention},
+		{"history.compact_interval", c.History.CompactInterval},
+		{"agent.timeout", c.Agent.Timeout},
+		{"agent.create_timeout", c.Agent.CreateTimeout},
+		{"prepull.interval", c.Prepull.Interval},
+		{"prepull.timeout", c.Prepull.Timeout},
+		{"pki.cert_ttl", c.PKI.CertTTL},
+		{"pki.client_cert_ttl", c.PKI.ClientCertTTL},
+	}
+	for i, rollup := range c.History.Rollups {
+		positive = append(positive,
+			intSetting{fmt.Sprintf("history.rollups[%d].resolution", i), rollup.Resolution},
+			intSetting{fmt.Sprintf("history.rollups[%d].retention", i), rollup.Retention},
+		)
+	}
+	for _, field := range positive {
+		if field.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %d", field.key, field.value)
+		}
+	}
+
+	// 以下配置允许为 0：gc.interval 为 0 表示不定期回收，agent.max_retries 为 0 表示不重试。
+	nonNegative := []intSetting{
+		{"gc.interval", c.GC.Interval},
+		{"gc.grace_period", c.GC.GracePeriod},
+		{"agent.max_retries", c.Agent.MaxRetries},
+		{"agent.retry_backoff", c.Agent.RetryBackoff},
+	}
+	for _, field := range nonNegative {
+		if field.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %d", field.key, field.value)
+		}
+	}
+
+	if c.Health.BackoffMax < c.Health.BackoffBase {
+		return fmt.Errorf("health.backoff_max (%d) must not be less than health.backoff_base (%d)", c.Health.BackoffMax, c.Health.BackoffBase)
+	}
+	return nil
+}
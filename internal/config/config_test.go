@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad_ValidatesIntervals(t *testing.T) {
+	_, err := Load()
+	require.NoError(t, err, "the shipped config is valid")
+
+	t.Setenv("UTOPIA_HEALTH_INTERVAL", "0")
+	_, err = Load()
+	assert.ErrorContains(t, err, "health.interval must be positive")
+}
+
+func TestValidate(t *testing.T) {
+	cfg, err := Load()
+	require.NoError(t, err)
+
+	disabled := *cfg
+	disabled.GC.Interval = 0
+	assert.NoError(t, disabled.validate(), "gc.interval 0 disables the collector")
+
+	negative := *cfg
+	negative.GC.Interval = -1
+	assert.ErrorContains(t, negative.validate(), "gc.interval must not be negative")
+
+	noWorkers := *cfg
+	noWorkers.Health.Workers = 0
+	assert.ErrorContains(t, noWorkers.validate(), "health.workers must be positive")
+
+	rollup := *cfg
+	rollup.History.Rollups = []RollupConfig{{Resolution: 60, Retention: 0}}
+	assert.ErrorContains(t, rollup.validate(), "history.rollups[0].retention must be positive")
+}
@@ -1,12 +1,13 @@
 package controller
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"log"
-	"strconv"
 	"time"
 
 	"utopia-server/internal/client"
+	"utopia-server/internal/metrics"
 	"utopia-server/internal/models"
 	"utopia-server/internal/node"
 	"utopia-server/internal/scheduler"
@@ -17,11 +18,11 @@ type Controller struct {
 	store       GpuClaimStore
 	scheduler   *scheduler.Scheduler
 	nodeStore   node.Store
-	agentClient *client.AgentClient
+	agentClient client.Agent
 }
 
 // NewController creates a new controller.
-func NewController(store GpuClaimStore, scheduler *scheduler.Scheduler, nodeStore node.Store, agentClient *client.AgentClient) *Controller {
+func NewController(store GpuClaimStore, scheduler *scheduler.Scheduler, nodeStore node.Store, agentClient client.Agent) *Controller {
 	return &Controller{
 		store:       store,
 		scheduler:   scheduler,
@@ -30,26 +31,40 @@ func NewController(store GpuClaimStore, scheduler *scheduler.Scheduler, nodeStor
 	}
 }
 
-// Run starts the controller's reconciliation loop.
+// Run reconciles claims every 5 seconds until stopCh is closed. Closing
+// stopCh also cancels the agent calls of a pass that is still running.
 func (c *Controller) Run(stopCh <-chan struct{}) {
 	log.Println("Starting controller")
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				c.reconcileClaims()
-			case <-stopCh:
-				log.Println("Stopping controller")
-				return
-			}
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
 		}
 	}()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.reconcileClaims(ctx)
+		case <-ctx.Done():
+			log.Println("Stopping controller")
+			return
+		}
+	}
 }
 
-func (c *Controller) reconcileClaims() {
+func (c *Controller) reconcileClaims(ctx context.Context) {
+	start := time.Now()
+	defer func() {
+		metrics.ReconcileDuration.Observe(time.Since(start).Seconds(), "controller")
+	}()
+
 	claims, err := c.store.ListByPhase(models.GpuClaimPhasePending, models.GpuClaimPhaseScheduled)
 	if err != nil {
 		log.Printf("Error listing GPU claims: %v", err)
@@ -57,18 +72,51 @@ func (c *Controller) reconcileClaims() {
 	}
 
 	for _, claim := range claims {
-		c.reconcile(&claim)
+		c.reconcile(ctx, &claim)
+	}
+
+	c.reconcileRunning(ctx)
+	c.reconcileTerminating(ctx)
+}
+
+// claimPhases lists every phase exported by Collect, so that empty phases report zero.
+var claimPhases = []models.GpuClaimPhase{
+	models.GpuClaimPhasePending,
+	models.GpuClaimPhaseScheduled,
+	models.GpuClaimPhaseRunning,
+	models.GpuClaimPhaseFailed,
+	models.GpuClaimPhaseCompleted,
+	models.GpuClaimPhaseTerminating,
+}
+
+// Collect implements metrics.Collector and exports the number of claims in each phase.
+func (c *Controller) Collect() []*metrics.Family {
+	claims, err := c.store.ListByPhase(claimPhases...)
+	if err != nil {
+		log.Printf("Error listing GPU claims for metrics: %v", err)
+		return nil
+	}
+
+	counts := make(map[models.GpuClaimPhase]int)
+	for _, claim := range claims {
+		counts[claim.Status.Phase]++
 	}
+
+	family := metrics.NewFamily("utopia_gpu_claims", "Number of GPU claims by phase.", metrics.TypeGauge)
+	for _, phase := range claimPhases {
+		family.Add(float64(counts[phase]), "phase", string(phase))
+	}
+	return []*metrics.Family{family}
 }
 
-func (c *Controller) reconcile(claim *models.GpuClaim) {
+func (c *Controller) reconcile(ctx context.Context, claim *models.GpuClaim) {
 	log.Printf("Reconciling GpuClaim %s in phase %s", claim.ID, claim.Status.Phase)
 
 	switch claim.Status.Phase {
 	case models.GpuClaimPhasePending:
 		c.reconcilePending(claim)
 	case models.GpuClaimPhaseScheduled:
-		c.reconcileScheduled(claim)
+		c.reconcileScheduled(ctx, claim)
 	}
 }
 
@@ -81,17 +129,16 @@ func (c *Controller) reconcilePending(claim *models.GpuClaim) {
 
 	log.Printf("GpuClaim %s scheduled to node %s", claim.ID, node.ID)
 	claim.Status.Phase = models.GpuClaimPhaseScheduled
-	claim.Status.NodeName = fmt.Sprintf("%d", node.ID)
+	claim.Status.NodeName = node.ID
 
 	if err := c.store.Update(claim); err != nil {
 		log.Printf("Failed to update GpuClaim %s after scheduling: %v", claim.ID, err)
 	}
 }
 
-func (c *Controller) reconcileScheduled(claim *models.GpuClaim) {
-	nodeID, err := strconv.ParseInt(claim.Status.NodeName, 10, 64)
-	if err != nil {
-		log.Printf("Failed to parse node ID %s for GpuClaim %s: %v", claim.Status.NodeName, claim.ID, err)
+func (c *Controller) reconcileScheduled(ctx context.Context, claim *models.GpuClaim) {
+	if !node.IsValidNodeRef(claim.Status.NodeName) {
+		log.Printf("Invalid node ID %s for GpuClaim %s", claim.Status.NodeName, claim.ID)
 		claim.Status.Phase = models.GpuClaimPhaseFailed
 		claim.Status.Reason = "InvalidNodeID"
 		if err := c.store.Update(claim); err != nil {
@@ -99,7 +146,7 @@ func (c *Controller) reconcileScheduled(claim *models.GpuClaim) {
 		}
 		return
 	}
-	node, err := c.nodeStore.GetNode(nodeID)
+	targetNode, err := node.ResolveNode(c.nodeStore, claim.Status.NodeName)
 	if err != nil {
 		log.Printf("Failed to get node %s for GpuClaim %s: %v", claim.Status.NodeName, claim.ID, err)
 		claim.Status.Phase = models.GpuClaimPhaseFailed
@@ -110,22 +157,184 @@ func (c *Controller) reconcileScheduled(claim *models.GpuClaim) {
 		return
 	}
 
-	containerID, err := c.agentClient.CreateContainer(node, claim)
+	// A previous attempt may have created the container without the result
+	// being recorded; look for it before creating another one.
+	if claim.Status.SubPhase == models.GpuClaimSubPhaseCreating {
+		if containerID := c.findClaimContainer(ctx, targetNode, claim); containerID != "" {
+			log.Printf("Found container %s of GpuClaim %s on node %s from an earlier attempt", containerID, claim.ID, targetNode.ID)
+			c.markRunning(claim, containerID)
+			return
+		}
+	} else {
+		claim.Status.SubPhase = models.GpuClaimSubPhaseCreating
+		if err := c.store.Update(claim); err != nil {
+			log.Printf("Failed to mark GpuClaim %s as creating: %v", claim.ID, err)
+			return
+		}
+	}
+
+	containerID, err := c.agentClient.CreateContainer(ctx, targetNode, claim)
 	if err != nil {
-		log.Printf("Failed to create container for GpuClaim %s on node %s: %v", claim.ID, node.ID, err)
+		if ctx.Err() != nil {
+			return // shutting down; the claim stays Scheduled and Creating
+		}
+		log.Printf("Failed to create container for GpuClaim %s on node %s: %v", claim.ID, targetNode.ID, err)
 		claim.Status.Phase = models.GpuClaimPhaseFailed
+		claim.Status.SubPhase = ""
 		claim.Status.Reason = "ContainerCreationError"
+		if client.IsUnreachable(err) {
+			claim.Status.Reason = "AgentUnreachable"
+		}
 		if err := c.store.Update(claim); err != nil {
 			log.Printf("Failed to update GpuClaim %s to Failed: %v", claim.ID, err)
 		}
 		return
 	}
 
-	log.Printf("Container %s created for GpuClaim %s on node %s", containerID, claim.ID, node.ID)
+	log.Printf("Container %s created for GpuClaim %s on node %s", containerID, claim.ID, targetNode.ID)
+	c.markRunning(claim, containerID)
+}
+
+// findClaimContainer returns the ID of the container labelled with the claim
+// on the node, or "" if there is none or the agent cannot be asked. Agents
+// that honour the idempotency key would return the same container from
+// CreateContainer; this lookup also covers agents that ignore it. Agents
+// that cannot list containers (v0) are not asked.
+func (c *Controller) findClaimContainer(ctx context.Context, targetNode *models.Node, claim *models.GpuClaim) string {
+	containers, err := c.agentClient.ListContainers(ctx, targetNode)
+	if errors.Is(err, client.ErrUnsupportedOperation) {
+		return ""
+	}
+	if err != nil {
+		log.Printf("Failed to list containers on node %s for GpuClaim %s: %v", targetNode.ID, claim.ID, err)
+		return ""
+	}
+	for _, container := range containers {
+		if container.ClaimID() == claim.ID {
+			return container.ID
+		}
+	}
+	return ""
+}
+
+// markRunning records the claim's container and moves the claim to Running.
+// If the update fails the claim stays Scheduled and Creating, and the next
+// pass finds the container again.
+func (c *Controller) markRunning(claim *models.GpuClaim, containerID string) {
 	claim.Status.Phase = models.GpuClaimPhaseRunning
+	claim.Status.SubPhase = ""
 	claim.Status.ContainerID = containerID
 
 	if err := c.store.Update(claim); err != nil {
 		log.Printf("Failed to update GpuClaim %s to Running: %v", claim.ID, err)
 	}
 }
+
+// reconcileRunning checks the containers of Running claims, one ListContainers
+// call per node. A claim whose container exited becomes Completed (exit code 0)
+// or Failed; a claim whose container disappeared becomes Failed. Claims on
+// nodes that are offline or unreachable are left alone until the node is back.
+// Claims on nodes whose agent cannot list containers (v0) stay Running: the
+// server does not manage the lifecycle of their containers.
+func (c *Controller) reconcileRunning(ctx context.Context) {
+	claims, err := c.store.ListByPhase(models.GpuClaimPhaseRunning)
+	if err != nil {
+		log.Printf("Error listing running GPU claims: %v", err)
+		return
+	}
+
+	byNode := make(map[string][]models.GpuClaim)
+	for _, claim := range claims {
+		byNode[claim.Status.NodeName] = append(byNode[claim.Status.NodeName], claim)
+	}
+
+	for nodeRef, nodeClaims := range byNode {
+		targetNode, err := node.ResolveNode(c.nodeStore, nodeRef)
+		if err != nil || targetNode.Status != models.NodeStatusOnline {
+			continue
+		}
+		containers, err := c.agentClient.ListContainers(ctx, targetNode)
+		if errors.Is(err, client.ErrUnsupportedOperation) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to list containers on node %s: %v", targetNode.ID, err)
+			continue
+		}
+		byID := make(map[string]models.ContainerInfo, len(containers))
+		for _, container := range containers {
+			byID[container.ID] = container
+		}
+
+		for i := range nodeClaims {
+			claim := &nodeClaims[i]
+			container, ok := byID[claim.Status.ContainerID]
+			switch {
+			case !ok:
+				log.Printf("Container %s of GpuClaim %s is missing from node %s", claim.Status.ContainerID, claim.ID, targetNode.ID)
+				claim.Status.Phase = models.GpuClaimPhaseFailed
+				claim.Status.Reason = "ContainerNotFound"
+			case container.Finished():
+				log.Printf("Container %s of GpuClaim %s exited with code %d", container.ID, claim.ID, container.ExitCode)
+				exitCode := container.ExitCode
+				claim.Status.ExitCode = &exitCode
+				claim.Status.Phase = models.GpuClaimPhaseCompleted
+				if exitCode != 0 {
+					claim.Status.Phase = models.GpuClaimPhaseFailed
+					claim.Status.Reason = "ContainerExited"
+				}
+			default:
+				continue
+			}
+			if err := c.store.Update(claim); err != nil {
+				log.Printf("Failed to update GpuClaim %s to %s: %v", claim.ID, claim.Status.Phase, err)
+			}
+		}
+	}
+}
+
+// reconcileTerminating stops and removes the containers of deleted claims and
+// then deletes the claims. A container that is already gone counts as removed;
+// any other error leaves the claim Terminating so the next pass retries. On
+// nodes whose agent cannot stop containers (v0) the claim is deleted and the
+// container is left to the node's operator.
+func (c *Controller) reconcileTerminating(ctx context.Context) {
+	claims, err := c.store.ListByPhase(models.GpuClaimPhaseTerminating)
+	if err != nil {
+		log.Printf("Error listing terminating GPU claims: %v", err)
+		return
+	}
+
+	for i := range claims {
+		claim := &claims[i]
+		if claim.Status.ContainerID != "" {
+			targetNode, err := node.ResolveNode(c.nodeStore, claim.Status.NodeName)
+			switch {
+			case errors.Is(err, node.ErrNodeNotFound):
+				// A node that no longer exists took its containers with it.
+			case err != nil:
+				log.Printf("Failed to resolve node %s of GpuClaim %s: %v", claim.Status.NodeName, claim.ID, err)
+				continue
+			default:
+				err := c.agentClient.StopContainer(ctx, targetNode, claim.Status.ContainerID)
+				if errors.Is(err, client.ErrUnsupportedOperation) {
+					log.Printf("Agent on node %s cannot stop containers; leaving container %s of GpuClaim %s in place", targetNode.ID, claim.Status.ContainerID, claim.ID)
+					break
+				}
+				if err != nil && !client.IsNotFound(err) {
+					log.Printf("Failed to stop container %s of GpuClaim %s: %v", claim.Status.ContainerID, claim.ID, err)
+					continue
+				}
+				if err := c.agentClient.RemoveContainer(ctx, targetNode, claim.Status.ContainerID); err != nil && !client.IsNotFound(err) {
+					log.Printf("Failed to remove container %s of GpuClaim %s: %v", claim.Status.ContainerID, claim.ID, err)
+					continue
+				}
+				log.Printf("Container %s of GpuClaim %s removed from node %s", claim.Status.ContainerID, claim.ID, targetNode.ID)
+			}
+		}
+
+		if err := c.store.DeleteGpuClaim(claim.ID); err != nil && !errors.Is(err, ErrGpuClaimNotFound) {
+			log.Printf("Failed to delete GpuClaim %s: %v", claim.ID, err)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"utopia-server/internal/client"
+	"utopia-server/internal/models"
+	"utopia-server/internal/node"
+	"utopia-server/internal/scheduler"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestController(t *testing.T, agent client.Agent) (*Controller, GpuClaimStore) {
+	t.Helper()
+	nodeStore := node.NewMemStore()
+	require.NoError(t, nodeStore.CreateNode(&models.Node{
+		Hostname:    "gpu-node-01",
+		Status:      models.NodeStatusOnline,
+		ControlPort: 7001,
+		Gpus:        []models.GpuInfo{{ID: 0}},
+	}))
+	store := NewMemStore()
+	return NewController(store, scheduler.NewScheduler(nodeStore), nodeStore, agent), store
+}
+
+func newTestClaim(t *testing.T, store GpuClaimStore) *models.GpuClaim {
+	t.Helper()
+	claim := &models.GpuClaim{ID: "claim-1", Status: models.GpuClaimStatus{Phase: models.GpuClaimPhasePending}}
+	claim.Spec.Image = "nvidia/cuda:12.2.0-base-ubuntu22.04"
+	claim.Spec.Resources.GpuCount = 1
+	require.NoError(t, store.CreateGpuClaim(claim))
+	return claim
+}
+
+func getClaim(t *testing.T, store GpuClaimStore, id string) models.GpuClaim {
+	t.Helper()
+	claim, err := store.GetGpuClaim(id)
+	require.NoError(t, err)
+	return *claim
+}
+
+// startClaim reconciles a new claim until its container is running.
+func startClaim(t *testing.T, ctrl *Controller, store GpuClaimStore) models.GpuClaim {
+	t.Helper()
+	claim := newTestClaim(t, store)
+	ctrl.reconcileClaims(context.Background())
+	ctrl.reconcileClaims(context.Background())
+	running := getClaim(t, store, claim.ID)
+	require.Equal(t, models.GpuClaimPhaseRunning, running.Status.Phase)
+	return running
+}
+
+func TestController_SchedulesAndStartsClaim(t *testing.T) {
+	agent := client.NewFakeAgent()
+	ctrl, store := newTestController(t, agent)
+	claim := newTestClaim(t, store)
+
+	ctrl.reconcileClaims(context.Background())
+	assert.Equal(t, models.GpuClaimPhaseScheduled, getClaim(t, store, claim.ID).Status.Phase)
+
+	ctrl.reconcileClaims(context.Background())
+	running := getClaim(t, store, claim.ID)
+	assert.Equal(t, models.GpuClaimPhaseRunning, running.Status.Phase)
+	assert.Equal(t, claim.ID, agent.Containers()[running.Status.ContainerID])
+}
+
+func TestController_RunBlocksUntilStopped(t *testing.T) {
+	ctrl, _ := newTestController(t, client.NewFakeAgent())
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ctrl.Run(stopCh)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Run returned before stopCh was closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(stopCh)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after stopCh was closed")
+	}
+}
+
+func TestController_AgentErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		reason string
+	}{
+		{"rejected", &client.RejectedError{Op: "create_container", StatusCode: 400}, "ContainerCreationError"},
+		{"unreachable", &client.UnreachableError{Op: "create_container", Err: errors.New("connection refused")}, "AgentUnreachable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := client.NewFakeAgent()
+			agent.CreateErr = tt.err
+			ctrl, store := newTestController(t, agent)
+			claim := newTestClaim(t, store)
+
+			ctrl.reconcileClaims(context.Background())
+			ctrl.reconcileClaims(context.Background())
+			failed := getClaim(t, store, claim.ID)
+			assert.Equal(t, models.GpuClaimPhaseFailed, failed.Status.Phase)
+			assert.Equal(t, tt.reason, failed.Status.Reason)
+			assert.Empty(t, agent.Containers())
+		})
+	}
+}
+
+func TestController_ContainerExit(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode int
+		phase    models.GpuClaimPhase
+		reason   string
+	}{
+		{"success", 0, models.GpuClaimPhaseCompleted, ""},
+		{"failure", 1, models.GpuClaimPhaseFailed, "ContainerExited"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := client.NewFakeAgent()
+			ctrl, store := newTestController(t, agent)
+			running := startClaim(t, ctrl, store)
+
+			agent.SetContainerState(running.Status.ContainerID, models.ContainerStateExited, tt.exitCode)
+			ctrl.reconcileClaims(context.Background())
+
+			exited := getClaim(t, store, running.ID)
+			assert.Equal(t, tt.phase, exited.Status.Phase)
+			assert.Equal(t, tt.reason, exited.Status.Reason)
+			require.NotNil(t, exited.Status.ExitCode)
+			assert.Equal(t, tt.exitCode, *exited.Status.ExitCode)
+		})
+	}
+}
+
+func TestController_ContainerMissing(t *testing.T) {
+	agent := client.NewFakeAgent()
+	ctrl, store := newTestController(t, agent)
+	running := startClaim(t, ctrl, store)
+
+	node := &models.Node{}
+	require.NoError(t, agent.RemoveContainer(context.Background(), node, running.Status.ContainerID))
+	ctrl.reconcileClaims(context.Background())
+
+	failed := getClaim(t, store, running.ID)
+	assert.Equal(t, models.GpuClaimPhaseFailed, failed.Status.Phase)
+	assert.Equal(t, "ContainerNotFound", failed.Status.Reason)
+}
+
+func TestController_AgentUnreachableKeepsRunning(t *testing.T) {
+	agent := client.NewFakeAgent()
+	ctrl, store := newTestController(t, agent)
+	running := startClaim(t, ctrl, store)
+
+	agent.LifecycleErr = &client.UnreachableError{Op: "list_containers", Err: errors.New("connection refused")}
+	ctrl.reconcileClaims(context.Background())
+	assert.Equal(t, models.GpuClaimPhaseRunning, getClaim(t, store, running.ID).Status.Phase)
+}
+
+func TestController_TerminatingClaim(t *testing.T) {
+	agent := client.NewFakeAgent()
+	ctrl, store := newTestController(t, agent)
+	running := startClaim(t, ctrl, store)
+
+	running.Status.Phase = models.GpuClaimPhaseTerminating
+	require.NoError(t, store.Update(&running))
+
+	// The claim survives while the agent cannot remove the container.
+	agent.LifecycleErr = &client.UnreachableError{Op: "stop_container", Err: errors.New("connection refused")}
+	ctrl.reconcileClaims(context.Background())
+	assert.Equal(t, models.GpuClaimPhaseTerminating, getClaim(t, store, running.ID).Status.Phase)
+	assert.Len(t, agent.Containers(), 1)
+
+	agent.LifecycleErr = nil
+	ctrl.reconcileClaims(context.Background())
+	_, err := store.GetGpuClaim(running.ID)
+	assert.ErrorIs(t, err, ErrGpuClaimNotFound)
+	assert.Empty(t, agent.Containers())
+}
+
+func TestController_V0AgentSkipsLifecycle(t *testing.T) {
+	agent := client.NewFakeAgent()
+	ctrl, store := newTestController(t, agent)
+	running := startClaim(t, ctrl, store)
+
+	// v0 agents cannot list containers, so the claim is not failed as missing.
+	agent.LifecycleErr = client.ErrUnsupportedOperation
+	ctrl.reconcileClaims(context.Background())
+	assert.Equal(t, models.GpuClaimPhaseRunning, getClaim(t, store, running.ID).Status.Phase)
+
+	// Nor can they stop it; the claim is deleted and the container left in place.
+	running.Status.Phase = models.GpuClaimPhaseTerminating
+	require.NoError(t, store.Update(&running))
+	ctrl.reconcileClaims(context.Background())
+	_, err := store.GetGpuClaim(running.ID)
+	assert.ErrorIs(t, err, ErrGpuClaimNotFound)
+	assert.Len(t, agent.Containers(), 1)
+}
+
+// flakyNodeStore fails node lookups with err while it is set.
+type flakyNodeStore struct {
+	node.Store
+	err error
+}
+
+func (s *flakyNodeStore) GetNode(id string) (*models.Node, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Store.GetNode(id)
+}
+
+func TestController_TerminatingClaimKeptWhenNodeLookupFails(t *testing.T) {
+	agent := client.NewFakeAgent()
+	nodeStore := &flakyNodeStore{Store: node.NewMemStore()}
+	require.NoError(t, nodeStore.CreateNode(&models.Node{Hostname: "gpu-node-01", Status: models.NodeStatusOnline, ControlPort: 7001, Gpus: []models.GpuInfo{{ID: 0}}}))
+	store := NewMemStore()
+	ctrl := NewController(store, scheduler.NewScheduler(nodeStore), nodeStore, agent)
+	running := startClaim(t, ctrl, store)
+
+	running.Status.Phase = models.GpuClaimPhaseTerminating
+	require.NoError(t, store.Update(&running))
+
+	// A database error is not proof that the node and its container are gone.
+	nodeStore.err = errors.New("database unavailable")
+	ctrl.reconcileTerminating(context.Background())
+	assert.Equal(t, models.GpuClaimPhaseTerminating, getClaim(t, store, running.ID).Status.Phase)
+	assert.Len(t, agent.Containers(), 1)
+
+	// A node that was deleted took its container with it.
+	nodeStore.err = nil
+	require.NoError(t, nodeStore.DeleteNode(running.Status.NodeName))
+	ctrl.reconcileTerminating(context.Background())
+	_, err := store.GetGpuClaim(running.ID)
+	assert.ErrorIs(t, err, ErrGpuClaimNotFound)
+}
+
+func TestController_FindsContainerBeforeRecreating(t *testing.T) {
+	agent := client.NewFakeAgent()
+	ctrl, store := newTestController(t, agent)
+	claim := newTestClaim(t, store)
+	ctrl.reconcileClaims(context.Background())
+
+	// The agent creates the container but the server shuts down before
+	// recording it.
+	agent.LostCreateErr = &client.UnreachableError{Op: "create_container", Err: context.Canceled}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctrl.reconcileClaims(ctx)
+	creating := getClaim(t, store, claim.ID)
+	assert.Equal(t, models.GpuClaimPhaseScheduled, creating.Status.Phase)
+	assert.Equal(t, models.GpuClaimSubPhaseCreating, creating.Status.SubPhase)
+	require.Len(t, agent.Containers(), 1)
+
+	agent.LostCreateErr = nil
+	ctrl.reconcileClaims(context.Background())
+	running := getClaim(t, store, claim.ID)
+	assert.Equal(t, models.GpuClaimPhaseRunning, running.Status.Phase)
+	assert.Empty(t, running.Status.SubPhase)
+	assert.Equal(t, claim.ID, agent.Containers()[running.Status.ContainerID])
+	assert.Equal(t, 1, agent.CreateCalls(), "the existing container is found without creating again")
+}
@@ -0,0 +1,271 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"utopia-server/internal/client"
+	"utopia-server/internal/config"
+	"utopia-server/internal/metrics"
+	"utopia-server/internal/models"
+	"utopia-server/internal/node"
+)
+
+// Orphan actions reported by ContainerGC.
+const (
+	// OrphanAdopt means the container belongs to a claim that lost track of it
+	// and will be recorded as the claim's container.
+	OrphanAdopt = "Adopt"
+	// OrphanRemove means the container has no claim and is older than the grace period.
+	OrphanRemove = "Remove"
+	// OrphanWait means the container has no claim but is still within the grace period.
+	OrphanWait = "Wait"
+)
+
+// OrphanContainer is a container on a node that no claim points to.
+type OrphanContainer struct {
+	NodeID      string    `json:"nodeId"`
+	Hostname    string    `json:"hostname"`
+	ContainerID string    `json:"containerId"`
+	ClaimID     string    `json:"claimId,omitempty"`
+	State       string    `json:"state"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Action      string    `json:"action"`
+	Reason      string    `json:"reason"`
+}
+
+// OrphanReport summarises one garbage collection pass.
+type OrphanReport struct {
+	DryRun       bool              `json:"dryRun"`
+	NodesScanned int               `json:"nodesScanned"`
+	Orphans      []OrphanContainer `json:"orphans"`
+	// Errors maps node IDs that could not be scanned or cleaned to the error.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// ContainerGC finds containers that no claim points to, for example because
+// the server crashed between the agent creating a container and the claim
+// being marked Running. A container labelled with the ID of a claim that has
+// no container yet on the same node is adopted by that claim; any other
+// unknown container is removed once it is older than the grace period.
+type ContainerGC struct {
+	store       GpuClaimStore
+	nodeStore   node.Store
+	agentClient client.Agent
+	config      config.GCConfig
+}
+
+// NewContainerGC creates a new container garbage collector.
+func NewContainerGC(store GpuClaimStore, nodeStore node.Store, agentClient client.Agent, cfg config.GCConfig) *ContainerGC {
+	return &ContainerGC{
+		store:       store,
+		nodeStore:   nodeStore,
+		agentClient: agentClient,
+		config:      cfg,
+	}
+}
+
+// Run collects orphaned containers every Interval seconds until stopCh is closed.
+func (g *ContainerGC) Run(stopCh <-chan struct{}) {
+	if g.config.Interval <= 0 {
+		log.Println("Container garbage collection is disabled")
+		return
+	}
+	ticker := time.NewTicker(time.Duration(g.config.Interval) * time.Second)
+	defer ticker.Stop()
+
+	// Closing stopCh also cancels the agent calls of a pass that is still running.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	log.Println("Container garbage collector started")
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := g.Collect(ctx, false); err != nil {
+				log.Printf("Error collecting orphaned containers: %v", err)
+			}
+		case <-ctx.Done():
+			log.Println("Container garbage collector stopped")
+			return
+		}
+	}
+}
+
+// Collect scans every online node for orphaned containers. With dryRun it
+// only reports what it would do. Nodes whose agent cannot list containers
+// (v0) are skipped.
+func (g *ContainerGC) Collect(ctx context.Context, dryRun bool) (*OrphanReport, error) {
+	start := time.Now()
+	defer func() {
+		metrics.ReconcileDuration.Observe(time.Since(start).Seconds(), "container_gc")
+	}()
+
+	claims, err := g.store.ListGpuClaims("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list gpu claims: %w", err)
+	}
+	claimsByID := make(map[string]*models.GpuClaim, len(claims))
+	knownContainers := make(map[string]bool, len(claims))
+	for i := range claims {
+		claimsByID[claims[i].ID] = &claims[i]
+		if claims[i].Status.ContainerID != "" {
+			knownContainers[claims[i].Status.ContainerID] = true
+		}
+	}
+
+	nodes, err := g.nodeStore.ListNodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	report := &OrphanReport{DryRun: dryRun, Orphans: []OrphanContainer{}}
+	for _, n := range nodes {
+		if n.Status != models.NodeStatusOnline || n.Endpoint() == "" {
+			continue
+		}
+		containers, err := g.agentClient.ListContainers(ctx, n)
+		if errors.Is(err, client.ErrUnsupportedOperation) {
+			continue
+		}
+		if err != nil {
+			report.addError(n.ID, err)
+			continue
+		}
+		report.NodesScanned++
+
+		for _, container := range containers {
+			if knownContainers[container.ID] {
+				continue
+			}
+			orphan := g.classify(n, container, claimsByID, start)
+			if orphan.Action == OrphanAdopt {
+				// A second container labelled with the same claim is then a duplicate.
+				claimsByID[orphan.ClaimID].Status.ContainerID = container.ID
+			}
+			if !dryRun {
+				if err := g.apply(ctx, n, &orphan); err != nil {
+					report.addError(n.ID, err)
+					continue
+				}
+			}
+			report.Orphans = append(report.Orphans, orphan)
+		}
+	}
+	return report, nil
+}
+
+// classify decides what to do with a container that no claim points to.
+func (g *ContainerGC) classify(n *models.Node, container models.ContainerInfo, claims map[string]*models.GpuClaim, now time.Time) OrphanContainer {
+	orphan := OrphanContainer{
+		NodeID:      n.ID,
+		Hostname:    n.Hostname,
+		ContainerID: container.ID,
+		ClaimID:     container.ClaimID(),
+		State:       container.State,
+		CreatedAt:   container.CreatedAt,
+	}
+
+	claim, ok := claims[orphan.ClaimID]
+	switch {
+	case orphan.ClaimID == "":
+		orphan.Reason = "container has no claim label"
+	case !ok:
+		orphan.Reason = "claim no longer exists"
+	default:
+		if orphan.Reason = notAdoptable(claim, n); orphan.Reason == "" {
+			orphan.Action = OrphanAdopt
+			orphan.Reason = "claim in phase " + string(claim.Status.Phase) + " has no container"
+			return orphan
+		}
+	}
+
+	orphan.Action = OrphanRemove
+	if now.Sub(container.CreatedAt) < time.Duration(g.config.GracePeriod)*time.Second {
+		orphan.Action = OrphanWait
+	}
+	return orphan
+}
+
+// apply adopts or removes an orphaned container.
+func (g *ContainerGC) apply(ctx context.Context, n *models.Node, orphan *OrphanContainer) error {
+	switch orphan.Action {
+	case OrphanAdopt:
+		// The claim list was read before the node scan; the controller may have
+		// moved the claim on since, so decide again on a fresh copy.
+		claim, err := g.store.GetGpuClaim(orphan.ClaimID)
+		if err != nil && !errors.Is(err, ErrGpuClaimNotFound) {
+			return fmt.Errorf("failed to get GpuClaim %s: %w", orphan.ClaimID, err)
+		}
+		reason := "claim no longer exists"
+		if err == nil {
+			reason = notAdoptable(claim, n)
+		}
+		if reason != "" {
+			orphan.Action = OrphanWait
+			orphan.Reason = reason
+			log.Printf("Not adopting container %s on node %s: %s", orphan.ContainerID, n.ID, reason)
+			return nil
+		}
+		claim.Status.Phase = models.GpuClaimPhaseRunning
+		claim.Status.SubPhase = ""
+		claim.Status.ContainerID = orphan.ContainerID
+		claim.Status.Reason = ""
+		if err := g.store.Update(claim); err != nil {
+			return fmt.Errorf("failed to adopt container %s for GpuClaim %s: %w", orphan.ContainerID, claim.ID, err)
+		}
+		metrics.OrphanContainers.Inc("adopted")
+		log.Printf("GpuClaim %s adopted orphaned container %s on node %s", claim.ID, orphan.ContainerID, n.ID)
+
+	case OrphanRemove:
+		if err := g.agentClient.StopContainer(ctx, n, orphan.ContainerID); err != nil && !client.IsNotFound(err) {
+			return fmt.Errorf("failed to stop orphaned container %s: %w", orphan.ContainerID, err)
+		}
+		if err := g.agentClient.RemoveContainer(ctx, n, orphan.ContainerID); err != nil && !client.IsNotFound(err) {
+			return fmt.Errorf("failed to remove orphaned container %s: %w", orphan.ContainerID, err)
+		}
+		metrics.OrphanContainers.Inc("removed")
+		log.Printf("Removed orphaned container %s on node %s: %s", orphan.ContainerID, n.ID, orphan.Reason)
+	}
+	return nil
+}
+
+// notAdoptable returns why an orphaned container on n cannot be adopted by
+// claim, or "" if it can. Only a scheduled claim that has not recorded a
+// container yet is waiting for one.
+func notAdoptable(claim *models.GpuClaim, n *models.Node) string {
+	switch {
+	case claim.Status.Phase == models.GpuClaimPhaseTerminating:
+		return "claim is being deleted"
+	case claim.Status.ContainerID != "":
+		return "claim already has container " + claim.Status.ContainerID
+	case claim.Status.Phase != models.GpuClaimPhaseScheduled && claim.Status.Phase != models.GpuClaimPhasePending:
+		return "claim in phase " + string(claim.Status.Phase) + " does not expect a container"
+	case !boundTo(claim, n):
+		return "claim is bound to another node"
+	}
+	return ""
+}
+
+// boundTo reports whether the claim is scheduled on n, by UUID or legacy ID.
+func boundTo(claim *models.GpuClaim, n *models.Node) bool {
+	return claim.Status.NodeName == n.ID || (n.LegacyID != 0 && claim.Status.NodeName == strconv.FormatInt(n.LegacyID, 10))
+}
+
+func (r *OrphanReport) addError(nodeID string, err error) {
+	if r.Errors == nil {
+		r.Errors = make(map[string]string)
+	}
+	r.Errors[nodeID] = err.Error()
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+	"utopia-server/internal/client"
+	"utopia-server/internal/config"
+	"utopia-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestGC(t *testing.T, ctrl *Controller, store GpuClaimStore, agent client.Agent) *ContainerGC {
+	t.Helper()
+	return NewContainerGC(store, ctrl.nodeStore, agent, config.GCConfig{GracePeriod: 600})
+}
+
+func TestContainerGC_AdoptsContainerOfCrashedCreate(t *testing.T) {
+	agent := client.NewFakeAgent()
+	ctrl, store := newTestController(t, agent)
+	gc := newTestGC(t, ctrl, store, agent)
+	claim := newTestClaim(t, store)
+	ctrl.reconcileClaims(context.Background())
+	scheduled := getClaim(t, store, claim.ID)
+
+	// The agent created the container but the server never recorded it.
+	containerID, err := agent.CreateContainer(context.Background(), &models.Node{}, &scheduled)
+	require.NoError(t, err)
+
+	report, err := gc.Collect(context.Background(), true)
+	require.NoError(t, err)
+	require.Len(t, report.Orphans, 1)
+	assert.Equal(t, OrphanAdopt, report.Orphans[0].Action)
+	assert.Equal(t, models.GpuClaimPhaseScheduled, getClaim(t, store, claim.ID).Status.Phase, "dry run changes nothing")
+
+	_, err = gc.Collect(context.Background(), false)
+	require.NoError(t, err)
+	adopted := getClaim(t, store, claim.ID)
+	assert.Equal(t, models.GpuClaimPhaseRunning, adopted.Status.Phase)
+	assert.Equal(t, containerID, adopted.Status.ContainerID)
+
+	report, err = gc.Collect(context.Background(), true)
+	require.NoError(t, err)
+	assert.Empty(t, report.Orphans)
+}
+
+func TestContainerGC_DoesNotAdoptForClaimThatMovedOn(t *testing.T) {
+	agent := client.NewFakeAgent()
+	ctrl, store := newTestController(t, agent)
+	gc := newTestGC(t, ctrl, store, agent)
+	claim := newTestClaim(t, store)
+	ctrl.reconcileClaims(context.Background())
+	scheduled := getClaim(t, store, claim.ID)
+
+	_, err := agent.CreateContainer(context.Background(), &models.Node{}, &scheduled)
+	require.NoError(t, err)
+	report, err := gc.Collect(context.Background(), true)
+	require.NoError(t, err)
+	require.Len(t, report.Orphans, 1)
+	orphan := report.Orphans[0]
+	require.Equal(t, OrphanAdopt, orphan.Action)
+
+	// The claim is deleted between the scan and the adoption.
+	scheduled.Status.Phase = models.GpuClaimPhaseTerminating
+	require.NoError(t, store.Update(&scheduled))
+
+	n, err := ctrl.nodeStore.GetNode(orphan.NodeID)
+	require.NoError(t, err)
+	require.NoError(t, gc.apply(context.Background(), n, &orphan))
+	assert.Equal(t, OrphanWait, orphan.Action)
+	current := getClaim(t, store, claim.ID)
+	assert.Equal(t, models.GpuClaimPhaseTerminating, current.Status.Phase)
+	assert.Empty(t, current.Status.ContainerID)
+}
+
+func TestContainerGC_RemovesUnknownContainersAfterGracePeriod(t *testing.T) {
+	agent := client.NewFakeAgent()
+	ctrl, store := newTestController(t, agent)
+	gc := newTestGC(t, ctrl, store, agent)
+	running := startClaim(t, ctrl, store)
+
+	agent.AddContainer(models.ContainerInfo{ID: "old", State: models.ContainerStateRunning, CreatedAt: time.Now().Add(-time.Hour)})
+	agent.AddContainer(models.ContainerInfo{
+		ID:        "deleted-claim",
+		State:     models.ContainerStateExited,
+		Labels:    map[string]string{models.ContainerLabelClaimID: "gone"},
+		CreatedAt: time.Now().Add(-time.Hour),
+	})
+	agent.AddContainer(models.ContainerInfo{ID: "new", State: models.ContainerStateRunning, CreatedAt: time.Now()})
+	agent.AddContainer(models.ContainerInfo{
+		ID:        "duplicate",
+		State:     models.ContainerStateRunning,
+		Labels:    map[string]string{models.ContainerLabelClaimID: running.ID},
+		CreatedAt: time.Now().Add(-time.Hour),
+	})
+
+	report, err := gc.Collect(context.Background(), false)
+	require.NoError(t, err)
+	actions := make(map[string]string)
+	for _, orphan := range report.Orphans {
+		actions[orphan.ContainerID] = orphan.Action
+	}
+	assert.Equal(t, map[string]string{
+		"old":           OrphanRemove,
+		"deleted-claim": OrphanRemove,
+		"new":           OrphanWait,
+		"duplicate":     OrphanRemove,
+	}, actions)
+
+	containers := agent.Containers()
+	assert.Len(t, containers, 2)
+	assert.Contains(t, containers, "new")
+	assert.Contains(t, containers, running.Status.ContainerID, "containers known to a claim are kept")
+}
+
+func TestContainerGC_SkipsV0Agents(t *testing.T) {
+	agent := client.NewFakeAgent()
+	ctrl, store := newTestController(t, agent)
+	gc := newTestGC(t, ctrl, store, agent)
+	agent.LifecycleErr = client.ErrUnsupportedOperation
+
+	report, err := gc.Collect(context.Background(), false)
+	require.NoError(t, err)
+	assert.Zero(t, report.NodesScanned)
+	assert.Empty(t, report.Errors)
+}
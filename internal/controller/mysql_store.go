@@ -3,6 +3,7 @@ package controller
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"utopia-server/internal/models"
 )
@@ -34,6 +35,65 @@ func (s *mysqlStore) CreateGpuClaim(claim *models.GpuClaim) error {
 	return nil
 }
 
+const gpuClaimColumns = "id, user_id, created_at, spec, status"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanGpuClaim(row rowScanner) (*models.GpuClaim, error) {
+	var claim models.GpuClaim
+	var spec, status []byte
+	if err := row.Scan(&claim.ID, &claim.UserID, &claim.CreatedAt, &spec, &status); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(spec, &claim.Spec); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal spec: %w", err)
+	}
+	if err := json.Unmarshal(status, &claim.Status); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal status: %w", err)
+	}
+	return &claim, nil
+}
+
+func (s *mysqlStore) GetGpuClaim(id string) (*models.GpuClaim, error) {
+	query := "SELECT " + gpuClaimColumns + " FROM gpu_claims WHERE id = ?"
+	claim, err := scanGpuClaim(s.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrGpuClaimNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gpu claim: %w", err)
+	}
+	return claim, nil
+}
+
+func (s *mysqlStore) ListGpuClaims(userID string) ([]models.GpuClaim, error) {
+	query := "SELECT " + gpuClaimColumns + " FROM gpu_claims"
+	var args []interface{}
+	if userID != "" {
+		query += " WHERE user_id = ?"
+		args = append(args, userID)
+	}
+	query += " ORDER BY created_at DESC"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list gpu claims: %w", err)
+	}
+	defer rows.Close()
+
+	claims := []models.GpuClaim{}
+	for rows.Next() {
+		claim, err := scanGpuClaim(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan gpu claim: %w", err)
+		}
+		claims = append(claims, *claim)
+	}
+	return claims, rows.Err()
+}
+
 func (s *mysqlStore) ListPendingGpuClaims() ([]*models.GpuClaim, error) {
 	query := `SELECT id, user_id, created_at, spec, status FROM gpu_claims WHERE status->>"$.phase" = 'Pending'`
 	rows, err := s.db.Query(query)
@@ -77,6 +137,21 @@ func (s *mysqlStore) Update(claim *models.GpuClaim) error {
 	return nil
 }
 
+func (s *mysqlStore) DeleteGpuClaim(id string) error {
+	result, err := s.db.Exec("DELETE FROM gpu_claims WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete gpu claim: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if deleted == 0 {
+		return ErrGpuClaimNotFound
+	}
+	return nil
+}
+
 func (s *mysqlStore) ListByPhase(phases ...models.GpuClaimPhase) ([]models.GpuClaim, error) {
 	if len(phases) == 0 {
 		return []models.GpuClaim{}, nil
@@ -120,3 +195,93 @@ func (s *mysqlStore) ListByPhase(phases ...models.GpuClaimPhase) ([]models.GpuCl
 
 	return claims, nil
 }
+
+type mysqlPrepullStore struct {
+	db *sql.DB
+}
+
+func NewMySQLPrepullStore(db *sql.DB) PrepullStore {
+	return &mysqlPrepullStore{db: db}
+}
+
+const prepullJobColumns = "id, image, status, progress, created_by, nodes, created_at, finished_at"
+
+func scanPrepullJob(row rowScanner) (*models.PrepullJob, error) {
+	var job models.PrepullJob
+	var createdBy sql.NullString
+	var finishedAt sql.NullTime
+	var nodes []byte
+	if err := row.Scan(&job.ID, &job.Image, &job.Status, &job.Progress, &createdBy, &nodes, &job.CreatedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+	job.CreatedBy = createdBy.String
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	if err := json.Unmarshal(nodes, &job.Nodes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal prepull nodes: %w", err)
+	}
+	return &job, nil
+}
+
+func (s *mysqlPrepullStore) CreatePrepullJob(job *models.PrepullJob) error {
+	nodes, err := json.Marshal(job.Nodes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal prepull nodes: %w", err)
+	}
+	query := "INSERT INTO image_prepull_jobs (" + prepullJobColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	if _, err := s.db.Exec(query, job.ID, job.Image, job.Status, job.Progress, job.CreatedBy, nodes, job.CreatedAt, job.FinishedAt); err != nil {
+		return fmt.Errorf("failed to create prepull job: %w", err)
+	}
+	return nil
+}
+
+func (s *mysqlPrepullStore) GetPrepullJob(id string) (*models.PrepullJob, error) {
+	query := "SELECT " + prepullJobColumns + " FROM image_prepull_jobs WHERE id = ?"
+	job, err := scanPrepullJob(s.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPrepullJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prepull job: %w", err)
+	}
+	return job, nil
+}
+
+func (s *mysqlPrepullStore) ListPrepullJobs(status string) ([]models.PrepullJob, error) {
+	query := "SELECT " + prepullJobColumns + " FROM image_prepull_jobs"
+	var args []interface{}
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY created_at DESC"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list prepull jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []models.PrepullJob{}
+	for rows.Next() {
+		job, err := scanPrepullJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan prepull job: %w", err)
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *mysqlPrepullStore) UpdatePrepullJob(job *models.PrepullJob) error {
+	nodes, err := json.Marshal(job.Nodes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal prepull nodes: %w", err)
+	}
+	query := "UPDATE image_prepull_jobs SET status = ?, progress = ?, nodes = ?, finished_at = ? WHERE id = ?"
+	if _, err := s.db.Exec(query, job.Status, job.Progress, nodes, job.FinishedAt, job.ID); err != nil {
+		return fmt.Errorf("failed to update prepull job: %w", err)
+	}
+	return nil
+}
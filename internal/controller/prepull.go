@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"utopia-server/internal/client"
+	"utopia-server/internal/config"
+	"utopia-server/internal/metrics"
+	"utopia-server/internal/models"
+	"utopia-server/internal/node"
+
+	"github.com/google/uuid"
+)
+
+// Prepuller runs image pre-pull jobs. It asks the agent of every selected node
+// to pull the image in the background, then polls the progress until each node
+// has finished or the job times out. Nodes that are offline wait; an agent
+// that lost track of a pull, for example after a restart, is asked again.
+type Prepuller struct {
+	store       PrepullStore
+	nodeStore   node.Store
+	agentClient client.Agent
+	config      config.PrepullConfig
+}
+
+// NewPrepuller creates a new image pre-puller.
+func NewPrepuller(store PrepullStore, nodeStore node.Store, agentClient client.Agent, cfg config.PrepullConfig) *Prepuller {
+	return &Prepuller{
+		store:       store,
+		nodeStore:   nodeStore,
+		agentClient: agentClient,
+		config:      cfg,
+	}
+}
+
+// Start creates a job that pulls image on nodes. Nodes that already report the
+// image in their cache succeed right away; the other pulls start on the next
+// reconcile.
+func (p *Prepuller) Start(image, createdBy string, nodes []*models.Node) (*models.PrepullJob, error) {
+	now := time.Now()
+	job := &models.PrepullJob{
+		ID:        uuid.NewString(),
+		Image:     image,
+		CreatedBy: createdBy,
+		Nodes:     make([]models.PrepullNode, 0, len(nodes)),
+		CreatedAt: now,
+	}
+	for _, n := range nodes {
+		entry := models.PrepullNode{NodeID: n.ID, Hostname: n.Hostname, Phase: models.PrepullPhasePending}
+		if n.HasImage(image) {
+			entry.Phase = models.PrepullPhaseSucceeded
+			entry.Progress = 100
+			entry.Message = "image already cached"
+			entry.FinishedAt = &now
+		}
+		job.Nodes = append(job.Nodes, entry)
+	}
+	updatePrepullJobStatus(job, now)
+
+	if err := p.store.CreatePrepullJob(job); err != nil {
+		return nil, fmt.Errorf("failed to create prepull job: %w", err)
+	}
+	log.Printf("Started prepull job %s for image %s on %d nodes", job.ID, image, len(nodes))
+	return job, nil
+}
+
+// GetJob returns the job with the given ID.
+func (p *Prepuller) GetJob(id string) (*models.PrepullJob, error) {
+	return p.store.GetPrepullJob(id)
+}
+
+// ListJobs returns the jobs with the given status, newest first; an empty status lists every job.
+func (p *Prepuller) ListJobs(status string) ([]models.PrepullJob, error) {
+	return p.store.ListPrepullJobs(status)
+}
+
+// Run advances the running jobs every Interval seconds until stopCh is closed.
+func (p *Prepuller) Run(stopCh <-chan struct{}) {
+	if p.config.Interval <= 0 {
+		log.Println("Image prepull is disabled")
+		return
+	}
+	ticker := time.NewTicker(time.Duration(p.config.Interval) * time.Second)
+	defer ticker.Stop()
+
+	// Closing stopCh also cancels the agent calls of a pass that is still running.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	log.Println("Image prepuller started")
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.Reconcile(ctx); err != nil {
+				log.Printf("Error reconciling prepull jobs: %v", err)
+			}
+		case <-ctx.Done():
+			log.Println("Image prepuller stopped")
+			return
+		}
+	}
+}
+
+// Reconcile advances every unfinished node of every running job by one step.
+func (p *Prepuller) Reconcile(ctx context.Context) error {
+	start := time.Now()
+	defer func() {
+		metrics.ReconcileDuration.Observe(time.Since(start).Seconds(), "image_prepull")
+	}()
+
+	jobs, err := p.store.ListPrepullJobs(models.PrepullJobRunning)
+	if err != nil {
+		return fmt.Errorf("failed to list prepull jobs: %w", err)
+	}
+
+	for i := range jobs {
+		job := &jobs[i]
+		for j := range job.Nodes {
+			if !job.Nodes[j].Finished() {
+				p.advance(ctx, job, &job.Nodes[j], start)
+			}
+		}
+		updatePrepullJobStatus(job, start)
+		if err := p.store.UpdatePrepullJob(job); err != nil {
+			log.Printf("Error updating prepull job %s: %v", job.ID, err)
+			continue
+		}
+		if job.Status != models.PrepullJobRunning {
+			log.Printf("Prepull job %s for image %s finished: %s", job.ID, job.Image, job.Status)
+		}
+	}
+	return nil
+}
+
+// advance starts or polls the pull on a single node.
+func (p *Prepuller) advance(ctx context.Context, job *models.PrepullJob, entry *models.PrepullNode, now time.Time) {
+	if p.config.Timeout > 0 && now.Sub(job.CreatedAt) > time.Duration(p.config.Timeout)*time.Second {
+		failPrepull(entry, "timed out", now)
+		return
+	}
+
+	n, err := p.nodeStore.GetNode(entry.NodeID)
+	if err != nil {
+		failPrepull(entry, "node no longer exists", now)
+		return
+	}
+	if n.Status != models.NodeStatusOnline || n.Endpoint() == "" {
+		entry.Message = "waiting for the node to come online"
+		return
+	}
+
+	var status *models.ImagePullStatus
+	if entry.Phase == models.PrepullPhasePending {
+		status, err = p.agentClient.PullImage(ctx, n, job.Image)
+		if err == nil {
+			entry.Phase = models.PrepullPhasePulling
+			entry.StartedAt = &now
+		}
+	} else {
+		status, err = p.agentClient.ImagePullStatus(ctx, n, job.Image)
+		if client.IsNotFound(err) {
+			entry.Phase = models.PrepullPhasePending
+			entry.Message = "agent lost track of the pull, starting it again"
+			return
+		}
+	}
+	switch {
+	case err == nil:
+	case client.IsUnreachable(err):
+		entry.Message = err.Error() // try again on the next reconcile
+		return
+	default:
+		failPrepull(entry, err.Error(), now)
+		return
+	}
+
+	entry.Message = ""
+	switch status.State {
+	case models.ImagePullStateDone:
+		entry.Phase = models.PrepullPhaseSucceeded
+		entry.Progress = 100
+		entry.FinishedAt = &now
+	case models.ImagePullStateFailed:
+		failPrepull(entry, status.Error, now)
+	default:
+		entry.Progress = min(max(status.Progress, 0), 100)
+	}
+}
+
+func failPrepull(entry *models.PrepullNode, message string, now time.Time) {
+	entry.Phase = models.PrepullPhaseFailed
+	entry.Message = message
+	entry.FinishedAt = &now
+}
+
+// updatePrepullJobStatus derives the job's progress and status from its nodes.
+func updatePrepullJobStatus(job *models.PrepullJob, now time.Time) {
+	progress, finished, failed := 0, 0, 0
+	for _, entry := range job.Nodes {
+		progress += entry.Progress
+		if entry.Finished() {
+			finished++
+		}
+		if entry.Phase == models.PrepullPhaseFailed {
+			failed++
+		}
+	}
+	if len(job.Nodes) > 0 {
+		job.Progress = progress / len(job.Nodes)
+	}
+
+	switch {
+	case finished < len(job.Nodes):
+		job.Status = models.PrepullJobRunning
+	case failed > 0:
+		job.Status = models.PrepullJobFailed
+		job.FinishedAt = &now
+	default:
+		job.Status = models.PrepullJobCompleted
+		job.Progress = 100
+		job.FinishedAt = &now
+	}
+}
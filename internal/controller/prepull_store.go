@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"utopia-server/internal/models"
+)
+
+// ErrPrepullJobNotFound is returned when an image pre-pull job does not exist.
+var ErrPrepullJobNotFound = errors.New("prepull job not found")
+
+// PrepullStore defines the interface for image pre-pull job storage.
+type PrepullStore interface {
+	CreatePrepullJob(job *models.PrepullJob) error
+	GetPrepullJob(id string) (*models.PrepullJob, error)
+	// ListPrepullJobs returns the jobs with the given status, newest first; an empty status lists every job.
+	ListPrepullJobs(status string) ([]models.PrepullJob, error)
+	UpdatePrepullJob(job *models.PrepullJob) error
+}
+
+// memPrepullStore is an in-memory implementation of PrepullStore for testing.
+type memPrepullStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*models.PrepullJob
+}
+
+// NewMemPrepullStore creates a new in-memory PrepullStore.
+func NewMemPrepullStore() PrepullStore {
+	return &memPrepullStore{jobs: make(map[string]*models.PrepullJob)}
+}
+
+func (s *memPrepullStore) CreatePrepullJob(job *models.PrepullJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = copyPrepullJob(job)
+	return nil
+}
+
+func (s *memPrepullStore) GetPrepullJob(id string) (*models.PrepullJob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrPrepullJobNotFound
+	}
+	return copyPrepullJob(job), nil
+}
+
+func (s *memPrepullStore) ListPrepullJobs(status string) ([]models.PrepullJob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []models.PrepullJob{}
+	for _, job := range s.jobs {
+		if status == "" || job.Status == status {
+			result = append(result, *copyPrepullJob(job))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	return result, nil
+}
+
+func (s *memPrepullStore) UpdatePrepullJob(job *models.PrepullJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[job.ID]; !ok {
+		return ErrPrepullJobNotFound
+	}
+	s.jobs[job.ID] = copyPrepullJob(job)
+	return nil
+}
+
+// copyPrepullJob copies the job and its node list so callers cannot modify the stored job.
+func copyPrepullJob(job *models.PrepullJob) *models.PrepullJob {
+	copied := *job
+	copied.Nodes = append([]models.PrepullNode(nil), job.Nodes...)
+	return &copied
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+	"utopia-server/internal/client"
+	"utopia-server/internal/config"
+	"utopia-server/internal/models"
+	"utopia-server/internal/node"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const prepullImage = "nvcr.io/nvidia/pytorch:24.01-py3"
+
+func newTestPrepuller(t *testing.T, agent client.Agent, nodes ...*models.Node) (*Prepuller, PrepullStore) {
+	t.Helper()
+	nodeStore := node.NewMemStore()
+	for _, n := range nodes {
+		require.NoError(t, nodeStore.CreateNode(n))
+	}
+	store := NewMemPrepullStore()
+	return NewPrepuller(store, nodeStore, agent, config.PrepullConfig{Interval: 5, Timeout: 3600}), store
+}
+
+func getPrepullJob(t *testing.T, store PrepullStore, id string) *models.PrepullJob {
+	t.Helper()
+	job, err := store.GetPrepullJob(id)
+	require.NoError(t, err)
+	return job
+}
+
+func TestPrepuller_TracksProgressUntilCompleted(t *testing.T) {
+	agent := client.NewFakeAgent()
+	cold := &models.Node{ID: "cold", Hostname: "gpu-node-01", Status: models.NodeStatusOnline, ControlPort: 7001}
+	warm := &models.Node{ID: "warm", Hostname: "gpu-node-02", Status: models.NodeStatusOnline, ControlPort: 7002,
+		Images: []models.CachedImage{{Reference: prepullImage}}}
+	prepuller, store := newTestPrepuller(t, agent, cold, warm)
+
+	job, err := prepuller.Start(prepullImage, "admin", []*models.Node{cold, warm})
+	require.NoError(t, err)
+	assert.Equal(t, models.PrepullJobRunning, job.Status)
+	assert.Equal(t, models.PrepullPhaseSucceeded, job.Nodes[1].Phase, "cached images are not pulled again")
+	assert.Equal(t, 50, job.Progress)
+
+	require.NoError(t, prepuller.Reconcile(context.Background()))
+	assert.True(t, agent.Pulling("cold", prepullImage))
+	assert.False(t, agent.Pulling("warm", prepullImage))
+	assert.Equal(t, models.PrepullPhasePulling, getPrepullJob(t, store, job.ID).Nodes[0].Phase)
+
+	agent.SetPullStatus("cold", models.ImagePullStatus{Image: prepullImage, State: models.ImagePullStatePulling, Progress: 40})
+	require.NoError(t, prepuller.Reconcile(context.Background()))
+	updated := getPrepullJob(t, store, job.ID)
+	assert.Equal(t, 40, updated.Nodes[0].Progress)
+	assert.Equal(t, 70, updated.Progress)
+
+	agent.SetPullStatus("cold", models.ImagePullStatus{Image: prepullImage, State: models.ImagePullStateDone, Progress: 100})
+	require.NoError(t, prepuller.Reconcile(context.Background()))
+	updated = getPrepullJob(t, store, job.ID)
+	assert.Equal(t, models.PrepullJobCompleted, updated.Status)
+	assert.Equal(t, 100, updated.Progress)
+	assert.NotNil(t, updated.FinishedAt)
+}
+
+func TestPrepuller_FailuresAndOfflineNodes(t *testing.T) {
+	agent := client.NewFakeAgent()
+	broken := &models.Node{ID: "broken", Status: models.NodeStatusOnline, ControlPort: 7001}
+	offline := &models.Node{ID: "offline", Status: models.NodeStatusOffline}
+	prepuller, store := newTestPrepuller(t, agent, broken, offline)
+
+	job, err := prepuller.Start(prepullImage, "admin", []*models.Node{broken, offline})
+	require.NoError(t, err)
+
+	require.NoError(t, prepuller.Reconcile(context.Background()))
+	agent.SetPullStatus("broken", models.ImagePullStatus{Image: prepullImage, State: models.ImagePullStateFailed, Error: "manifest unknown"})
+	require.NoError(t, prepuller.Reconcile(context.Background()))
+
+	updated := getPrepullJob(t, store, job.ID)
+	assert.Equal(t, models.PrepullPhaseFailed, updated.Nodes[0].Phase)
+	assert.Equal(t, "manifest unknown", updated.Nodes[0].Message)
+	assert.Equal(t, models.PrepullPhasePending, updated.Nodes[1].Phase, "offline nodes wait")
+	assert.Equal(t, models.PrepullJobRunning, updated.Status)
+
+	prepuller.config.Timeout = 1
+	updated.CreatedAt = time.Now().Add(-time.Minute)
+	require.NoError(t, store.UpdatePrepullJob(updated))
+	require.NoError(t, prepuller.Reconcile(context.Background()))
+
+	updated = getPrepullJob(t, store, job.ID)
+	assert.Equal(t, "timed out", updated.Nodes[1].Message)
+	assert.Equal(t, models.PrepullJobFailed, updated.Status)
+}
@@ -1,17 +1,26 @@
 package controller
 
 import (
+	"errors"
+	"sort"
 	"sync"
 
 	"utopia-server/internal/models"
 )
 
+// ErrGpuClaimNotFound is returned when a GPU claim does not exist.
+var ErrGpuClaimNotFound = errors.New("gpu claim not found")
+
 // GpuClaimStore defines the interface for GPU claim storage.
 type GpuClaimStore interface {
 	CreateGpuClaim(claim *models.GpuClaim) error
+	GetGpuClaim(id string) (*models.GpuClaim, error)
+	// ListGpuClaims returns the claims of userID, newest first; an empty userID lists every claim.
+	ListGpuClaims(userID string) ([]models.GpuClaim, error)
 	ListPendingGpuClaims() ([]*models.GpuClaim, error)
 	ListByPhase(phases ...models.GpuClaimPhase) ([]models.GpuClaim, error)
 	Update(claim *models.GpuClaim) error
+	DeleteGpuClaim(id string) error
 }
 
 // memStore is an in-memory implementation of GpuClaimStore for testing.
@@ -35,6 +44,34 @@ func (s *memStore) CreateGpuClaim(claim *models.GpuClaim) error {
 	return nil
 }
 
+// GetGpuClaim returns a copy of the claim with the given ID.
+func (s *memStore) GetGpuClaim(id string) (*models.GpuClaim, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	claim, ok := s.claims[id]
+	if !ok {
+		return nil, ErrGpuClaimNotFound
+	}
+	copied := *claim
+	return &copied, nil
+}
+
+// ListGpuClaims returns copies of the claims owned by userID, newest first.
+func (s *memStore) ListGpuClaims(userID string) ([]models.GpuClaim, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []models.GpuClaim{}
+	for _, claim := range s.claims {
+		if userID == "" || claim.UserID == userID {
+			result = append(result, *claim)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	return result, nil
+}
+
 // ListPendingGpuClaims returns all claims with a "Pending" status.
 func (s *memStore) ListPendingGpuClaims() ([]*models.GpuClaim, error) {
 	s.mu.RLock()
@@ -72,3 +109,14 @@ func (s *memStore) Update(claim *models.GpuClaim) error {
 	s.claims[claim.ID] = claim
 	return nil
 }
+
+// DeleteGpuClaim removes a GPU claim from the store.
+func (s *memStore) DeleteGpuClaim(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.claims[id]; !ok {
+		return ErrGpuClaimNotFound
+	}
+	delete(s.claims, id)
+	return nil
+}
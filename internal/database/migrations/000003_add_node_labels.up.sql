@@ -0,0 +1,2 @@
+ALTER TABLE `nodes` ADD COLUMN `labels` JSON AFTER `status`;
+UPDATE `nodes` SET `labels` = JSON_OBJECT() WHERE `labels` IS NULL;
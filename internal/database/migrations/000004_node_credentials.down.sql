@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `bootstrap_tokens`;
+ALTER TABLE `nodes` DROP COLUMN `credential_hash`;
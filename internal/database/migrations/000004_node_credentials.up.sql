@@ -0,0 +1,14 @@
+ALTER TABLE `nodes` ADD COLUMN `credential_hash` VARCHAR(64);
+
+CREATE TABLE `bootstrap_tokens` (
+    `id` INT NOT NULL AUTO_INCREMENT,
+    `token_hash` VARCHAR(64) NOT NULL UNIQUE,
+    `description` VARCHAR(255),
+    `single_use` BOOLEAN NOT NULL DEFAULT FALSE,
+    `expires_at` TIMESTAMP NULL,
+    `use_count` INT NOT NULL DEFAULT 0,
+    `last_used_at` TIMESTAMP NULL,
+    `created_by` VARCHAR(255),
+    `created_at` TIMESTAMP,
+    PRIMARY KEY (`id`)
+);
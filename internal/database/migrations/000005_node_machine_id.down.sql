@@ -0,0 +1,2 @@
+ALTER TABLE `nodes` DROP INDEX `uniq_nodes_machine_id`;
+ALTER TABLE `nodes` DROP COLUMN `machine_id`;
@@ -0,0 +1,2 @@
+ALTER TABLE `nodes` ADD COLUMN `machine_id` VARCHAR(255) NULL;
+ALTER TABLE `nodes` ADD UNIQUE KEY `uniq_nodes_machine_id` (`machine_id`);
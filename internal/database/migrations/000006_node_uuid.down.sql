@@ -0,0 +1,12 @@
+-- 回滚到自增整数 ID。升级后注册的节点没有 legacy_id，回滚时会被删除。
+UPDATE `gpu_claims` c
+JOIN `nodes` n ON JSON_UNQUOTE(JSON_EXTRACT(c.`status`, '$.nodeName')) = n.`id`
+SET c.`status` = JSON_SET(c.`status`, '$.nodeName', CAST(n.`legacy_id` AS CHAR))
+WHERE n.`legacy_id` IS NOT NULL;
+
+DELETE FROM `nodes` WHERE `legacy_id` IS NULL;
+
+ALTER TABLE `nodes` DROP PRIMARY KEY;
+ALTER TABLE `nodes` DROP INDEX `uniq_nodes_legacy_id`;
+ALTER TABLE `nodes` DROP COLUMN `id`;
+ALTER TABLE `nodes` CHANGE `legacy_id` `id` INT NOT NULL AUTO_INCREMENT PRIMARY KEY FIRST;
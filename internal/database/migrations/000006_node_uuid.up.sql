@@ -0,0 +1,15 @@
+-- 为每个已有节点分配 UUID，并保留原自增 ID 作为 legacy_id 以兼容旧 agent。
+ALTER TABLE `nodes` ADD COLUMN `uuid` VARCHAR(36) NULL;
+UPDATE `nodes` SET `uuid` = UUID();
+
+-- 将 GpuClaim 中引用的整数节点 ID 改写为对应的 UUID。
+UPDATE `gpu_claims` c
+JOIN `nodes` n ON JSON_UNQUOTE(JSON_EXTRACT(c.`status`, '$.nodeName')) = CAST(n.`id` AS CHAR)
+SET c.`status` = JSON_SET(c.`status`, '$.nodeName', n.`uuid`);
+
+ALTER TABLE `nodes` MODIFY `id` INT NOT NULL;
+ALTER TABLE `nodes` DROP PRIMARY KEY;
+ALTER TABLE `nodes` CHANGE `id` `legacy_id` INT NULL;
+ALTER TABLE `nodes` CHANGE `uuid` `id` VARCHAR(36) NOT NULL FIRST;
+ALTER TABLE `nodes` ADD PRIMARY KEY (`id`);
+ALTER TABLE `nodes` ADD UNIQUE KEY `uniq_nodes_legacy_id` (`legacy_id`);
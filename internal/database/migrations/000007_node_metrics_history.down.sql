@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `gpu_metric_samples`;
+DROP TABLE IF EXISTS `node_metric_samples`;
@@ -0,0 +1,25 @@
+CREATE TABLE `node_metric_samples` (
+    `node_id` VARCHAR(36) NOT NULL,
+    `resolution` INT NOT NULL,
+    `ts` DATETIME NOT NULL,
+    `cpu_usage_percent` DOUBLE,
+    `memory_usage_percent` DOUBLE,
+    `memory_used_mb` DOUBLE,
+    `memory_total_mb` INT,
+    PRIMARY KEY (`node_id`, `resolution`, `ts`),
+    KEY `idx_node_metric_samples_resolution_ts` (`resolution`, `ts`)
+);
+
+CREATE TABLE `gpu_metric_samples` (
+    `node_id` VARCHAR(36) NOT NULL,
+    `gpu_index` INT NOT NULL,
+    `resolution` INT NOT NULL,
+    `ts` DATETIME NOT NULL,
+    `gpu_uuid` VARCHAR(255),
+    `usage_percent` DOUBLE,
+    `memory_used_mb` DOUBLE,
+    `memory_total_mb` INT,
+    `temperature_c` DOUBLE,
+    PRIMARY KEY (`node_id`, `gpu_index`, `resolution`, `ts`),
+    KEY `idx_gpu_metric_samples_resolution_ts` (`resolution`, `ts`)
+);
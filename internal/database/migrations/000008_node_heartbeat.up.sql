@@ -0,0 +1 @@
+ALTER TABLE `nodes` ADD COLUMN `last_heartbeat` TIMESTAMP NULL;
@@ -0,0 +1 @@
+ALTER TABLE `nodes` ADD COLUMN `address` VARCHAR(255) NULL;
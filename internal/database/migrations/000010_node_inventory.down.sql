@@ -0,0 +1,2 @@
+ALTER TABLE `nodes` DROP COLUMN `declared_at`;
+ALTER TABLE `nodes` DROP COLUMN `expected_gpus`;
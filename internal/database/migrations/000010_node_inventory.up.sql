@@ -0,0 +1,2 @@
+ALTER TABLE `nodes` ADD COLUMN `expected_gpus` INT NOT NULL DEFAULT 0;
+ALTER TABLE `nodes` ADD COLUMN `declared_at` TIMESTAMP NULL;
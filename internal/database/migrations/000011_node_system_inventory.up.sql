@@ -0,0 +1,2 @@
+ALTER TABLE `nodes` ADD COLUMN `inventory` JSON NULL;
+ALTER TABLE `nodes` ADD COLUMN `system` JSON NULL;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS `gpu_health`;
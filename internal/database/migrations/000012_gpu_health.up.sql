@@ -0,0 +1,15 @@
+CREATE TABLE `gpu_health` (
+    `node_id` VARCHAR(36) NOT NULL,
+    `gpu_index` INT NOT NULL,
+    `gpu_uuid` VARCHAR(255),
+    `state` VARCHAR(32) NOT NULL,
+    `reason` VARCHAR(255),
+    `suspect_count` INT NOT NULL DEFAULT 0,
+    `clean_count` INT NOT NULL DEFAULT 0,
+    `ecc_corrected` BIGINT NOT NULL DEFAULT 0,
+    `ecc_uncorrected` BIGINT NOT NULL DEFAULT 0,
+    `quarantined_at` TIMESTAMP NULL,
+    `updated_at` TIMESTAMP NOT NULL,
+    PRIMARY KEY (`node_id`, `gpu_index`),
+    FOREIGN KEY (`node_id`) REFERENCES `nodes`(`id`) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+ALTER TABLE `nodes` DROP COLUMN `credentials_issued_at`;
+ALTER TABLE `nodes` DROP COLUMN `agent_secret`;
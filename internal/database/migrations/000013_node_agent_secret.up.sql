@@ -0,0 +1,2 @@
+ALTER TABLE `nodes` ADD COLUMN `agent_secret` VARCHAR(255) NULL;
+ALTER TABLE `nodes` ADD COLUMN `credentials_issued_at` TIMESTAMP NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE `nodes` DROP COLUMN `certificate_expires_at`;
+ALTER TABLE `nodes` DROP COLUMN `certificate_serial`;
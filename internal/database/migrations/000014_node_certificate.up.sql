@@ -0,0 +1,2 @@
+ALTER TABLE `nodes` ADD COLUMN `certificate_serial` VARCHAR(64) NULL;
+ALTER TABLE `nodes` ADD COLUMN `certificate_expires_at` TIMESTAMP NULL;
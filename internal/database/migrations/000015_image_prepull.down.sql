@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `image_prepull_jobs`;
+ALTER TABLE `nodes` DROP COLUMN `images`;
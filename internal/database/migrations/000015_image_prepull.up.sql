@@ -0,0 +1,14 @@
+ALTER TABLE `nodes` ADD COLUMN `images` JSON NULL;
+
+CREATE TABLE `image_prepull_jobs` (
+    `id` VARCHAR(36) NOT NULL,
+    `image` VARCHAR(512) NOT NULL,
+    `status` VARCHAR(32) NOT NULL,
+    `progress` INT NOT NULL DEFAULT 0,
+    `created_by` VARCHAR(255),
+    `nodes` JSON,
+    `created_at` TIMESTAMP NOT NULL,
+    `finished_at` TIMESTAMP NULL,
+    PRIMARY KEY (`id`),
+    KEY `idx_image_prepull_jobs_status` (`status`)
+);
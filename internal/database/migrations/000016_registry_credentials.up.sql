@@ -0,0 +1,10 @@
+CREATE TABLE `registry_credentials` (
+    `id` VARCHAR(36) NOT NULL,
+    `registry` VARCHAR(255) NOT NULL,
+    `username` VARCHAR(255) NOT NULL,
+    `password` TEXT NOT NULL,
+    `created_at` TIMESTAMP NOT NULL,
+    `updated_at` TIMESTAMP NOT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_registry_credentials_registry` (`registry`)
+);
@@ -0,0 +1 @@
+ALTER TABLE `nodes` DROP COLUMN `claimed_machine_id`;
@@ -0,0 +1 @@
+ALTER TABLE `nodes` ADD COLUMN `claimed_machine_id` VARCHAR(255) NULL;
@@ -0,0 +1 @@
+ALTER TABLE `nodes` ADD COLUMN `requested_address` VARCHAR(255) NULL;
@@ -0,0 +1,5 @@
+-- 同一序号上有多块 GPU 的记录时只保留最近更新的一份。
+DELETE `old` FROM `gpu_health` `old`
+JOIN `gpu_health` `newer` ON `old`.`node_id` = `newer`.`node_id` AND `old`.`gpu_index` = `newer`.`gpu_index`
+    AND (`old`.`updated_at` < `newer`.`updated_at` OR (`old`.`updated_at` = `newer`.`updated_at` AND `old`.`gpu_key` < `newer`.`gpu_key`));
+ALTER TABLE `gpu_health` DROP PRIMARY KEY, ADD PRIMARY KEY (`node_id`, `gpu_index`), DROP COLUMN `gpu_key`;
@@ -0,0 +1,4 @@
+-- GPU 健康状态按 GPU 标识（UUID，没有 UUID 时为 "index:序号"）记录，换卡或序号变化后不会互相覆盖。
+ALTER TABLE `gpu_health` ADD COLUMN `gpu_key` VARCHAR(255) NULL AFTER `node_id`;
+UPDATE `gpu_health` SET `gpu_key` = IF(`gpu_uuid` IS NULL OR `gpu_uuid` = '', CONCAT('index:', `gpu_index`), `gpu_uuid`);
+ALTER TABLE `gpu_health` MODIFY `gpu_key` VARCHAR(255) NOT NULL, DROP PRIMARY KEY, ADD PRIMARY KEY (`node_id`, `gpu_key`);
@@ -0,0 +1,315 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"utopia-server/internal/config"
+	"utopia-server/internal/models"
+)
+
+var (
+	// ErrInvalidRange 表示查询的时间范围或步长不合法。
+	ErrInvalidRange = errors.New("invalid time range")
+	// ErrTooManyPoints 表示查询结果的点数超过 MaxQueryPoints。
+	ErrTooManyPoints = errors.New("too many points requested")
+)
+
+const (
+	// MaxQueryPoints 是单次查询每条序列允许返回的最大点数。
+	MaxQueryPoints = 10000
+	// defaultQueryPoints 是未指定 step 时每条序列的目标点数。
+	defaultQueryPoints = 300
+)
+
+// tier 是一个采样精度层级，resolution 为 0 表示原始采样。
+type tier struct {
+	resolution int
+	retention  time.Duration
+}
+
+// Service 负责记录节点指标、定期降采样和清理过期数据，并提供历史查询。
+//
+// 原始采样保留 RawRetention 秒；每个降采样层级由上一层级按 Resolution 秒分桶取平均得到，
+// 并保留 Retention 秒。查询时自动选择仍覆盖起始时间的最细层级。
+type Service struct {
+	store  Store
+	config config.HistoryConfig
+	tiers  []tier
+
+	mu         sync.Mutex
+	watermarks map[int]time.Time // 每个降采样层级已完成聚合的截止时间
+}
+
+// NewService 创建一个新的 history Service 实例。
+func NewService(store Store, cfg config.HistoryConfig) *Service {
+	tiers := []tier{{resolution: 0, retention: time.Duration(cfg.RawRetention) * time.Second}}
+	rollups := append([]config.RollupConfig(nil), cfg.Rollups...)
+	sort.Slice(rollups, func(i, j int) bool { return rollups[i].Resolution < rollups[j].Resolution })
+	for _, rollup := range rollups {
+		if rollup.Resolution <= 0 {
+			continue
+		}
+		tiers = append(tiers, tier{resolution: rollup.Resolution, retention: time.Duration(rollup.Retention) * time.Second})
+	}
+
+	return &Service{
+		store:      store,
+		config:     cfg,
+		tiers:      tiers,
+		watermarks: make(map[int]time.Time),
+	}
+}
+
+// Record 保存一次健康检查采集到的节点指标。
+func (s *Service) Record(nodeID string, metrics *models.NodeMetrics, at time.Time) error {
+	ts := at.Truncate(time.Second)
+
+	cpu := metrics.System.CPUUsagePercent
+	if cpu == 0 {
+		cpu = metrics.CPUUsagePercent
+	}
+	memory := metrics.System.MemoryUsagePercent
+	if memory == 0 {
+		memory = metrics.MemoryUsagePercent
+	}
+
+	nodeSample := &models.NodeMetricSample{
+		NodeID:             nodeID,
+		Timestamp:          ts,
+		CPUUsagePercent:    cpu,
+		MemoryUsagePercent: memory,
+		MemoryUsedMB:       float64(metrics.System.MemoryUsedMB),
+		MemoryTotalMB:      metrics.System.MemoryTotalMB,
+	}
+
+	gpuSamples := make([]models.GpuMetricSample, 0, len(metrics.Gpus))
+	for _, gpu := range metrics.Gpus {
+		gpuSamples = append(gpuSamples, models.GpuMetricSample{
+			NodeID:        nodeID,
+			GpuIndex:      gpu.ID,
+			GpuUUID:       gpu.UUID,
+			Timestamp:     ts,
+			UsagePercent:  float64(gpu.UsagePercent),
+			MemoryUsedMB:  float64(gpu.MemoryUsedMB),
+			MemoryTotalMB: gpu.MemoryTotalMB,
+			TemperatureC:  float64(gpu.TemperatureC),
+		})
+	}
+
+	if err := s.store.InsertSamples(nodeSample, gpuSamples); err != nil {
+		return fmt.Errorf("failed to record metrics for node %s: %w", nodeID, err)
+	}
+	return nil
+}
+
+// Query 返回节点在 [from, to) 内的指标历史，按 step 秒分桶取平均。
+// step 为 0 时根据时间范围自动选择，使每条序列约有 defaultQueryPoints 个点。
+func (s *Service) Query(nodeID string, from, to time.Time, step int) (*models.NodeMetricsHistory, error) {
+	if !from.Before(to) || step < 0 {
+		return nil, ErrInvalidRange
+	}
+
+	span := to.Sub(from)
+	if step == 0 {
+		step = int((span + defaultQueryPoints*time.Second - 1) / (defaultQueryPoints * time.Second))
+	}
+
+	source := s.selectTier(time.Since(from), step)
+	step = max(step, source.resolution, 1)
+
+	if points := int(span / (time.Duration(step) * time.Second)); points > MaxQueryPoints {
+		return nil, fmt.Errorf("%w: %d points exceed the limit of %d, increase step", ErrTooManyPoints, points, MaxQueryPoints)
+	}
+
+	nodeSamples, err := s.store.QueryNodeSamples(nodeID, source.resolution, from, to)
+	if err != nil {
+		return nil, err
+	}
+	gpuSamples, err := s.store.QueryGpuSamples(nodeID, source.resolution, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.NodeMetricsHistory{
+		NodeID:     nodeID,
+		From:       from,
+		To:         to,
+		Step:       step,
+		Resolution: source.resolution,
+		Node:       rebucketNodeSamples(nodeSamples, step),
+		Gpus:       []models.GpuMetricSeries{},
+	}
+
+	byIndex := make(map[int][]models.GpuMetricSample)
+	var indexes []int
+	for _, sample := range gpuSamples {
+		if _, ok := byIndex[sample.GpuIndex]; !ok {
+			indexes = append(indexes, sample.GpuIndex)
+		}
+		byIndex[sample.GpuIndex] = append(byIndex[sample.GpuIndex], sample)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		samples := byIndex[index]
+		result.Gpus = append(result.Gpus, models.GpuMetricSeries{
+			Index:   index,
+			UUID:    samples[len(samples)-1].GpuUUID,
+			Samples: rebucketGpuSamples(samples, step),
+		})
+	}
+
+	return result, nil
+}
+
+// selectTier 在仍覆盖 age 的层级中选择精度不超过 step 的最粗层级；
+// 如果没有层级覆盖 age，则使用保留时间最长的最粗层级。
+func (s *Service) selectTier(age time.Duration, step int) tier {
+	var chosen *tier
+	for i := range s.tiers {
+		t := &s.tiers[i]
+		if t.retention < age {
+			continue
+		}
+		if chosen == nil || t.resolution <= step {
+			chosen = t
+		}
+	}
+	if chosen == nil {
+		return s.tiers[len(s.tiers)-1]
+	}
+	return *chosen
+}
+
+// Run 定期执行降采样和过期数据清理，直到 stopCh 被关闭。
+func (s *Service) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(max(s.config.CompactInterval, 1)) * time.Second)
+	defer ticker.Stop()
+
+	log.Println("Metrics history service started")
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Compact(time.Now()); err != nil {
+				log.Printf("Error compacting metrics history: %v", err)
+			}
+		case <-stopCh:
+			log.Println("Metrics history service stopped")
+			return
+		}
+	}
+}
+
+// Compact 将各层级已结束的桶聚合到下一层级，然后删除超过保留时间的采样。
+// 聚合是幂等的，服务重启后会从上一层级保留范围的起点重新聚合。
+func (s *Service) Compact(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 1; i < len(s.tiers); i++ {
+		source, target := s.tiers[i-1], s.tiers[i]
+
+		end := bucketStart(now, target.resolution)
+		start, ok := s.watermarks[target.resolution]
+		if !ok {
+			start = bucketStart(now.Add(-source.retention), target.resolution)
+		}
+		if !start.Before(end) {
+			continue
+		}
+		if err := s.store.Rollup(source.resolution, target.resolution, start, end); err != nil {
+			return fmt.Errorf("failed to roll up resolution %d into %d: %w", source.resolution, target.resolution, err)
+		}
+		s.watermarks[target.resolution] = end
+	}
+
+	for _, t := range s.tiers {
+		if err := s.store.DeleteBefore(t.resolution, now.Add(-t.retention)); err != nil {
+			return fmt.Errorf("failed to apply retention for resolution %d: %w", t.resolution, err)
+		}
+	}
+	return nil
+}
+
+// bucketStart 返回 t 所在的、按 Unix 时间对齐的 resolution 秒桶的起始时间。
+func bucketStart(t time.Time, resolution int) time.Time {
+	if resolution <= 0 {
+		return t
+	}
+	seconds := t.Unix()
+	return time.Unix(seconds-seconds%int64(resolution), 0).UTC()
+}
+
+func averageNodeSamples(samples []models.NodeMetricSample) models.NodeMetricSample {
+	avg := samples[0]
+	var cpu, memory, memoryUsed float64
+	for _, sample := range samples {
+		cpu += sample.CPUUsagePercent
+		memory += sample.MemoryUsagePercent
+		memoryUsed += sample.MemoryUsedMB
+		avg.MemoryTotalMB = max(avg.MemoryTotalMB, sample.MemoryTotalMB)
+	}
+	n := float64(len(samples))
+	avg.CPUUsagePercent = cpu / n
+	avg.MemoryUsagePercent = memory / n
+	avg.MemoryUsedMB = memoryUsed / n
+	return avg
+}
+
+func averageGpuSamples(samples []models.GpuMetricSample) models.GpuMetricSample {
+	avg := samples[0]
+	var usage, memoryUsed, temperature float64
+	for _, sample := range samples {
+		usage += sample.UsagePercent
+		memoryUsed += sample.MemoryUsedMB
+		temperature += sample.TemperatureC
+		avg.MemoryTotalMB = max(avg.MemoryTotalMB, sample.MemoryTotalMB)
+		if sample.GpuUUID != "" {
+			avg.GpuUUID = sample.GpuUUID
+		}
+	}
+	n := float64(len(samples))
+	avg.UsagePercent = usage / n
+	avg.MemoryUsedMB = memoryUsed / n
+	avg.TemperatureC = temperature / n
+	return avg
+}
+
+// rebucketNodeSamples 将按时间排序的采样按 step 秒分桶取平均。
+func rebucketNodeSamples(samples []models.NodeMetricSample, step int) []models.NodeMetricSample {
+	result := []models.NodeMetricSample{}
+	for start := 0; start < len(samples); {
+		bucket := bucketStart(samples[start].Timestamp, step)
+		end := start + 1
+		for end < len(samples) && bucketStart(samples[end].Timestamp, step).Equal(bucket) {
+			end++
+		}
+		avg := averageNodeSamples(samples[start:end])
+		avg.Timestamp = bucket
+		result = append(result, avg)
+		start = end
+	}
+	return result
+}
+
+// rebucketGpuSamples 将同一 GPU 按时间排序的采样按 step 秒分桶取平均。
+func rebucketGpuSamples(samples []models.GpuMetricSample, step int) []models.GpuMetricSample {
+	result := []models.GpuMetricSample{}
+	for start := 0; start < len(samples); {
+		bucket := bucketStart(samples[start].Timestamp, step)
+		end := start + 1
+		for end < len(samples) && bucketStart(samples[end].Timestamp, step).Equal(bucket) {
+			end++
+		}
+		avg := averageGpuSamples(samples[start:end])
+		avg.Timestamp = bucket
+		result = append(result, avg)
+		start = end
+	}
+	return result
+}
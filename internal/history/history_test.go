@@ -0,0 +1,82 @@
+package history
+
+import (
+	"testing"
+	"time"
+	"utopia-server/internal/config"
+	"utopia-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(store Store) *Service {
+	return NewService(store, config.HistoryConfig{
+		RawRetention: 3600,
+		Rollups: []config.RollupConfig{
+			{Resolution: 300, Retention: 86400},
+		},
+	})
+}
+
+func testMetrics(cpu float64, gpuUsage int) *models.NodeMetrics {
+	return &models.NodeMetrics{
+		System: models.SystemMetrics{CPUUsagePercent: cpu, MemoryUsagePercent: 50, MemoryTotalMB: 1024, MemoryUsedMB: 512},
+		Gpus:   []models.GpuInfo{{ID: 0, UUID: "GPU-0", UsagePercent: gpuUsage, TemperatureC: 60, MemoryTotalMB: 8192}},
+	}
+}
+
+func TestQuery_RawSamplesAveragedByStep(t *testing.T) {
+	service := newTestService(NewMemStore())
+	start := time.Now().Add(-10 * time.Minute).Truncate(time.Minute)
+
+	require.NoError(t, service.Record("node-a", testMetrics(10, 20), start))
+	require.NoError(t, service.Record("node-a", testMetrics(30, 40), start.Add(15*time.Second)))
+	require.NoError(t, service.Record("node-b", testMetrics(90, 90), start))
+
+	result, err := service.Query("node-a", start, start.Add(time.Minute), 60)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Resolution)
+	require.Len(t, result.Node, 1)
+	assert.InDelta(t, 20, result.Node[0].CPUUsagePercent, 0.001)
+	require.Len(t, result.Gpus, 1)
+	assert.Equal(t, "GPU-0", result.Gpus[0].UUID)
+	assert.InDelta(t, 30, result.Gpus[0].Samples[0].UsagePercent, 0.001)
+}
+
+func TestCompact_RollsUpAndAppliesRetention(t *testing.T) {
+	store := NewMemStore()
+	service := newTestService(store)
+	now := time.Now()
+	old := bucketStart(now.Add(-2*time.Hour), 300)
+
+	// 两小时前的原始采样已超过原始保留期，但在首次压缩时仍会被聚合到 5 分钟精度。
+	require.NoError(t, service.Record("node-a", testMetrics(10, 0), old))
+	require.NoError(t, service.Record("node-a", testMetrics(50, 0), old.Add(time.Minute)))
+	service.watermarks[300] = bucketStart(now.Add(-3*time.Hour), 300)
+	require.NoError(t, service.Compact(now))
+
+	raw, err := store.QueryNodeSamples("node-a", 0, old, now)
+	require.NoError(t, err)
+	assert.Empty(t, raw)
+
+	result, err := service.Query("node-a", old, old.Add(time.Hour), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 300, result.Resolution)
+	require.Len(t, result.Node, 1)
+	assert.InDelta(t, 30, result.Node[0].CPUUsagePercent, 0.001)
+}
+
+func TestQuery_RejectsTooManyPoints(t *testing.T) {
+	service := NewService(NewMemStore(), config.HistoryConfig{RawRetention: 86400})
+	to := time.Now()
+
+	_, err := service.Query("node-a", to.Add(-20*time.Hour), to, 0)
+	assert.NoError(t, err)
+
+	_, err = service.Query("node-a", to.Add(-20*time.Hour), to, 1)
+	assert.ErrorIs(t, err, ErrTooManyPoints)
+
+	_, err = service.Query("node-a", to, to.Add(-time.Hour), 0)
+	assert.ErrorIs(t, err, ErrInvalidRange)
+}
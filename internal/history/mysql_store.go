@@ -0,0 +1,163 @@
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"utopia-server/internal/models"
+)
+
+type mysqlStore struct {
+	db *sql.DB
+}
+
+func NewMySQLStore(db *sql.DB) Store {
+	return &mysqlStore{db: db}
+}
+
+// bucketExpr 计算 ts 所在桶的起始秒数。使用 TIMESTAMPDIFF 而不是 UNIX_TIMESTAMP，
+// 使分桶边界不受会话时区影响，与 Go 侧按 Unix 时间分桶保持一致。
+const bucketExpr = "FLOOR(TIMESTAMPDIFF(SECOND, '1970-01-01 00:00:00', ts) / ?) * ?"
+
+func (s *mysqlStore) InsertSamples(node *models.NodeMetricSample, gpus []models.GpuMetricSample) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if node != nil {
+		query := `
+			INSERT INTO node_metric_samples (node_id, resolution, ts, cpu_usage_percent, memory_usage_percent, memory_used_mb, memory_total_mb)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE cpu_usage_percent = VALUES(cpu_usage_percent), memory_usage_percent = VALUES(memory_usage_percent),
+				memory_used_mb = VALUES(memory_used_mb), memory_total_mb = VALUES(memory_total_mb)
+		`
+		if _, err := tx.Exec(query, node.NodeID, node.Resolution, node.Timestamp, node.CPUUsagePercent, node.MemoryUsagePercent, node.MemoryUsedMB, node.MemoryTotalMB); err != nil {
+			return fmt.Errorf("failed to insert node metric sample: %w", err)
+		}
+	}
+
+	if len(gpus) > 0 {
+		stmt, err := tx.Prepare(`
+			INSERT INTO gpu_metric_samples (node_id, gpu_index, resolution, ts, gpu_uuid, usage_percent, memory_used_mb, memory_total_mb, temperature_c)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE gpu_uuid = VALUES(gpu_uuid), usage_percent = VALUES(usage_percent), memory_used_mb = VALUES(memory_used_mb),
+				memory_total_mb = VALUES(memory_total_mb), temperature_c = VALUES(temperature_c)
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to prepare gpu metric insert: %w", err)
+		}
+		defer stmt.Close()
+
+		for _, gpu := range gpus {
+			if _, err := stmt.Exec(gpu.NodeID, gpu.GpuIndex, gpu.Resolution, gpu.Timestamp, gpu.GpuUUID, gpu.UsagePercent, gpu.MemoryUsedMB, gpu.MemoryTotalMB, gpu.TemperatureC); err != nil {
+				return fmt.Errorf("failed to insert metric sample for gpu %d: %w", gpu.GpuIndex, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit metric samples: %w", err)
+	}
+	return nil
+}
+
+func (s *mysqlStore) QueryNodeSamples(nodeID string, resolution int, from, to time.Time) ([]models.NodeMetricSample, error) {
+	query := `
+		SELECT ts, cpu_usage_percent, memory_usage_percent, memory_used_mb, memory_total_mb
+		FROM node_metric_samples
+		WHERE node_id = ? AND resolution = ? AND ts >= ? AND ts < ?
+		ORDER BY ts
+	`
+	rows, err := s.db.Query(query, nodeID, resolution, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query node metric samples: %w", err)
+	}
+	defer rows.Close()
+
+	var samples []models.NodeMetricSample
+	for rows.Next() {
+		sample := models.NodeMetricSample{NodeID: nodeID, Resolution: resolution}
+		if err := rows.Scan(&sample.Timestamp, &sample.CPUUsagePercent, &sample.MemoryUsagePercent, &sample.MemoryUsedMB, &sample.MemoryTotalMB); err != nil {
+			return nil, fmt.Errorf("failed to scan node metric sample: %w", err)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+func (s *mysqlStore) QueryGpuSamples(nodeID string, resolution int, from, to time.Time) ([]models.GpuMetricSample, error) {
+	query := `
+		SELECT gpu_index, ts, gpu_uuid, usage_percent, memory_used_mb, memory_total_mb, temperature_c
+		FROM gpu_metric_samples
+		WHERE node_id = ? AND resolution = ? AND ts >= ? AND ts < ?
+		ORDER BY gpu_index, ts
+	`
+	rows, err := s.db.Query(query, nodeID, resolution, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query gpu metric samples: %w", err)
+	}
+	defer rows.Close()
+
+	var samples []models.GpuMetricSample
+	for rows.Next() {
+		sample := models.GpuMetricSample{NodeID: nodeID, Resolution: resolution}
+		var gpuUUID sql.NullString
+		if err := rows.Scan(&sample.GpuIndex, &sample.Timestamp, &gpuUUID, &sample.UsagePercent, &sample.MemoryUsedMB, &sample.MemoryTotalMB, &sample.TemperatureC); err != nil {
+			return nil, fmt.Errorf("failed to scan gpu metric sample: %w", err)
+		}
+		sample.GpuUUID = gpuUUID.String
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+// Rollup 在数据库内完成分桶聚合，再以 upsert 写回，重复执行同一区间是幂等的。
+func (s *mysqlStore) Rollup(source, target int, from, to time.Time) error {
+	nodeQuery := `
+		INSERT INTO node_metric_samples (node_id, resolution, ts, cpu_usage_percent, memory_usage_percent, memory_used_mb, memory_total_mb)
+		SELECT node_id, ?, DATE_ADD('1970-01-01 00:00:00', INTERVAL bucket SECOND),
+			AVG(cpu_usage_percent), AVG(memory_usage_percent), AVG(memory_used_mb), MAX(memory_total_mb)
+		FROM (
+			SELECT node_id, ` + bucketExpr + ` AS bucket, cpu_usage_percent, memory_usage_percent, memory_used_mb, memory_total_mb
+			FROM node_metric_samples
+			WHERE resolution = ? AND ts >= ? AND ts < ?
+		) AS src
+		GROUP BY node_id, bucket
+		ON DUPLICATE KEY UPDATE cpu_usage_percent = VALUES(cpu_usage_percent), memory_usage_percent = VALUES(memory_usage_percent),
+			memory_used_mb = VALUES(memory_used_mb), memory_total_mb = VALUES(memory_total_mb)
+	`
+	if _, err := s.db.Exec(nodeQuery, target, target, target, source, from, to); err != nil {
+		return fmt.Errorf("failed to roll up node metric samples: %w", err)
+	}
+
+	gpuQuery := `
+		INSERT INTO gpu_metric_samples (node_id, gpu_index, resolution, ts, gpu_uuid, usage_percent, memory_used_mb, memory_total_mb, temperature_c)
+		SELECT node_id, gpu_index, ?, DATE_ADD('1970-01-01 00:00:00', INTERVAL bucket SECOND),
+			MAX(gpu_uuid), AVG(usage_percent), AVG(memory_used_mb), MAX(memory_total_mb), AVG(temperature_c)
+		FROM (
+			SELECT node_id, gpu_index, ` + bucketExpr + ` AS bucket, gpu_uuid, usage_percent, memory_used_mb, memory_total_mb, temperature_c
+			FROM gpu_metric_samples
+			WHERE resolution = ? AND ts >= ? AND ts < ?
+		) AS src
+		GROUP BY node_id, gpu_index, bucket
+		ON DUPLICATE KEY UPDATE gpu_uuid = VALUES(gpu_uuid), usage_percent = VALUES(usage_percent), memory_used_mb = VALUES(memory_used_mb),
+			memory_total_mb = VALUES(memory_total_mb), temperature_c = VALUES(temperature_c)
+	`
+	if _, err := s.db.Exec(gpuQuery, target, target, target, source, from, to); err != nil {
+		return fmt.Errorf("failed to roll up gpu metric samples: %w", err)
+	}
+	return nil
+}
+
+func (s *mysqlStore) DeleteBefore(resolution int, before time.Time) error {
+	if _, err := s.db.Exec("DELETE FROM node_metric_samples WHERE resolution = ? AND ts < ?", resolution, before); err != nil {
+		return fmt.Errorf("failed to delete node metric samples: %w", err)
+	}
+	if _, err := s.db.Exec("DELETE FROM gpu_metric_samples WHERE resolution = ? AND ts < ?", resolution, before); err != nil {
+		return fmt.Errorf("failed to delete gpu metric samples: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package history
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"utopia-server/internal/models"
+)
+
+// Store 定义了节点指标历史的持久化接口。
+// 采样点按 (节点, [GPU,] 精度, 时间) 唯一，重复写入会覆盖已有的点。
+type Store interface {
+	InsertSamples(node *models.NodeMetricSample, gpus []models.GpuMetricSample) error
+	QueryNodeSamples(nodeID string, resolution int, from, to time.Time) ([]models.NodeMetricSample, error)
+	QueryGpuSamples(nodeID string, resolution int, from, to time.Time) ([]models.GpuMetricSample, error)
+	// Rollup 将精度为 source、时间在 [from, to) 内的采样按 target 秒分桶取平均，写入精度为 target 的序列。
+	Rollup(source, target int, from, to time.Time) error
+	// DeleteBefore 删除精度为 resolution 且早于 before 的采样。
+	DeleteBefore(resolution int, before time.Time) error
+}
+
+type nodeSampleKey struct {
+	nodeID     string
+	resolution int
+	ts         int64
+}
+
+type gpuSampleKey struct {
+	nodeID     string
+	gpuIndex   int
+	resolution int
+	ts         int64
+}
+
+// memStore 是 Store 接口的一个内存实现，主要用于测试。
+type memStore struct {
+	mu    sync.RWMutex
+	nodes map[nodeSampleKey]models.NodeMetricSample
+	gpus  map[gpuSampleKey]models.GpuMetricSample
+}
+
+// NewMemStore 创建一个新的 memStore 实例。
+func NewMemStore() Store {
+	return &memStore{
+		nodes: make(map[nodeSampleKey]models.NodeMetricSample),
+		gpus:  make(map[gpuSampleKey]models.GpuMetricSample),
+	}
+}
+
+func (s *memStore) InsertSamples(node *models.NodeMetricSample, gpus []models.GpuMetricSample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if node != nil {
+		s.nodes[nodeSampleKey{node.NodeID, node.Resolution, node.Timestamp.Unix()}] = *node
+	}
+	for _, gpu := range gpus {
+		s.gpus[gpuSampleKey{gpu.NodeID, gpu.GpuIndex, gpu.Resolution, gpu.Timestamp.Unix()}] = gpu
+	}
+	return nil
+}
+
+func (s *memStore) QueryNodeSamples(nodeID string, resolution int, from, to time.Time) ([]models.NodeMetricSample, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var samples []models.NodeMetricSample
+	for key, sample := range s.nodes {
+		if key.nodeID == nodeID && key.resolution == resolution && inRange(sample.Timestamp, from, to) {
+			samples = append(samples, sample)
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Timestamp.Before(samples[j].Timestamp) })
+	return samples, nil
+}
+
+func (s *memStore) QueryGpuSamples(nodeID string, resolution int, from, to time.Time) ([]models.GpuMetricSample, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var samples []models.GpuMetricSample
+	for key, sample := range s.gpus {
+		if key.nodeID == nodeID && key.resolution == resolution && inRange(sample.Timestamp, from, to) {
+			samples = append(samples, sample)
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].GpuIndex != samples[j].GpuIndex {
+			return samples[i].GpuIndex < samples[j].GpuIndex
+		}
+		return samples[i].Timestamp.Before(samples[j].Timestamp)
+	})
+	return samples, nil
+}
+
+func (s *memStore) Rollup(source, target int, from, to time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nodeBuckets := make(map[nodeSampleKey][]models.NodeMetricSample)
+	for key, sample := range s.nodes {
+		if key.resolution == source && inRange(sample.Timestamp, from, to) {
+			bucket := nodeSampleKey{key.nodeID, target, bucketStart(sample.Timestamp, target).Unix()}
+			nodeBuckets[bucket] = append(nodeBuckets[bucket], sample)
+		}
+	}
+	for key, samples := range nodeBuckets {
+		rolled := averageNodeSamples(samples)
+		rolled.Resolution = target
+		rolled.Timestamp = time.Unix(key.ts, 0)
+		s.nodes[key] = rolled
+	}
+
+	gpuBuckets := make(map[gpuSampleKey][]models.GpuMetricSample)
+	for key, sample := range s.gpus {
+		if key.resolution == source && inRange(sample.Timestamp, from, to) {
+			bucket := gpuSampleKey{key.nodeID, key.gpuIndex, target, bucketStart(sample.Timestamp, target).Unix()}
+			gpuBuckets[bucket] = append(gpuBuckets[bucket], sample)
+		}
+	}
+	for key, samples := range gpuBuckets {
+		rolled := averageGpuSamples(samples)
+		rolled.Resolution = target
+		rolled.Timestamp = time.Unix(key.ts, 0)
+		s.gpus[key] = rolled
+	}
+	return nil
+}
+
+func (s *memStore) DeleteBefore(resolution int, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, sample := range s.nodes {
+		if key.resolution == resolution && sample.Timestamp.Before(before) {
+			delete(s.nodes, key)
+		}
+	}
+	for key, sample := range s.gpus {
+		if key.resolution == resolution && sample.Timestamp.Before(before) {
+			delete(s.gpus, key)
+		}
+	}
+	return nil
+}
+
+// inRange 判断 t 是否落在 [from, to) 内。
+func inRange(t, from, to time.Time) bool {
+	return !t.Before(from) && t.Before(to)
+}
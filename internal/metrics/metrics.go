@@ -0,0 +1,310 @@
+// Package metrics 实现了一个最小的 Prometheus 指标注册表，并以文本暴露格式输出。
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 指标类型，对应文本格式中的 # TYPE 行。
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// DefBuckets 是直方图的默认桶边界（秒），与 Prometheus 客户端库一致。
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Sample 是指标族中的一个样本。Suffix 用于直方图的 _bucket、_sum、_count 等子序列。
+type Sample struct {
+	Suffix string
+	Labels []string // 交替的标签名和标签值
+	Value  float64
+}
+
+// Family 是同名指标的集合。
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// NewFamily 创建一个空的指标族。
+func NewFamily(name, help, typ string) *Family {
+	return &Family{Name: name, Help: help, Type: typ}
+}
+
+// Add 添加一个样本，labels 为交替的标签名和标签值。
+func (f *Family) Add(value float64, labels ...string) {
+	f.Samples = append(f.Samples, Sample{Labels: labels, Value: value})
+}
+
+// Collector 在每次抓取时返回其当前的指标。
+type Collector interface {
+	Collect() []*Family
+}
+
+// CollectorFunc 将普通函数适配为 Collector。
+type CollectorFunc func() []*Family
+
+func (f CollectorFunc) Collect() []*Family {
+	return f()
+}
+
+// Registry 汇总多个 Collector 的指标。
+type Registry struct {
+	mu         sync.RWMutex
+	collectors []Collector
+}
+
+// NewRegistry 创建一个空的 Registry。
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default 是服务进程使用的全局注册表。
+var Default = NewRegistry()
+
+// MustRegister 注册一个或多个 Collector。
+func (r *Registry) MustRegister(collectors ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// Gather 收集所有指标族，并按名称排序。
+func (r *Registry) Gather() []*Family {
+	r.mu.RLock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.RUnlock()
+
+	var families []*Family
+	for _, collector := range collectors {
+		families = append(families, collector.Collect()...)
+	}
+	sort.SliceStable(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+	return families
+}
+
+// WriteText 以 Prometheus 文本暴露格式（0.0.4）写出所有指标。
+func (r *Registry) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, family := range r.Gather() {
+		if family.Help != "" {
+			fmt.Fprintf(bw, "# HELP %s %s\n", family.Name, escapeHelp(family.Help))
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", family.Name, family.Type)
+		for _, sample := range family.Samples {
+			bw.WriteString(family.Name)
+			bw.WriteString(sample.Suffix)
+			writeLabels(bw, sample.Labels)
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(sample.Value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+// Handler 返回一个输出所有指标的 http.Handler。
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := r.WriteText(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+func writeLabels(w *bufio.Writer, labels []string) {
+	if len(labels) == 0 {
+		return
+	}
+	w.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(labels[i])
+		w.WriteString(`="`)
+		w.WriteString(escapeLabelValue(labels[i+1]))
+		w.WriteByte('"')
+	}
+	w.WriteByte('}')
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// labelKey 将标签值编码为 map 的键。
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// zipLabels 将标签名和标签值合并为交替的切片。
+func zipLabels(names, values []string) []string {
+	labels := make([]string, 0, 2*len(names))
+	for i, name := range names {
+		labels = append(labels, name, values[i])
+	}
+	return labels
+}
+
+// CounterVec 是带标签的计数器。
+type CounterVec struct {
+	name, help string
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec 创建一个带有给定标签名的计数器。
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{name: name, help: help, labelNames: labelNames, values: make(map[string]*counterValue)}
+}
+
+// Inc 将 labelValues 对应的计数器加一。
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add 将 labelValues 对应的计数器增加 v，v 不能为负数。
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 || len(labelValues) != len(c.labelNames) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := labelKey(labelValues)
+	counter, ok := c.values[key]
+	if !ok {
+		counter = &counterValue{labels: zipLabels(c.labelNames, labelValues)}
+		c.values[key] = counter
+	}
+	counter.value += v
+}
+
+func (c *CounterVec) Collect() []*Family {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	family := NewFamily(c.name, c.help, TypeCounter)
+	for _, key := range sortedKeys(c.values) {
+		counter := c.values[key]
+		family.Add(counter.value, counter.labels...)
+	}
+	return []*Family{family}
+}
+
+// HistogramVec 是带标签的直方图。
+type HistogramVec struct {
+	name, help string
+	buckets    []float64
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64 // 每个桶内（不累计）的观测数
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec 创建一个带有给定桶边界和标签名的直方图。
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{name: name, help: help, buckets: buckets, labelNames: labelNames, values: make(map[string]*histogramValue)}
+}
+
+// Observe 记录一次观测值。
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labelNames) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := labelKey(labelValues)
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogramValue{labels: zipLabels(h.labelNames, labelValues), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hist.counts[i]++
+	}
+	hist.sum += v
+	hist.count++
+}
+
+func (h *HistogramVec) Collect() []*Family {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	family := NewFamily(h.name, h.help, TypeHistogram)
+	for _, key := range sortedKeys(h.values) {
+		hist := h.values[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hist.counts[i]
+			family.Samples = append(family.Samples, Sample{Suffix: "_bucket", Labels: append(append([]string(nil), hist.labels...), "le", formatValue(bound)), Value: float64(cumulative)})
+		}
+		family.Samples = append(family.Samples,
+			Sample{Suffix: "_bucket", Labels: append(append([]string(nil), hist.labels...), "le", "+Inf"), Value: float64(hist.count)},
+			Sample{Suffix: "_sum", Labels: hist.labels, Value: hist.sum},
+			Sample{Suffix: "_count", Labels: hist.labels, Value: float64(hist.count)},
+		)
+	}
+	return []*Family{family}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
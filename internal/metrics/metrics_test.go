@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteText(t *testing.T) {
+	registry := NewRegistry()
+
+	counter := NewCounterVec("test_errors_total", "Test errors.", "operation")
+	counter.Inc("create")
+	counter.Add(2, "create")
+	counter.Inc("wrong", "label count") // ignored
+
+	histogram := NewHistogramVec("test_duration_seconds", "Test durations.", []float64{0.1, 1}, "loop")
+	histogram.Observe(0.05, "a")
+	histogram.Observe(0.5, "a")
+	histogram.Observe(5, "a")
+
+	registry.MustRegister(counter, histogram, CollectorFunc(func() []*Family {
+		family := NewFamily("test_info", "Line one\nline two.", TypeGauge)
+		family.Add(1, "name", `quote " and \ backslash`)
+		return []*Family{family}
+	}))
+
+	var out strings.Builder
+	require.NoError(t, registry.WriteText(&out))
+
+	expected := `# HELP test_duration_seconds Test durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{loop="a",le="0.1"} 1
+test_duration_seconds_bucket{loop="a",le="1"} 2
+test_duration_seconds_bucket{loop="a",le="+Inf"} 3
+test_duration_seconds_sum{loop="a"} 5.55
+test_duration_seconds_count{loop="a"} 3
+# HELP test_errors_total Test errors.
+# TYPE test_errors_total counter
+test_errors_total{operation="create"} 3
+# HELP test_info Line one\nline two.
+# TYPE test_info gauge
+test_info{name="quote \" and \\ backslash"} 1
+`
+	assert.Equal(t, expected, out.String())
+}
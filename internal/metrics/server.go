@@ -0,0 +1,52 @@
+package metrics
+
+// 服务内部的指标，在 Default 注册表中注册。
+var (
+	// SchedulingDuration 记录每次调度决策的耗时，result 为 scheduled、unschedulable 或 error。
+	SchedulingDuration = NewHistogramVec(
+		"utopia_scheduling_duration_seconds",
+		"Time taken to make a scheduling decision for a GPU claim.",
+		DefBuckets, "result",
+	)
+
+	// ReconcileDuration 记录每轮后台循环的耗时，loop 为 controller、discovery、container_gc 或 image_prepull。
+	ReconcileDuration = NewHistogramVec(
+		"utopia_reconcile_duration_seconds",
+		"Duration of a single pass of a background reconcile loop.",
+		DefBuckets, "loop",
+	)
+
+	// AgentRequestErrors 统计对节点 agent 请求的失败次数。
+	// reason 为 transport（连接失败或超时）、status（非预期的状态码）或 decode（响应无法解析）。
+	AgentRequestErrors = NewCounterVec(
+		"utopia_agent_request_errors_total",
+		"Number of failed requests to node agents.",
+		"operation", "reason",
+	)
+
+	// OrphanContainers 统计容器垃圾回收处理的孤儿容器数量，action 为 adopted 或 removed。
+	OrphanContainers = NewCounterVec(
+		"utopia_orphan_containers_total",
+		"Number of orphaned containers adopted or removed by the garbage collector.",
+		"action",
+	)
+
+	// NodeEvents 统计发现服务记录的节点状态变化，type 为 NodeOnline、NodeOffline 或 NodeControlPortChanged，
+	// reason 为下线或端口变化的原因（上线时为空）。
+	NodeEvents = NewCounterVec(
+		"utopia_node_events_total",
+		"Number of node state transitions observed by the discovery service.",
+		"type", "reason",
+	)
+
+	// ImageCacheHits 统计调度时所选节点是否已缓存 GpuClaim 的镜像，result 为 hit 或 miss。
+	ImageCacheHits = NewCounterVec(
+		"utopia_scheduler_image_cache_total",
+		"Number of scheduled GPU claims whose node already had the image cached.",
+		"result",
+	)
+)
+
+func init() {
+	Default.MustRegister(SchedulingDuration, ReconcileDuration, AgentRequestErrors, OrphanContainers, NodeEvents, ImageCacheHits)
+}
@@ -0,0 +1,29 @@
+package models
+
+// node-agent 的 API 版本。
+const (
+	// AgentAPIV0 是引入版本协商之前的 agent 所使用的隐含版本，只提供 POST /containers 和 /api/v1/metrics，
+	// 不能列出、查询或停止容器。
+	AgentAPIV0 = "v0"
+	// AgentAPIV1 的所有接口都位于 /api/v1 之下。
+	AgentAPIV1 = "v1"
+)
+
+// SupportedAgentAPIs 是服务器支持的 agent API 版本，按优先级从高到低排列。
+var SupportedAgentAPIs = []string{AgentAPIV1, AgentAPIV0}
+
+// AgentAPI 返回服务器与节点 agent 协商出的 API 版本，即双方都支持的最新版本。
+// 未上报 api_versions 的旧 agent 视为只支持 v0；没有共同版本时 ok 为 false，此类节点不会被调度。
+func (n *Node) AgentAPI() (version string, ok bool) {
+	if n.Inventory == nil || len(n.Inventory.APIVersions) == 0 {
+		return AgentAPIV0, true
+	}
+	for _, supported := range SupportedAgentAPIs {
+		for _, offered := range n.Inventory.APIVersions {
+			if offered == supported {
+				return supported, true
+			}
+		}
+	}
+	return "", false
+}
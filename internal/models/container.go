@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// 容器状态，与 node-agent 上报的 Docker 容器状态一致。
+const (
+	ContainerStateCreated    = "created"
+	ContainerStateRunning    = "running"
+	ContainerStatePaused     = "paused"
+	ContainerStateRestarting = "restarting"
+	ContainerStateExited     = "exited"
+	ContainerStateDead       = "dead"
+)
+
+// ContainerLabelClaimID 是服务器在创建容器时写入的标签，值为所属 GpuClaim 的 ID。
+// 容器垃圾回收据此把节点上的容器与 GpuClaim 对应起来。
+const ContainerLabelClaimID = "utopia.claim-id"
+
+// ContainerInfo 描述 node-agent 管理的一个容器。
+type ContainerInfo struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Image      string            `json:"image"`
+	State      string            `json:"state"`            // created, running, paused, restarting, exited, dead
+	Status     string            `json:"status,omitempty"` // 便于阅读的状态描述，例如 "Up 2 hours"
+	ExitCode   int               `json:"exit_code"`
+	Gpus       []int             `json:"gpus,omitempty"` // 分配给容器的 GPU 序号
+	Labels     map[string]string `json:"labels,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	StartedAt  *time.Time        `json:"started_at,omitempty"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+}
+
+// ClaimID 返回容器所属 GpuClaim 的 ID，没有该标签时返回空字符串。
+func (c *ContainerInfo) ClaimID() string {
+	return c.Labels[ContainerLabelClaimID]
+}
+
+// Finished 报告容器是否已经停止运行。
+func (c *ContainerInfo) Finished() bool {
+	return c.State == ContainerStateExited || c.State == ContainerStateDead
+}
+
+// ContainerGpuStats 是容器所用单个 GPU 的资源使用情况。
+type ContainerGpuStats struct {
+	Index        int `json:"index"`
+	UsagePercent int `json:"usage_percent"`
+	MemoryUsedMB int `json:"memory_used_mb"`
+}
+
+// ContainerStats 是容器当前的资源使用情况。
+type ContainerStats struct {
+	ContainerID     string              `json:"container_id"`
+	CPUUsagePercent float64             `json:"cpu_usage_percent"`
+	MemoryUsedMB    int                 `json:"memory_used_mb"`
+	MemoryLimitMB   int                 `json:"memory_limit_mb"`
+	NetworkRxBytes  int64               `json:"network_rx_bytes"`
+	NetworkTxBytes  int64               `json:"network_tx_bytes"`
+	Gpus            []ContainerGpuStats `json:"gpus,omitempty"`
+}
@@ -16,23 +16,35 @@ const (
 	GpuClaimPhaseFailed GpuClaimPhase = "Failed"
 	// GpuClaimPhaseCompleted means the claim has been completed.
 	GpuClaimPhaseCompleted GpuClaimPhase = "Completed"
+	// GpuClaimPhaseTerminating means the claim has been deleted and its container is being stopped and removed.
+	GpuClaimPhaseTerminating GpuClaimPhase = "Terminating"
 )
 
+// GpuClaimSubPhaseCreating means the controller has asked the agent to create the
+// container of a Scheduled claim but has not yet recorded the result. The agent
+// may already have created it, so the controller looks for the container
+// before creating it again.
+const GpuClaimSubPhaseCreating = "Creating"
+
 // GpuClaimSpec 定义了用户对 GPU 资源的期望状态。
 type GpuClaimSpec struct {
 	Image     string `json:"image"`
 	Resources struct {
 		GpuCount int `json:"gpuCount"`
 	} `json:"resources"`
+	// MinCudaVersion 要求节点驱动支持的 CUDA 版本不低于该值，例如 "12.1"；为空表示不限制。
+	MinCudaVersion string `json:"minCudaVersion,omitempty"`
 }
 
 // GpuClaimStatus 定义了 GPU 资源的实际状态。
 type GpuClaimStatus struct {
-	Phase       GpuClaimPhase `json:"phase"`            // Pending, Running, Failed, Completed
-	NodeName    string        `json:"nodeName"`         // 被调度到的节点名称
-	ContainerID string        `json:"containerId"`      // 在节点上运行的容器 ID
-	AccessURL   string        `json:"accessUrl"`        // 容器的公网访问地址
-	Reason      string        `json:"reason,omitempty"` // 当 claim 失败时的原因
+	Phase       GpuClaimPhase `json:"phase"`              // Pending, Scheduled, Running, Failed, Completed, Terminating
+	SubPhase    string        `json:"subPhase,omitempty"` // Scheduled 阶段内的子状态，目前只有 Creating
+	NodeName    string        `json:"nodeName"`           // 被调度到的节点名称
+	ContainerID string        `json:"containerId"`        // 在节点上运行的容器 ID
+	AccessURL   string        `json:"accessUrl"`          // 容器的公网访问地址
+	Reason      string        `json:"reason,omitempty"`   // 当 claim 失败时的原因
+	ExitCode    *int          `json:"exitCode,omitempty"` // 容器退出后的退出码
 }
 
 // GpuClaim 是一个声明式的 API 对象，用于描述对 GPU 资源的需求。
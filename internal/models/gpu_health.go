@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// GPU 健康状态。
+const (
+	GpuHealthHealthy = "Healthy"
+	// GpuHealthSuspect 表示 GPU 最近出现了可恢复的异常（可纠正 ECC 错误、非致命 XID、硬件降频等），仍可调度。
+	GpuHealthSuspect = "Suspect"
+	// GpuHealthQuarantined 表示 GPU 已被隔离，不再参与调度，只能由管理员解除。
+	GpuHealthQuarantined = "Quarantined"
+)
+
+// GpuHealth 是服务器为单个 GPU 维护的健康状态。
+type GpuHealth struct {
+	NodeID   string `json:"nodeId"`
+	GpuIndex int    `json:"gpuIndex"`
+	GpuUUID  string `json:"gpuUuid,omitempty"`
+	State    string `json:"state"`
+	Reason   string `json:"reason,omitempty"` // 最近一次进入 Suspect 或 Quarantined 的原因
+	// SuspectCount 是自上次 Healthy 以来异常上报的次数，CleanCount 是连续正常的上报次数。
+	SuspectCount int `json:"suspectCount"`
+	CleanCount   int `json:"cleanCount"`
+	// EccCorrected 和 EccUncorrected 是上一次上报的累计 ECC 错误数，用于判断是否有新增错误。
+	EccCorrected   int64      `json:"eccCorrected"`
+	EccUncorrected int64      `json:"eccUncorrected"`
+	QuarantinedAt  *time.Time `json:"quarantinedAt,omitempty"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
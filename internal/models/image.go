@@ -0,0 +1,145 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// CachedImage 是 agent 上报的、节点本地已经存在的镜像。
+type CachedImage struct {
+	Reference string `json:"reference"`        // 例如 "nvcr.io/nvidia/pytorch:24.01-py3"
+	Digest    string `json:"digest,omitempty"` // 例如 "sha256:..."
+	SizeMB    int64  `json:"size_mb,omitempty"`
+}
+
+// NormalizeImage 把镜像引用补全为完整形式，便于比较：
+// "pytorch" 与 "docker.io/library/pytorch:latest" 视为同一个镜像。
+func NormalizeImage(ref string) string {
+	name, digest, hasDigest := strings.Cut(strings.TrimSpace(ref), "@")
+
+	domain, remainder := "docker.io", name
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		if first := name[:i]; strings.ContainsAny(first, ".:") || first == "localhost" {
+			domain, remainder = first, name[i+1:]
+		}
+	}
+	if domain == "docker.io" && !strings.Contains(remainder, "/") {
+		remainder = "library/" + remainder
+	}
+	if !hasDigest && !strings.Contains(remainder[strings.LastIndexByte(remainder, '/')+1:], ":") {
+		remainder += ":latest"
+	}
+
+	normalized := domain + "/" + remainder
+	if hasDigest {
+		normalized += "@" + digest
+	}
+	return normalized
+}
+
+// ImageRepository 返回镜像引用的完整仓库名，不含标签和 digest，
+// 例如 "pytorch:2.1" 返回 "docker.io/library/pytorch"。
+func ImageRepository(ref string) string {
+	name, _, _ := strings.Cut(NormalizeImage(ref), "@")
+	if i := strings.LastIndexByte(name, ':'); i > strings.LastIndexByte(name, '/') {
+		name = name[:i]
+	}
+	return name
+}
+
+// ImageRegistry 返回镜像所在的仓库地址，例如 "nvcr.io"；Docker Hub 的镜像返回 "docker.io"。
+func ImageRegistry(ref string) string {
+	registry, _, _ := strings.Cut(ImageRepository(ref), "/")
+	return registry
+}
+
+// ImageMatches 报告镜像是否属于 pattern 指定的仓库或路径前缀。"*" 匹配所有镜像；
+// "nvcr.io" 匹配该仓库的所有镜像；"nvcr.io/nvidia" 匹配该路径下的镜像，但不匹配 "nvcr.io/nvidia-x/..."。
+// pattern 必须包含仓库地址，Docker Hub 的镜像写作 "docker.io/library/pytorch"。
+func ImageMatches(pattern, ref string) bool {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return false
+	}
+	pattern = strings.TrimSuffix(strings.TrimSuffix(pattern, "*"), "/")
+	if pattern == "" {
+		return true
+	}
+	repo := ImageRepository(ref)
+	return repo == pattern || strings.HasPrefix(repo, pattern+"/")
+}
+
+// RegistryCredential 是访问私有镜像仓库的凭据。密码用主密钥加密保存，不会通过 API 返回。
+type RegistryCredential struct {
+	ID        string    `json:"id"`
+	Registry  string    `json:"registry"` // 仓库地址或路径前缀，例如 "nvcr.io" 或 "ghcr.io/acme"，匹配规则同 ImageMatches
+	Username  string    `json:"username"`
+	Password  string    `json:"-"` // 加密后的密码
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// PullSecret 是随创建容器和拉取镜像的请求发给 agent 的仓库凭据。
+type PullSecret struct {
+	Registry string `json:"registry"` // 镜像所在的仓库地址，即 agent 登录的服务器
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// 预拉取任务的状态。
+const (
+	PrepullJobRunning   = "Running"
+	PrepullJobCompleted = "Completed" // 所有节点都已拉取成功
+	PrepullJobFailed    = "Failed"    // 所有节点都已结束，且至少一个节点失败
+)
+
+// 预拉取任务中单个节点的阶段。
+const (
+	PrepullPhasePending   = "Pending"
+	PrepullPhasePulling   = "Pulling"
+	PrepullPhaseSucceeded = "Succeeded"
+	PrepullPhaseFailed    = "Failed"
+)
+
+// agent 上报的镜像拉取状态。
+const (
+	ImagePullStatePulling = "pulling"
+	ImagePullStateDone    = "done"
+	ImagePullStateFailed  = "failed"
+)
+
+// ImagePullStatus 是 agent 返回的一次镜像拉取的进度。
+type ImagePullStatus struct {
+	Image    string `json:"image"`
+	State    string `json:"state"`    // pulling, done, failed
+	Progress int    `json:"progress"` // 0-100
+	Error    string `json:"error,omitempty"`
+}
+
+// PrepullJob 是管理员发起的镜像预拉取任务，要求一组节点提前拉取同一个镜像。
+type PrepullJob struct {
+	ID         string        `json:"id"`
+	Image      string        `json:"image"`
+	Status     string        `json:"status"`   // Running, Completed, Failed
+	Progress   int           `json:"progress"` // 所有节点进度的平均值，0-100
+	CreatedBy  string        `json:"createdBy,omitempty"`
+	Nodes      []PrepullNode `json:"nodes"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	FinishedAt *time.Time    `json:"finishedAt,omitempty"`
+}
+
+// PrepullNode 记录预拉取任务在单个节点上的进度。
+type PrepullNode struct {
+	NodeID     string     `json:"nodeId"`
+	Hostname   string     `json:"hostname"`
+	Phase      string     `json:"phase"` // Pending, Pulling, Succeeded, Failed
+	Progress   int        `json:"progress"`
+	Message    string     `json:"message,omitempty"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Finished 报告该节点的预拉取是否已经结束。
+func (n *PrepullNode) Finished() bool {
+	return n.Phase == PrepullPhaseSucceeded || n.Phase == PrepullPhaseFailed
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// NodeMetricSample 是节点级指标的一个时间序列采样点。
+// Resolution 为 0 表示原始采样，否则表示降采样后每个点覆盖的秒数。
+type NodeMetricSample struct {
+	NodeID             string    `json:"-"`
+	Resolution         int       `json:"-"`
+	Timestamp          time.Time `json:"ts"`
+	CPUUsagePercent    float64   `json:"cpuUsagePercent"`
+	MemoryUsagePercent float64   `json:"memoryUsagePercent"`
+	MemoryUsedMB       float64   `json:"memoryUsedMb"`
+	MemoryTotalMB      int       `json:"memoryTotalMb"`
+}
+
+// GpuMetricSample 是单个 GPU 指标的一个时间序列采样点。
+type GpuMetricSample struct {
+	NodeID        string    `json:"-"`
+	GpuIndex      int       `json:"-"`
+	GpuUUID       string    `json:"-"`
+	Resolution    int       `json:"-"`
+	Timestamp     time.Time `json:"ts"`
+	UsagePercent  float64   `json:"usagePercent"`
+	MemoryUsedMB  float64   `json:"memoryUsedMb"`
+	MemoryTotalMB int       `json:"memoryTotalMb"`
+	TemperatureC  float64   `json:"temperatureC"`
+}
+
+// GpuMetricSeries 是单个 GPU 在一段时间内的指标序列。
+type GpuMetricSeries struct {
+	Index   int               `json:"index"`
+	UUID    string            `json:"uuid"`
+	Samples []GpuMetricSample `json:"samples"`
+}
+
+// NodeMetricsHistory 是节点指标历史查询的结果。
+type NodeMetricsHistory struct {
+	NodeID     string             `json:"nodeId"`
+	From       time.Time          `json:"from"`
+	To         time.Time          `json:"to"`
+	Step       int                `json:"step"`       // 返回序列的步长（秒）
+	Resolution int                `json:"resolution"` // 数据来源的采样精度（秒），0 表示原始采样
+	Node       []NodeMetricSample `json:"node"`
+	Gpus       []GpuMetricSeries  `json:"gpus"`
+}
@@ -1,11 +1,17 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 const (
 	NodeStatusOnline      = "Online"
 	NodeStatusOffline     = "Offline"
 	NodeStatusRegistering = "Registering"
+	// NodeStatusUnknown 表示节点最近的健康检查失败，但尚未达到判定 Offline 的阈值。
+	NodeStatusUnknown = "Unknown"
 )
 
 // GpuInfo 描述了节点上单个 GPU 的信息。
@@ -19,6 +25,16 @@ type GpuInfo struct {
 	Busy          bool   `json:"busy"`
 	UsagePercent  int    `json:"usage_percent"`
 	ContainerID   string `json:"containerId,omitempty"` // This field is not in the new JSON, but we might need it.
+
+	// 以下健康字段由 agent 上报，均为可选。
+	EccErrorsCorrected   int64    `json:"ecc_errors_corrected,omitempty"`   // 累计可纠正 ECC 错误数
+	EccErrorsUncorrected int64    `json:"ecc_errors_uncorrected,omitempty"` // 累计不可纠正 ECC 错误数
+	XidErrors            []int    `json:"xid_errors,omitempty"`             // 自上次上报以来发生的 XID 事件
+	ThrottleReasons      []string `json:"throttle_reasons,omitempty"`       // 当前生效的降频原因，例如 "hw_slowdown"
+	PowerDrawW           float64  `json:"power_draw_w,omitempty"`
+	PowerLimitW          float64  `json:"power_limit_w,omitempty"`
+	// Health 是服务器评估的健康状态（Healthy、Suspect、Quarantined），agent 上报的值会被忽略。
+	Health string `json:"health,omitempty"`
 }
 
 // SystemMetrics 代表节点的系统级指标。
@@ -32,14 +48,110 @@ type SystemMetrics struct {
 	Uptime             int     `json:"uptime"`
 }
 
+// NodeInventory 描述节点的软硬件清单，由 agent 在注册和上报指标时提供。
+type NodeInventory struct {
+	AgentVersion  string   `json:"agent_version,omitempty"`
+	APIVersions   []string `json:"api_versions,omitempty"`   // agent 支持的 API 版本，例如 ["v1", "v0"]
+	DriverVersion string   `json:"driver_version,omitempty"` // NVIDIA 驱动版本，例如 "535.104.05"
+	CudaVersion   string   `json:"cuda_version,omitempty"`   // 驱动支持的最高 CUDA 版本，例如 "12.2"
+	CPUModel      string   `json:"cpu_model,omitempty"`
+	CPUCores      int      `json:"cpu_cores,omitempty"`
+	MemoryTotalMB int      `json:"memory_total_mb,omitempty"`
+	OS            string   `json:"os,omitempty"`
+	KernelVersion string   `json:"kernel_version,omitempty"`
+}
+
 // Node 代表一个计算节点，可以承载 GPU 工作负载。
 type Node struct {
-	ID          int64     `json:"id" gorm:"primaryKey"`
-	Hostname    string    `json:"hostname"`
-	Status      string    `json:"status"` // Online, Offline, Registering
-	Gpus        []GpuInfo `json:"gpus" gorm:"type:json"`
-	ControlPort int       `json:"controlPort"`
-	LastSeen    time.Time `json:"lastSeen"`
+	ID          string            `json:"id" gorm:"primaryKey"` // UUID
+	LegacyID    int64             `json:"legacyId,omitempty"`   // 切换到 UUID 之前的自增 ID，用于兼容旧 agent
+	Hostname    string            `json:"hostname"`
+	MachineID   string            `json:"machineId,omitempty"` // 稳定的机器标识，用于幂等重新注册
+	Status      string            `json:"status"`              // Online, Unknown, Offline, Registering
+	Labels      map[string]string `json:"labels" gorm:"type:json"`
+	Gpus        []GpuInfo         `json:"gpus" gorm:"type:json"`
+	ControlPort int               `json:"controlPort"`
+	// Address 是直连节点 agent 的 host:port，为空时通过 frps 隧道访问。
+	Address  string    `json:"address,omitempty"`
+	LastSeen time.Time `json:"lastSeen"`
+	// LastHeartbeat 是 agent 最近一次主动上报心跳的时间，从未上报过则为 nil。
+	LastHeartbeat *time.Time `json:"lastHeartbeat,omitempty"`
+	// ExpectedGpus 是静态清单中声明的 GPU 数量，0 表示未声明。
+	ExpectedGpus int `json:"expectedGpus,omitempty"`
+	// DeclaredAt 是节点首次出现在静态清单中的时间，不是由清单声明的节点为 nil。
+	DeclaredAt *time.Time `json:"declaredAt,omitempty"`
+	// Inventory 是 agent 最近一次上报的软硬件清单，从未上报过则为 nil。
+	Inventory *NodeInventory `json:"inventory,omitempty" gorm:"type:json"`
+	// System 是最近一次成功健康检查或心跳得到的系统级指标。
+	System *SystemMetrics `json:"system,omitempty" gorm:"type:json"`
+	// CredentialHash 是节点凭据的 SHA-256 摘要，凭据明文仅在注册时返回一次。
+	CredentialHash string `json:"-"`
+	// AgentSecret 是服务器调用 agent 时用于签名的密钥，以主密钥加密后保存；为空时使用共享的 frp.agent_token。
+	AgentSecret string `json:"-"`
+	// CredentialsIssuedAt 是最近一次签发节点凭据与 agent 密钥的时间。
+	CredentialsIssuedAt *time.Time `json:"credentialsIssuedAt,omitempty"`
+	// CertificateSerial 是内部 CA 签发给节点 agent 的证书序列号，为空时服务器通过 HTTP 访问 agent。
+	CertificateSerial string `json:"certificateSerial,omitempty"`
+	// CertificateExpiresAt 是节点证书的到期时间。
+	CertificateExpiresAt *time.Time `json:"certificateExpiresAt,omitempty"`
+	// Images 是 agent 最近一次上报的本地镜像缓存，调度时优先选择已有所需镜像的节点。
+	Images []CachedImage `json:"images,omitempty" gorm:"type:json"`
+	// ClaimedMachineID 是注册时上报了已有节点的机器标识、但没有出示该节点凭据的节点所声称的标识。
+	// 这样的节点不会接管已有节点，而是作为新节点等待管理员确认并合并。
+	ClaimedMachineID string `json:"claimedMachineId,omitempty"`
+	// RequestedAddress 是 agent 注册时请求的直连地址。它不会直接生效，需由管理员设置为 Address。
+	RequestedAddress string `json:"requestedAddress,omitempty"`
+}
+
+// IsDirect 报告节点是否配置了直连地址，而不依赖 frps 隧道。
+func (n *Node) IsDirect() bool {
+	return n.Address != ""
+}
+
+// HasImage 报告节点的本地镜像缓存中是否有 ref。带摘要的引用按摘要比较。
+func (n *Node) HasImage(ref string) bool {
+	want := NormalizeImage(ref)
+	_, digest, byDigest := strings.Cut(want, "@")
+	for _, image := range n.Images {
+		if NormalizeImage(image.Reference) == want || (byDigest && image.Digest == digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasCertificate 报告节点是否持有内部 CA 签发的证书，此时必须通过双向 TLS 访问其 agent。
+func (n *Node) HasCertificate() bool {
+	return n.CertificateSerial != ""
+}
+
+// Endpoint 返回访问节点 agent 的基础 URL，例如 "http://10.0.0.5:8080"；持有证书的节点使用 https。
+// 直连节点使用其地址，其余节点经由 frps 隧道在本机的端口；节点不可达时返回空字符串。
+func (n *Node) Endpoint() string {
+	scheme := "http"
+	if n.HasCertificate() {
+		scheme = "https"
+	}
+	if n.IsDirect() {
+		return scheme + "://" + n.Address
+	}
+	if n.ControlPort == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s://localhost:%d", scheme, n.ControlPort)
+}
+
+// BootstrapToken 是管理员签发的节点注册令牌，数据库中只保存其哈希值。
+type BootstrapToken struct {
+	ID          int64      `json:"id"`
+	TokenHash   string     `json:"-"`
+	Description string     `json:"description"`
+	SingleUse   bool       `json:"singleUse"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	UseCount    int        `json:"useCount"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+	CreatedBy   string     `json:"createdBy"`
+	CreatedAt   time.Time  `json:"createdAt"`
 }
 
 // NodeMetrics 代表从节点 agent 返回的完整指标。
@@ -49,4 +161,8 @@ type NodeMetrics struct {
 	MemoryUsagePercent float64       `json:"memory_usage_percent"`
 	Gpus               []GpuInfo     `json:"gpus"`
 	System             SystemMetrics `json:"system"`
+	// Inventory 是可选的软硬件清单，agent 未上报时为 nil，节点保留之前记录的清单。
+	Inventory *NodeInventory `json:"inventory,omitempty"`
+	// Images 是可选的本地镜像缓存，agent 未上报时为 nil，节点保留之前记录的镜像列表。
+	Images []CachedImage `json:"images,omitempty"`
 }
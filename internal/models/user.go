@@ -7,6 +7,11 @@ import (
 	"time"
 )
 
+const (
+	RoleAdmin     = "admin"
+	RoleDeveloper = "developer"
+)
+
 // Policies 定义了角色的权限集合。
 type Policies map[string]interface{}
 
@@ -24,6 +29,40 @@ func (p *Policies) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, &p)
 }
 
+// PolicyAllowedRegistries 是限制镜像来源的策略键，值为 ImageMatches 所用的模式列表。
+const PolicyAllowedRegistries = "allowed_registries"
+
+// AllowedRegistries 返回允许使用的镜像仓库模式。ok 为 false 表示没有配置该策略，即不限制镜像来源。
+func (p Policies) AllowedRegistries() (patterns []string, ok bool) {
+	switch value := p[PolicyAllowedRegistries].(type) {
+	case []string:
+		return value, true
+	case []interface{}:
+		for _, pattern := range value {
+			if s, isString := pattern.(string); isString {
+				patterns = append(patterns, s)
+			}
+		}
+		return patterns, true
+	default:
+		return nil, false
+	}
+}
+
+// AllowsImage 报告策略是否允许使用该镜像：没有配置 allowed_registries，或镜像匹配其中某个模式。
+func (p Policies) AllowsImage(image string) bool {
+	patterns, ok := p.AllowedRegistries()
+	if !ok {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ImageMatches(pattern, image) {
+			return true
+		}
+	}
+	return false
+}
+
 // Role 定义了用户角色及其权限。
 type Role struct {
 	ID       int      `json:"id" gorm:"primaryKey"`
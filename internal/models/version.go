@@ -0,0 +1,55 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+)
+
+// parseVersion 将点分隔的数字版本号（如 "12.2" 或 "535.104.05"）解析为各段数值。
+func parseVersion(version string) ([]int, bool) {
+	if version == "" {
+		return nil, false
+	}
+	parts := strings.Split(version, ".")
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		numbers[i] = n
+	}
+	return numbers, true
+}
+
+// IsValidVersion reports whether version is a dotted numeric version such as "12.2".
+func IsValidVersion(version string) bool {
+	_, ok := parseVersion(version)
+	return ok
+}
+
+// VersionAtLeast 报告 version 是否不低于 minimum，缺少的段按 0 处理（"12" 等同于 "12.0"）。
+// 任一版本号无法解析时返回 false。
+func VersionAtLeast(version, minimum string) bool {
+	v, ok := parseVersion(version)
+	if !ok {
+		return false
+	}
+	m, ok := parseVersion(minimum)
+	if !ok {
+		return false
+	}
+	for i := 0; i < max(len(v), len(m)); i++ {
+		var a, b int
+		if i < len(v) {
+			a = v[i]
+		}
+		if i < len(m) {
+			b = m[i]
+		}
+		if a != b {
+			return a > b
+		}
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package node
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidBootstrapToken is returned when a bootstrap token is unknown, expired or already used.
+	ErrInvalidBootstrapToken = errors.New("invalid or expired bootstrap token")
+	// ErrInvalidNodeCredential is returned when a node presents a credential that does not match its record.
+	ErrInvalidNodeCredential = errors.New("invalid node credential")
+	// ErrProxyNameMismatch is returned when a node opens a control proxy named after another node.
+	ErrProxyNameMismatch = errors.New("control proxy name does not match the node ID")
+	// ErrCertificatesDisabled is returned when a node submits a CSR but the server has no CA configured.
+	ErrCertificatesDisabled = errors.New("certificate signing is not enabled")
+)
+
+// generateSecret 生成一个 256 位的随机密钥，以十六进制字符串返回。
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashSecret 返回密钥的 SHA-256 摘要。
+// 密钥本身是高熵随机值，因此无需使用 bcrypt 这类慢哈希，且摘要可直接用于索引查找。
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// secretMatchesHash 以常数时间比较密钥与已存储的摘要。
+func secretMatchesHash(secret, hash string) bool {
+	if hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(hash)) == 1
+}
@@ -2,21 +2,56 @@ package node
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"utopia-server/internal/config"
+	"utopia-server/internal/metrics"
 	"utopia-server/internal/models"
 )
 
+// 节点被判定离线的原因。
+const (
+	offlineReasonProxyMissing = "ProxyMissing" // frps 中已没有该节点的控制隧道
+	offlineReasonProxyOffline = "ProxyOffline" // 控制隧道存在但状态不是 online
+	offlineReasonProxyClosed  = "ProxyClosed"  // frps 通知控制隧道已关闭
+)
+
 // DiscoveryService 定期从 frps 发现节点隧道。
+//
+// 每一轮都会用 frps 的代理列表对所有节点做一次完整的调和：
+// 隧道在线的节点转为 Online 并更新控制端口，隧道消失或下线的节点转为 Offline。
+//
+// frps 通过服务器插件通知隧道的打开和关闭时，ProxyOpened 和 ProxyClosed 会立即更新节点；
+// 定期轮询作为兜底，用于修正错过的通知。
+//
+// 启用 enforceIdentity 时，frpc 必须在 metas 中携带 node_id 和 node_token（注册时签发的节点凭据），
+// 并且只能打开以自己 ID 命名的控制隧道。
 type DiscoveryService struct {
-	frpsApiUrl string
-	frpsUser   string
-	frpsPass   string
-	store      Store
+	frpsApiUrl      string
+	frpsUser        string
+	frpsPass        string
+	store           Store
+	events          EventSink
+	enforceIdentity bool
+
+	mu      sync.Mutex        // 串行化轮询调和与插件事件
+	runs    map[string]string // frpc run_id -> 节点 ID
+	trigger chan struct{}
+}
+
+// frpsProxy 是 frps 管理 API 返回的单个代理。
+type frpsProxy struct {
+	Name string `json:"name"`
+	Conf struct {
+		RemotePort int `json:"remotePort"`
+	} `json:"conf"`
+	Status string `json:"status"`
 }
 
 // Run 启动发现服务。
@@ -28,6 +63,8 @@ func (s *DiscoveryService) Run(stopCh <-chan struct{}) {
 		select {
 		case <-ticker.C:
 			s.discover()
+		case <-s.trigger:
+			s.discover()
 		case <-stopCh:
 			return
 		}
@@ -35,96 +72,321 @@ func (s *DiscoveryService) Run(stopCh <-chan struct{}) {
 }
 
 func (s *DiscoveryService) discover() {
+	start := time.Now()
+	defer func() {
+		metrics.ReconcileDuration.Observe(time.Since(start).Seconds(), "discovery")
+	}()
+
+	proxies, err := s.fetchProxies()
+	if err != nil {
+		// 无法获取代理列表时不做任何降级，避免 frps 短暂不可用导致所有节点离线。
+		log.Printf("Error getting tcp proxies: %v", err)
+		return
+	}
+	s.reconcile(proxies)
+}
+
+// Trigger 请求尽快执行一次轮询调和，不会阻塞。
+func (s *DiscoveryService) Trigger() {
+	select {
+	case s.trigger <- struct{}{}:
+	default: // 已有待执行的调和
+	}
+}
+
+// 节点凭据在 frpc metas 中的键名。
+const (
+	metaNodeID    = "node_id"
+	metaNodeToken = "node_token"
+)
+
+// authenticateClient 用 frpc 携带的 metas 认证节点。
+func (s *DiscoveryService) authenticateClient(metas map[string]string) (*models.Node, error) {
+	nodeID, token := metas[metaNodeID], metas[metaNodeToken]
+	if nodeID == "" || token == "" {
+		return nil, ErrInvalidNodeCredential
+	}
+	node, err := authenticateNode(s.store, nodeID, token)
+	if err != nil {
+		return nil, ErrInvalidNodeCredential
+	}
+	return node, nil
+}
+
+// ClientLogin 处理 frps 的 Login 通知。启用身份校验时，凭据无效的客户端会被拒绝。
+func (s *DiscoveryService) ClientLogin(runID string, metas map[string]string) error {
+	if !s.enforceIdentity {
+		return nil
+	}
+
+	node, err := s.authenticateClient(metas)
+	if err != nil {
+		log.Printf("Rejecting frpc login (run_id %s, node_id %q): %v", runID, metas[metaNodeID], err)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if runID != "" {
+		s.runs[runID] = node.ID
+	}
+	return nil
+}
+
+// ProxyOpened 处理 frps 的 NewProxy 通知：控制隧道对应的节点立即上线。
+// remotePort 为 0 时端口由 frps 随机分配，此时触发一次轮询来获取实际端口。
+// 启用身份校验时，返回错误表示应拒绝该代理。
+func (s *DiscoveryService) ProxyOpened(runID string, metas map[string]string, proxyName string, remotePort int) error {
+	ref, ok := controlProxyNodeRef(proxyName)
+	if !ok {
+		return nil
+	}
+
+	var node *models.Node
+	if s.enforceIdentity {
+		authenticated, err := s.authenticateClient(metas)
+		if err != nil {
+			log.Printf("Rejecting control proxy %s (run_id %s): %v", proxyName, runID, err)
+			return err
+		}
+		if !nodeOwnsRef(authenticated, ref) {
+			log.Printf("Rejecting control proxy %s opened by node %s: %v", proxyName, authenticated.ID, ErrProxyNameMismatch)
+			return ErrProxyNameMismatch
+		}
+		node = authenticated
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if node == nil {
+		resolved, err := ResolveNode(s.store, ref)
+		if err != nil {
+			log.Printf("Control proxy %s does not match any known node: %v", proxyName, err)
+			return nil
+		}
+		node = resolved
+	}
+	if runID != "" {
+		s.runs[runID] = node.ID
+	}
+	if remotePort == 0 {
+		s.Trigger()
+		return nil
+	}
+
+	update := newHealthUpdate(node)
+	if event, ok := markTunnelOnline(node, remotePort, time.Now()); ok {
+		s.apply([]HealthUpdate{update}, []Event{event})
+	}
+	return nil
+}
+
+// nodeOwnsRef 判断隧道名称中的节点引用是否指向该节点本身。
+func nodeOwnsRef(node *models.Node, ref string) bool {
+	return ref == node.ID || (node.LegacyID != 0 && ref == strconv.FormatInt(node.LegacyID, 10))
+}
+
+// ProxyClosed 处理 frps 的 CloseProxy 通知：控制隧道对应的节点立即离线。
+// 只处理没有 run_id 或 run_id 正是该节点当前连接的通知。
+func (s *DiscoveryService) ProxyClosed(runID, proxyName string) {
+	ref, ok := controlProxyNodeRef(proxyName)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, err := ResolveNode(s.store, ref)
+	if err != nil {
+		return
+	}
+	if runID != "" {
+		if s.runs[runID] != node.ID {
+			// 通知来自已被替代的旧连接（节点重连后旧连接才关闭），或服务器重启后尚未记录的连接：
+			// 不据此下线节点，交给下一次轮询核实隧道状态。
+			s.Trigger()
+			return
+		}
+		delete(s.runs, runID)
+	}
+
+	update := newHealthUpdate(node)
+	if event, ok := markTunnelOffline(node, offlineReasonProxyClosed, time.Now()); ok {
+		s.apply([]HealthUpdate{update}, []Event{event})
+	}
+}
+
+func (s *DiscoveryService) fetchProxies() ([]frpsProxy, error) {
 	client := &http.Client{}
 	req, err := http.NewRequest("GET", s.frpsApiUrl+"/api/proxy/tcp", nil)
 	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		return
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.SetBasicAuth(s.frpsUser, s.frpsPass)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Printf("Error getting tcp proxies: %v", err)
-		return
+		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error status code from frps: %d", resp.StatusCode)
-		return
+		return nil, fmt.Errorf("unexpected status code from frps: %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	// Parse the FRP API response with correct structure
 	var response struct {
-		Proxies []struct {
-			Name string `json:"name"`
-			Conf struct {
-				RemotePort int `json:"remotePort"`
-			} `json:"conf"`
-			Status string `json:"status"`
-		} `json:"proxies"`
+		Proxies []frpsProxy `json:"proxies"`
 	}
-
 	if err := json.Unmarshal(body, &response); err != nil {
-		log.Printf("Error unmarshalling response: %v", err)
 		log.Printf("Raw response: %s", string(body))
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return response.Proxies, nil
+}
+
+// controlProxyNodeRef 从控制隧道名称中提取节点引用（UUID 或旧的整数 ID）。
+// frps 可能为代理名加上用户前缀，因此只查找 "control_" 之后的部分。
+func controlProxyNodeRef(name string) (string, bool) {
+	index := strings.Index(name, "control_")
+	if index == -1 {
+		return "", false
+	}
+	return name[index+len("control_"):], true
+}
+
+// reconcile 用代理列表调和所有节点的状态和控制端口。
+func (s *DiscoveryService) reconcile(proxies []frpsProxy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nodes, err := s.store.ListNodes()
+	if err != nil {
+		log.Printf("Error listing nodes for discovery: %v", err)
 		return
 	}
 
-	proxies := response.Proxies
+	// 新 agent 以 UUID 命名隧道，旧 agent 仍使用整数 ID。
+	byRef := make(map[string]*models.Node, 2*len(nodes))
+	for _, node := range nodes {
+		byRef[node.ID] = node
+		if node.LegacyID != 0 {
+			byRef[strconv.FormatInt(node.LegacyID, 10)] = node
+		}
+	}
 
+	online := make(map[string]int)       // 节点 ID -> 在线隧道的端口
+	seenOffline := make(map[string]bool) // 隧道存在但未在线的节点
 	for _, proxy := range proxies {
-		if strings.Contains(proxy.Name, "control_") && proxy.Status == "online" {
-			// 找到 "control_" 的位置，然后提取后面的部分
-			index := strings.Index(proxy.Name, "control_")
-			if index != -1 {
-				nodeIDStr := proxy.Name[index+len("control_"):]
-				s.updateNode(nodeIDStr, proxy.Conf.RemotePort)
+		ref, ok := controlProxyNodeRef(proxy.Name)
+		if !ok {
+			continue
+		}
+		node, ok := byRef[ref]
+		if !ok {
+			log.Printf("Control proxy %s does not match any known node", proxy.Name)
+			continue
+		}
+		if proxy.Status == "online" {
+			online[node.ID] = proxy.Conf.RemotePort
+		} else {
+			seenOffline[node.ID] = true
+		}
+	}
+
+	now := time.Now()
+	var changed []HealthUpdate
+	var events []Event
+	for _, node := range nodes {
+		var event Event
+		var ok bool
+		update := newHealthUpdate(node)
+		if port, found := online[node.ID]; found {
+			event, ok = markTunnelOnline(node, port, now)
+		} else {
+			reason := offlineReasonProxyMissing
+			if seenOffline[node.ID] {
+				reason = offlineReasonProxyOffline
 			}
+			event, ok = markTunnelOffline(node, reason, now)
+		}
+		if ok {
+			changed = append(changed, update)
+			events = append(events, event)
 		}
 	}
+
+	s.apply(changed, events)
 }
 
-func (s *DiscoveryService) updateNode(nodeIDStr string, controlPort int) {
-	nodeID, err := strconv.ParseInt(nodeIDStr, 10, 64)
-	if err != nil {
-		log.Printf("Error parsing node ID %s: %v", nodeIDStr, err)
+// apply 写回发生变化的节点并发送事件，调用方必须持有 s.mu。
+func (s *DiscoveryService) apply(changed []HealthUpdate, events []Event) {
+	if len(changed) == 0 {
 		return
 	}
-	node, err := s.store.GetNode(nodeID)
-	if err != nil {
-		log.Printf("Error getting node %d: %v", nodeID, err)
+	if err := s.store.UpdateNodeHealth(changed); err != nil {
+		log.Printf("Error updating %d nodes after discovery: %v", len(changed), err)
 		return
 	}
-
-	if node.ControlPort == controlPort && node.Status == models.NodeStatusOnline {
-		return // No update needed
+	for _, event := range events {
+		logEvent(s.events, event)
 	}
+}
 
-	node.ControlPort = controlPort
-	node.Status = models.NodeStatusOnline
-	node.LastSeen = time.Now()
+// markTunnelOnline 在节点的控制隧道在线时更新节点，返回对应的事件；节点无需更新时返回 false。
+func markTunnelOnline(node *models.Node, port int, now time.Time) (Event, bool) {
+	event := Event{NodeID: node.ID, Hostname: node.Hostname, OldPort: node.ControlPort, NewPort: port, Time: now}
+	switch {
+	case node.Status != models.NodeStatusOnline && node.Status != models.NodeStatusUnknown:
+		event.Type = EventNodeOnline
+		node.Status = models.NodeStatusOnline
+	case node.ControlPort != port:
+		// Unknown 节点的状态留给健康检查决定，这里只更新端口。
+		event.Type = EventNodeControlPortChanged
+	default:
+		return Event{}, false
+	}
+	node.ControlPort = port
+	node.LastSeen = now
+	return event, true
+}
 
-	if err := s.store.UpdateNode(node); err != nil {
-		log.Printf("Error updating node %d: %v", nodeID, err)
-	} else {
-		log.Printf("Node %d is online, control port: %d", nodeID, controlPort)
+// markTunnelOffline 在节点的控制隧道不可用时将其降级为 Offline；节点本来就不在线时返回 false。
+func markTunnelOffline(node *models.Node, reason string, now time.Time) (Event, bool) {
+	if node.IsDirect() {
+		// 直连节点不依赖隧道，只清除失效的端口，状态留给健康检查决定。
+		if node.ControlPort == 0 {
+			return Event{}, false
+		}
+		event := Event{Type: EventNodeControlPortChanged, NodeID: node.ID, Hostname: node.Hostname, OldPort: node.ControlPort, Reason: reason, Time: now}
+		node.ControlPort = 0
+		return event, true
+	}
+	if node.Status != models.NodeStatusOnline && node.Status != models.NodeStatusUnknown {
+		return Event{}, false // 已经离线，或仍在等待首次上线
 	}
+	event := Event{Type: EventNodeOffline, NodeID: node.ID, Hostname: node.Hostname, OldPort: node.ControlPort, Reason: reason, Time: now}
+	node.Status = models.NodeStatusOffline
+	node.ControlPort = 0
+	return event, true
 }
 
-// NewDiscoveryService 创建一个新的 DiscoveryService 实例。
-func NewDiscoveryService(frpsApiUrl, frpsUser, frpsPass string, store Store) *DiscoveryService {
+// NewDiscoveryService 创建一个新的 DiscoveryService 实例。events 可以为 nil，此时事件只记录日志。
+func NewDiscoveryService(cfg config.FRPConfig, store Store, events EventSink) *DiscoveryService {
 	return &DiscoveryService{
-		frpsApiUrl: frpsApiUrl,
-		frpsUser:   frpsUser,
-		frpsPass:   frpsPass,
-		store:      store,
+		frpsApiUrl:      fmt.Sprintf("http://localhost:%d", cfg.DashboardPort),
+		frpsUser:        cfg.DashboardUser,
+		frpsPass:        cfg.DashboardPwd,
+		store:           store,
+		events:          events,
+		enforceIdentity: cfg.EnforceNodeIdentity,
+		runs:            make(map[string]string),
+		trigger:         make(chan struct{}, 1),
 	}
 }
@@ -0,0 +1,168 @@
+package node
+
+import (
+	"testing"
+	"time"
+	"utopia-server/internal/config"
+	"utopia-server/internal/metrics"
+	"utopia-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testProxy(name string, port int, status string) frpsProxy {
+	proxy := frpsProxy{Name: name, Status: status}
+	proxy.Conf.RemotePort = port
+	return proxy
+}
+
+func TestDiscoveryReconcile(t *testing.T) {
+	store := NewMemStore()
+
+	registering := &models.Node{Hostname: "new", Status: models.NodeStatusRegistering}
+	moved := &models.Node{Hostname: "moved", Status: models.NodeStatusOnline, ControlPort: 7001}
+	vanished := &models.Node{Hostname: "vanished", Status: models.NodeStatusOnline, ControlPort: 7002}
+	stopped := &models.Node{Hostname: "stopped", Status: models.NodeStatusUnknown, ControlPort: 7003}
+	legacy := &models.Node{Hostname: "legacy", Status: models.NodeStatusOffline, LegacyID: 9}
+	direct := &models.Node{Hostname: "direct", Status: models.NodeStatusOnline, Address: "10.0.0.5:8080"}
+	for _, node := range []*models.Node{registering, moved, vanished, stopped, legacy, direct} {
+		require.NoError(t, store.CreateNode(node))
+	}
+
+	var events []Event
+	service := NewDiscoveryService(config.FRPConfig{}, store, EventSinkFunc(func(event Event) {
+		events = append(events, event)
+	}))
+
+	service.reconcile([]frpsProxy{
+		testProxy("control_"+registering.ID, 7000, "online"),
+		testProxy("user.control_"+moved.ID, 7101, "online"),
+		testProxy("control_"+stopped.ID, 7003, "offline"),
+		testProxy("control_9", 7009, "online"),
+		testProxy("ssh_"+vanished.ID, 2222, "online"),
+	})
+
+	expectNode := func(id, status string, port int) {
+		t.Helper()
+		node, err := store.GetNode(id)
+		require.NoError(t, err)
+		assert.Equal(t, status, node.Status, node.Hostname)
+		assert.Equal(t, port, node.ControlPort, node.Hostname)
+	}
+	expectNode(registering.ID, models.NodeStatusOnline, 7000)
+	expectNode(moved.ID, models.NodeStatusOnline, 7101)
+	expectNode(vanished.ID, models.NodeStatusOffline, 0)
+	expectNode(stopped.ID, models.NodeStatusOffline, 0)
+	expectNode(legacy.ID, models.NodeStatusOnline, 7009)
+	expectNode(direct.ID, models.NodeStatusOnline, 0)
+
+	byNode := make(map[string]Event)
+	for _, event := range events {
+		byNode[event.NodeID] = event
+	}
+	require.Len(t, byNode, 5)
+	assert.Equal(t, EventNodeOnline, byNode[registering.ID].Type)
+	assert.Equal(t, EventNodeControlPortChanged, byNode[moved.ID].Type)
+	assert.Equal(t, 7001, byNode[moved.ID].OldPort)
+	assert.Equal(t, EventNodeOffline, byNode[vanished.ID].Type)
+	assert.Equal(t, offlineReasonProxyMissing, byNode[vanished.ID].Reason)
+	assert.Equal(t, offlineReasonProxyOffline, byNode[stopped.ID].Reason)
+	assert.Equal(t, EventNodeOnline, byNode[legacy.ID].Type)
+
+	// A second pass with the same proxies changes nothing.
+	events = nil
+	service.reconcile([]frpsProxy{
+		testProxy("control_"+registering.ID, 7000, "online"),
+		testProxy("user.control_"+moved.ID, 7101, "online"),
+		testProxy("control_9", 7009, "online"),
+	})
+	assert.Empty(t, events)
+}
+
+func TestDiscoveryPluginEvents(t *testing.T) {
+	store := NewMemStore()
+	node := &models.Node{Hostname: "gpu-node-01", Status: models.NodeStatusRegistering}
+	require.NoError(t, store.CreateNode(node))
+
+	var events []Event
+	service := NewDiscoveryService(config.FRPConfig{}, store, EventSinkFunc(func(event Event) {
+		events = append(events, event)
+	}))
+
+	service.ProxyOpened("run-1", nil, "control_"+node.ID, 7100)
+	updated, err := store.GetNode(node.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.NodeStatusOnline, updated.Status)
+	assert.Equal(t, 7100, updated.ControlPort)
+
+	// A randomly assigned port is resolved by the next poll instead.
+	service.ProxyOpened("run-1", nil, "control_"+node.ID, 0)
+	assert.Len(t, service.trigger, 1)
+
+	// A close from a connection that has since been replaced is ignored.
+	service.ProxyClosed("run-0", "control_"+node.ID)
+	updated, err = store.GetNode(node.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.NodeStatusOnline, updated.Status)
+
+	service.ProxyClosed("run-1", "control_"+node.ID)
+	updated, err = store.GetNode(node.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.NodeStatusOffline, updated.Status)
+	assert.Equal(t, 0, updated.ControlPort)
+
+	require.Len(t, events, 2)
+	assert.Equal(t, EventNodeOnline, events[0].Type)
+	assert.Equal(t, EventNodeOffline, events[1].Type)
+	assert.Equal(t, offlineReasonProxyClosed, events[1].Reason)
+}
+
+func TestDiscoveryEnforcesNodeIdentity(t *testing.T) {
+	store := NewMemStore()
+	nodeService := NewService(store, nil, nil, 0)
+	token, _, err := nodeService.CreateBootstrapToken("", "admin", false, time.Hour)
+	require.NoError(t, err)
+	owner, ownerCredential, _, err := nodeService.RegisterNode(token, Registration{Hostname: "gpu-node-01", MachineID: "machine-a"})
+	require.NoError(t, err)
+	impostor, impostorCredential, _, err := nodeService.RegisterNode(token, Registration{Hostname: "gpu-node-02", MachineID: "machine-b"})
+	require.NoError(t, err)
+
+	service := NewDiscoveryService(config.FRPConfig{EnforceNodeIdentity: true}, store, nil)
+	ownerMetas := map[string]string{"node_id": owner.ID, "node_token": ownerCredential.NodeToken}
+	impostorMetas := map[string]string{"node_id": impostor.ID, "node_token": impostorCredential.NodeToken}
+
+	assert.NoError(t, service.ClientLogin("run-1", ownerMetas))
+	assert.ErrorIs(t, service.ClientLogin("run-2", nil), ErrInvalidNodeCredential)
+	assert.ErrorIs(t, service.ClientLogin("run-2", map[string]string{"node_id": owner.ID, "node_token": impostorCredential.NodeToken}), ErrInvalidNodeCredential)
+
+	// A valid node cannot take over another node's control proxy.
+	err = service.ProxyOpened("run-2", impostorMetas, "control_"+owner.ID, 7100)
+	assert.ErrorIs(t, err, ErrProxyNameMismatch)
+	unchanged, err := store.GetNode(owner.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, unchanged.ControlPort)
+
+	require.NoError(t, service.ProxyOpened("run-1", ownerMetas, "control_"+owner.ID, 7100))
+	updated, err := store.GetNode(owner.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 7100, updated.ControlPort)
+
+	// Proxies other than the control tunnel are not checked.
+	assert.NoError(t, service.ProxyOpened("run-2", nil, "ssh_"+owner.ID, 2222))
+}
+
+func TestMetricsSink_CountsTransitions(t *testing.T) {
+	count := func() float64 {
+		for _, sample := range metrics.NodeEvents.Collect()[0].Samples {
+			if sample.Labels[1] == string(EventNodeOffline) && sample.Labels[3] == offlineReasonProxyClosed {
+				return sample.Value
+			}
+		}
+		return 0
+	}
+	before := count()
+
+	MetricsSink.Emit(Event{Type: EventNodeOffline, Reason: offlineReasonProxyClosed})
+	assert.Equal(t, before+1, count())
+}
@@ -0,0 +1,64 @@
+package node
+
+import (
+	"log"
+	"time"
+
+	"utopia-server/internal/metrics"
+)
+
+// EventType 标识节点状态变化的类型。
+type EventType string
+
+const (
+	// EventNodeOnline 表示发现服务发现了节点的隧道，节点上线。
+	EventNodeOnline EventType = "NodeOnline"
+	// EventNodeOffline 表示节点的隧道消失或下线，节点被判定为离线。
+	EventNodeOffline EventType = "NodeOffline"
+	// EventNodeControlPortChanged 表示节点的隧道被 frps 分配了新的端口。
+	EventNodeControlPortChanged EventType = "NodeControlPortChanged"
+)
+
+// Event 描述一次节点状态变化。
+type Event struct {
+	Type     EventType
+	NodeID   string
+	Hostname string
+	OldPort  int
+	NewPort  int
+	Reason   string
+	Time     time.Time
+}
+
+// EventSink 接收节点事件，例如用于审计、告警或推送给前端。
+// Emit 在发现循环中同步调用，实现不应阻塞。
+type EventSink interface {
+	Emit(event Event)
+}
+
+// EventSinkFunc 将普通函数适配为 EventSink。
+type EventSinkFunc func(event Event)
+
+func (f EventSinkFunc) Emit(event Event) {
+	f(event)
+}
+
+// MetricsSink 把节点事件计入 metrics.NodeEvents，用于观察节点上下线的频率。
+var MetricsSink EventSink = EventSinkFunc(func(event Event) {
+	metrics.NodeEvents.Inc(string(event.Type), event.Reason)
+})
+
+// logEvent 记录事件日志，并在 sink 不为 nil 时转发。
+func logEvent(sink EventSink, event Event) {
+	switch event.Type {
+	case EventNodeOnline:
+		log.Printf("Node %s (%s) is online, control port: %d", event.Hostname, event.NodeID, event.NewPort)
+	case EventNodeOffline:
+		log.Printf("Node %s (%s) is offline: %s (control port was %d)", event.Hostname, event.NodeID, event.Reason, event.OldPort)
+	case EventNodeControlPortChanged:
+		log.Printf("Node %s (%s) control port changed from %d to %d", event.Hostname, event.NodeID, event.OldPort, event.NewPort)
+	}
+	if sink != nil {
+		sink.Emit(event)
+	}
+}
@@ -0,0 +1,314 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"utopia-server/internal/models"
+)
+
+// ErrGpuHealthNotFound 表示服务器尚未记录该 GPU 的健康状态。
+var ErrGpuHealthNotFound = errors.New("gpu health not found")
+
+// criticalXids 是表明 GPU 硬件故障或需要重置的 XID，出现一次即隔离：
+// 双比特 ECC、行重映射失败、NVLink 错误、GPU 掉卡、ECC 错误率过高、GSP 故障等。
+var criticalXids = map[int]bool{48: true, 63: true, 64: true, 74: true, 79: true, 92: true, 94: true, 95: true, 119: true, 120: true}
+
+// applicationXids 是通常由用户程序引起的 XID，不计入 GPU 健康评估。
+var applicationXids = map[int]bool{13: true, 31: true, 43: true, 45: true}
+
+// hardwareThrottleReasons 是表明散热或供电异常的降频原因。
+var hardwareThrottleReasons = map[string]bool{
+	"hw_slowdown":             true,
+	"hw_thermal_slowdown":     true,
+	"hw_power_brake_slowdown": true,
+	"sw_thermal_slowdown":     true,
+}
+
+// powerOverdrawRatio 是功耗超出功耗上限多少倍时视为异常。
+const powerOverdrawRatio = 1.05
+
+// assessGpu 检查一次上报中的异常信号。reason 为空表示没有异常；fatal 为 true 表示应立即隔离。
+// ECC 计数器是累计值，只有相对上一次上报增加时才算作新错误；首次上报只记录基线。
+func assessGpu(previous *models.GpuHealth, gpu models.GpuInfo) (reason string, fatal bool) {
+	if previous != nil && gpu.EccErrorsUncorrected > previous.EccUncorrected {
+		return fmt.Sprintf("uncorrectable ECC errors increased to %d", gpu.EccErrorsUncorrected), true
+	}
+	for _, xid := range gpu.XidErrors {
+		if criticalXids[xid] {
+			return fmt.Sprintf("critical XID %d", xid), true
+		}
+	}
+
+	if previous != nil && gpu.EccErrorsCorrected > previous.EccCorrected {
+		return fmt.Sprintf("correctable ECC errors increased to %d", gpu.EccErrorsCorrected), false
+	}
+	for _, xid := range gpu.XidErrors {
+		if !applicationXids[xid] {
+			return fmt.Sprintf("XID %d", xid), false
+		}
+	}
+	for _, throttle := range gpu.ThrottleReasons {
+		if hardwareThrottleReasons[throttle] {
+			return "throttled: " + throttle, false
+		}
+	}
+	if gpu.PowerLimitW > 0 && gpu.PowerDrawW > gpu.PowerLimitW*powerOverdrawRatio {
+		return fmt.Sprintf("power draw %.0fW exceeds limit %.0fW", gpu.PowerDrawW, gpu.PowerLimitW), false
+	}
+	return "", false
+}
+
+// evaluateGpus 根据节点最新上报的 GPU 信息推进每块 GPU 的健康状态机，并把结果写入 GpuInfo.Health。
+//
+//	Healthy --异常--> Suspect --累计 GpuSuspectThreshold 次异常--> Quarantined
+//	Suspect --连续 GpuRecoveryThreshold 次正常--> Healthy
+//	任意状态 --致命错误（不可纠正 ECC、严重 XID）--> Quarantined
+//
+// Quarantined 只能由管理员通过 ClearGpuQuarantine 解除。状态保存在内存中，
+// 只有发生变化的状态才会交给 writer 写回数据库。
+func (s *HealthCheckService) evaluateGpus(node *models.Node, now time.Time) {
+	if len(node.Gpus) == 0 {
+		return
+	}
+
+	s.gpuMu.Lock()
+	defer s.gpuMu.Unlock()
+
+	if err := s.loadGpuHealth(); err != nil {
+		log.Printf("Error loading GPU health: %v", err)
+		return
+	}
+	states := s.gpuHealth[node.ID]
+	if states == nil {
+		states = make(map[string]*models.GpuHealth)
+		s.gpuHealth[node.ID] = states
+	}
+
+	for i := range node.Gpus {
+		gpu := &node.Gpus[i]
+		key := gpuHealthKey(gpu.UUID, gpu.ID)
+		previous := states[key]
+		if legacy := states[gpuHealthKey("", gpu.ID)]; previous == nil && legacy != nil && legacy.GpuUUID == "" {
+			// 之前的上报没有 UUID，沿用按序号记录的状态。
+			previous = legacy
+			delete(states, gpuHealthKey("", gpu.ID))
+			delete(s.gpuPending, gpuKey{node.ID, gpuHealthKey("", gpu.ID)})
+		}
+		reason, fatal := assessGpu(previous, *gpu)
+
+		var next models.GpuHealth
+		if previous != nil {
+			next = *previous
+		} else {
+			next = models.GpuHealth{NodeID: node.ID, State: models.GpuHealthHealthy}
+		}
+		s.advanceGpuHealth(&next, reason, fatal, now)
+		next.GpuIndex = gpu.ID
+		next.GpuUUID = gpu.UUID
+		next.EccCorrected = gpu.EccErrorsCorrected
+		next.EccUncorrected = gpu.EccErrorsUncorrected
+
+		if previous == nil || next != *previous {
+			if previous != nil && next.State != previous.State {
+				log.Printf("GPU %d on node %s (%s) is now %s: %s", gpu.ID, node.Hostname, node.ID, next.State, next.Reason)
+			}
+			next.UpdatedAt = now
+			states[key] = &next
+			s.queueGpuHealth(&next)
+		}
+		gpu.Health = next.State
+	}
+}
+
+// gpuHealthKey 标识节点上的一块 GPU：优先使用 UUID，GPU 序号在重启或换卡后可能变化；
+// agent 没有上报 UUID 时退回到 "index:序号"。它也是 gpu_health 表主键中的 gpu_key。
+func gpuHealthKey(uuid string, index int) string {
+	if uuid != "" {
+		return uuid
+	}
+	return fmt.Sprintf("index:%d", index)
+}
+
+// loadGpuHealth 在第一次使用时从数据库加载所有 GPU 的健康状态。调用方需持有 gpuMu。
+func (s *HealthCheckService) loadGpuHealth() error {
+	if s.gpuHealth != nil {
+		return nil
+	}
+	existing, err := s.store.ListGpuHealth("")
+	if err != nil {
+		return err
+	}
+	s.gpuHealth = make(map[string]map[string]*models.GpuHealth)
+	s.gpuPending = make(map[gpuKey]*models.GpuHealth)
+	for _, state := range existing {
+		if s.gpuHealth[state.NodeID] == nil {
+			s.gpuHealth[state.NodeID] = make(map[string]*models.GpuHealth)
+		}
+		s.gpuHealth[state.NodeID][gpuHealthKey(state.GpuUUID, state.GpuIndex)] = state
+	}
+	return nil
+}
+
+// queueGpuHealth 记下需要写回的状态；同一块 GPU 只保留最新的一份。调用方需持有 gpuMu。
+func (s *HealthCheckService) queueGpuHealth(state *models.GpuHealth) {
+	copied := *state
+	s.gpuPending[gpuKey{state.NodeID, gpuHealthKey(state.GpuUUID, state.GpuIndex)}] = &copied
+}
+
+// flushGpuHealth 把待写回的 GPU 健康状态写入数据库。写入失败的状态留待下次重试，
+// 除非期间已有更新的状态。
+func (s *HealthCheckService) flushGpuHealth() error {
+	s.gpuFlushMu.Lock()
+	defer s.gpuFlushMu.Unlock()
+
+	s.gpuMu.Lock()
+	batch := make([]*models.GpuHealth, 0, len(s.gpuPending))
+	for _, state := range s.gpuPending {
+		batch = append(batch, state)
+	}
+	if len(batch) > 0 {
+		s.gpuPending = make(map[gpuKey]*models.GpuHealth)
+	}
+	s.gpuMu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	err := s.store.SaveGpuHealth(batch)
+	if err != nil {
+		s.gpuMu.Lock()
+		for _, state := range batch {
+			key := gpuKey{state.NodeID, gpuHealthKey(state.GpuUUID, state.GpuIndex)}
+			if _, ok := s.gpuPending[key]; !ok {
+				s.gpuPending[key] = state
+			}
+		}
+		s.gpuMu.Unlock()
+	}
+	return err
+}
+
+// pruneGpuHealth 丢弃已删除节点的 GPU 健康状态，它们在数据库中的记录已随节点一起删除。
+func (s *HealthCheckService) pruneGpuHealth(nodes []*models.Node) {
+	s.gpuMu.Lock()
+	defer s.gpuMu.Unlock()
+
+	if s.gpuHealth == nil {
+		return
+	}
+	exists := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		exists[node.ID] = true
+	}
+	for nodeID := range s.gpuHealth {
+		if !exists[nodeID] {
+			delete(s.gpuHealth, nodeID)
+		}
+	}
+	for key := range s.gpuPending {
+		if !exists[key.nodeID] {
+			delete(s.gpuPending, key)
+		}
+	}
+}
+
+// advanceGpuHealth 按一次上报的评估结果推进单块 GPU 的状态。
+func (s *HealthCheckService) advanceGpuHealth(state *models.GpuHealth, reason string, fatal bool, now time.Time) {
+	quarantine := func() {
+		state.State = models.GpuHealthQuarantined
+		state.Reason = reason
+		quarantinedAt := now
+		state.QuarantinedAt = &quarantinedAt
+	}
+
+	switch {
+	case state.State == models.GpuHealthQuarantined:
+		// 保持隔离，直到管理员解除。
+	case fatal:
+		quarantine()
+	case reason != "":
+		state.SuspectCount++
+		state.CleanCount = 0
+		state.Reason = reason
+		if state.SuspectCount >= max(s.health.GpuSuspectThreshold, 1) {
+			quarantine()
+		} else {
+			state.State = models.GpuHealthSuspect
+		}
+	case state.State == models.GpuHealthSuspect:
+		state.CleanCount++
+		if state.CleanCount >= max(s.health.GpuRecoveryThreshold, 1) {
+			state.State = models.GpuHealthHealthy
+			state.Reason = ""
+			state.SuspectCount = 0
+			state.CleanCount = 0
+		}
+	}
+}
+
+// ClearGpuQuarantine 将节点当前位于 gpuIndex 的 GPU 恢复为 Healthy，使其重新参与调度。
+// 序号按节点最近一次上报的 GPU 列表解析为 UUID，换卡后残留的旧 GPU 状态不受影响。
+// GPU 之后再出现异常时会重新经历状态机。
+func (s *HealthCheckService) ClearGpuQuarantine(nodeID string, gpuIndex int) (*models.GpuHealth, error) {
+	node, err := s.store.GetNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	key := ""
+	for _, gpu := range node.Gpus {
+		if gpu.ID == gpuIndex {
+			key = gpuHealthKey(gpu.UUID, gpu.ID)
+		}
+	}
+
+	s.gpuMu.Lock()
+	if err := s.loadGpuHealth(); err != nil {
+		s.gpuMu.Unlock()
+		return nil, err
+	}
+	state := s.gpuHealth[nodeID][key]
+	if key == "" || state == nil {
+		s.gpuMu.Unlock()
+		return nil, ErrGpuHealthNotFound
+	}
+	state.State = models.GpuHealthHealthy
+	state.Reason = ""
+	state.SuspectCount = 0
+	state.CleanCount = 0
+	state.QuarantinedAt = nil
+	state.UpdatedAt = time.Now()
+	s.queueGpuHealth(state)
+	cleared := *state
+	s.gpuMu.Unlock()
+
+	// 管理员操作立即写回，而不是等待 writer。
+	if err := s.flushGpuHealth(); err != nil {
+		return nil, err
+	}
+
+	// 同步节点记录中的 GPU 状态，使调度器无需等待下一次上报。
+	snapshot := *node
+	snapshot.Gpus = append([]models.GpuInfo(nil), node.Gpus...)
+	for i := range snapshot.Gpus {
+		if snapshot.Gpus[i].ID == gpuIndex {
+			snapshot.Gpus[i].Health = models.GpuHealthHealthy
+		}
+	}
+	// 只写回指标；如果期间已写入更新的上报则跳过，GPU 状态随下一次上报同步。
+	update := HealthUpdate{Node: &snapshot, Metrics: true, ExpectedStatus: snapshot.Status, ExpectedPort: snapshot.ControlPort}
+	if err := s.store.UpdateNodeHealth([]HealthUpdate{update}); err != nil {
+		return nil, err
+	}
+
+	log.Printf("GPU %d on node %s (%s) was released from quarantine", gpuIndex, node.Hostname, node.ID)
+	return &cleared, nil
+}
+
+// ListGpuHealth 返回节点各 GPU 的健康状态；nodeID 为空时返回所有节点的。
+// 状态由健康检查服务异步写回，最多落后一个写回间隔。
+func (s *Service) ListGpuHealth(nodeID string) ([]*models.GpuHealth, error) {
+	return s.store.ListGpuHealth(nodeID)
+}
@@ -1,31 +1,116 @@
 package node
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
+	"utopia-server/internal/client"
 	"utopia-server/internal/config"
+	"utopia-server/internal/metrics"
 	"utopia-server/internal/models"
+	"utopia-server/internal/pki"
+	"utopia-server/internal/secrets"
 )
 
+// probeState 记录单个节点最近的连续探测结果。
+type probeState struct {
+	failures  int
+	successes int
+	nextProbe time.Time
+}
+
+// MetricsRecorder 接收健康检查成功时采集到的节点指标，用于保存指标历史。
+type MetricsRecorder interface {
+	Record(nodeID string, metrics *models.NodeMetrics, at time.Time) error
+}
+
 // HealthCheckService 定期轮询节点健康状况。
+//
+// 节点状态带有迟滞：Online 节点第一次探测失败后转为 Unknown，
+// 连续失败达到 FailureThreshold 次后才判定为 Offline；
+// Unknown 节点需连续成功 SuccessThreshold 次才恢复为 Online。
+// 对 Unknown 节点的探测采用指数退避。
+//
+// 探测由固定数量的 worker 执行，同一节点同一时间最多只有一个探测在进行；
+// 探测结果由单独的 writer 批量写回数据库。
+//
+// 主动上报心跳的节点（推送模式）不会被轮询，每个 HeartbeatTimeout 内没有收到心跳计为一次失败。
+//
+// 直连节点没有隧道可供发现服务感知，因此无论状态如何都会被探测：
+// Offline 节点按退避间隔探测，探测成功后经由 Unknown 恢复为 Online。
 type HealthCheckService struct {
 	store  Store
-	config config.FRPConfig
+	auth   *secrets.AgentAuthenticator
+	tls    *pki.Transports
+	health config.HealthConfig
+	// recorder 可以为 nil，此时不保存指标历史。
+	recorder MetricsRecorder
+
+	mu       sync.Mutex
+	states   map[string]*probeState
+	inFlight map[string]bool
+	latest   map[string]*models.NodeMetrics // 每个节点最近一次成功探测得到的指标
+
+	// gpuMu 保护内存中的 GPU 健康状态，串行化状态机的推进与管理员解除隔离。
+	// gpuHealth 在第一次使用时从数据库加载一次，之后以内存为准；
+	// 发生变化的状态记入 gpuPending，由 writer 批量写回。
+	gpuMu      sync.Mutex
+	gpuHealth  map[string]map[string]*models.GpuHealth // 节点 ID -> gpuHealthKey -> 状态
+	gpuPending map[gpuKey]*models.GpuHealth
+	// gpuFlushMu 串行化 GPU 健康状态的写回，保证较新的状态不会被较早取出的状态覆盖。
+	gpuFlushMu sync.Mutex
+
+	jobs    chan *models.Node
+	results chan HealthUpdate
 }
 
-// NewHealthCheckService 创建一个新的 HealthCheckService 实例。
-func NewHealthCheckService(store Store, cfg config.FRPConfig) *HealthCheckService {
-	return &HealthCheckService{store: store, config: cfg}
+// healthFlushInterval 是 writer 在未攒满一批时写回结果的最长间隔。
+const healthFlushInterval = time.Second
+
+// NewHealthCheckService 创建一个新的 HealthCheckService 实例。auth、tls 与 recorder 可以为 nil：
+// auth 为 nil 时请求不携带认证信息，tls 为 nil 时无法探测持有证书的节点。
+func NewHealthCheckService(store Store, auth *secrets.AgentAuthenticator, tls *pki.Transports, healthCfg config.HealthConfig, recorder MetricsRecorder) *HealthCheckService {
+	return &HealthCheckService{
+		store:    store,
+		auth:     auth,
+		tls:      tls,
+		health:   healthCfg,
+		recorder: recorder,
+		states:   make(map[string]*probeState),
+		inFlight: make(map[string]bool),
+		latest:   make(map[string]*models.NodeMetrics),
+		jobs:     make(chan *models.Node, max(healthCfg.Workers, 1)),
+		results:  make(chan HealthUpdate, max(healthCfg.BatchSize, 1)),
+	}
 }
 
 // Run 启动健康检查轮询循环。
-// 它会阻塞直到 stopCh 被关闭。
+// 它会阻塞直到 stopCh 被关闭，并在返回前取消进行中的探测、写回已完成的结果。
 func (s *HealthCheckService) Run(stopCh <-chan struct{}) {
-	ticker := time.NewTicker(15 * time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var workers sync.WaitGroup
+	for i := 0; i < max(s.health.Workers, 1); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			s.worker(ctx)
+		}()
+	}
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		s.writer()
+	}()
+
+	ticker := time.NewTicker(time.Duration(s.health.Interval) * time.Second)
 	defer ticker.Stop()
 
 	log.Println("Health check service started")
@@ -33,65 +118,307 @@ func (s *HealthCheckService) Run(stopCh <-chan struct{}) {
 	for {
 		select {
 		case <-ticker.C:
-			s.performCheck()
+			s.performCheck(ctx)
 		case <-stopCh:
+			cancel()
+			close(s.jobs)
+			workers.Wait()
+			close(s.results)
+			<-writerDone
 			log.Println("Health check service stopped")
 			return
 		}
 	}
 }
 
-func (s *HealthCheckService) performCheck() {
+func (s *HealthCheckService) performCheck(ctx context.Context) {
 	nodes, err := s.store.ListNodes()
 	if err != nil {
 		log.Printf("Error listing nodes for health check: %v", err)
 		return
 	}
 
+	s.pruneGpuHealth(nodes)
+	s.tls.Retain(nodes)
+
+	now := time.Now()
+	var missedHeartbeats []HealthUpdate
 	for _, node := range nodes {
-		if node.Status == models.NodeStatusOnline {
-			go s.checkNode(node)
+		if node.Status != models.NodeStatusOnline && node.Status != models.NodeStatusUnknown && !node.IsDirect() {
+			continue
+		}
+		if s.inPushMode(node, now) {
+			snapshot := *node
+			update := newHealthUpdate(&snapshot)
+			if s.checkHeartbeat(&snapshot, now) {
+				missedHeartbeats = append(missedHeartbeats, update)
+			}
+			continue
+		}
+		if node.Endpoint() == "" || !s.dueForProbe(node.ID, now) {
+			continue
+		}
+		if !s.markInFlight(node.ID) {
+			continue // 上一轮对该节点的探测尚未完成
 		}
+
+		// 复制一份节点，避免与其他持有同一指针的组件产生数据竞争。
+		snapshot := *node
+		select {
+		case s.jobs <- &snapshot:
+		case <-ctx.Done():
+			s.clearInFlight(node.ID)
+			return
+		default:
+			// 所有 worker 都忙，留待下一轮再探测。
+			s.clearInFlight(node.ID)
+		}
+	}
+
+	if len(missedHeartbeats) == 0 {
+		return
+	}
+	if err := s.store.UpdateNodeHealth(missedHeartbeats); err != nil {
+		log.Printf("Error writing missed heartbeats for %d nodes: %v", len(missedHeartbeats), err)
 	}
 }
 
-func (s *HealthCheckService) checkNode(node *models.Node) {
-	url := fmt.Sprintf("http://localhost:%d/api/v1/metrics", node.ControlPort)
-	req, err := http.NewRequest("GET", url, nil)
+func (s *HealthCheckService) worker(ctx context.Context) {
+	for node := range s.jobs {
+		if ctx.Err() == nil {
+			if update, ok := s.checkNode(ctx, node); ok {
+				s.results <- update
+			}
+		}
+		s.clearInFlight(node.ID)
+	}
+}
+
+// writer 批量写回探测结果：攒满 BatchSize 个或每隔 healthFlushInterval 写一次。
+// 同一节点在一批中只保留最新的结果。待写回的 GPU 健康状态随定时写回一起写入。
+func (s *HealthCheckService) writer() {
+	ticker := time.NewTicker(healthFlushInterval)
+	defer ticker.Stop()
+
+	pending := make(map[string]HealthUpdate)
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		batch := make([]HealthUpdate, 0, len(pending))
+		for _, update := range pending {
+			batch = append(batch, update)
+		}
+		if err := s.store.UpdateNodeHealth(batch); err != nil {
+			log.Printf("Error writing health check results for %d nodes: %v", len(batch), err)
+		}
+		pending = make(map[string]HealthUpdate)
+	}
+	flushAll := func() {
+		flush()
+		if err := s.flushGpuHealth(); err != nil {
+			log.Printf("Error writing GPU health: %v", err)
+		}
+	}
+
+	for {
+		select {
+		case update, ok := <-s.results:
+			if !ok {
+				flushAll()
+				return
+			}
+			pending[update.Node.ID] = update
+			if len(pending) >= max(s.health.BatchSize, 1) {
+				flush()
+			}
+		case <-ticker.C:
+			flushAll()
+		}
+	}
+}
+
+// markInFlight 标记节点正在被探测；如果已有探测在进行则返回 false。
+func (s *HealthCheckService) markInFlight(nodeID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inFlight[nodeID] {
+		return false
+	}
+	s.inFlight[nodeID] = true
+	return true
+}
+
+func (s *HealthCheckService) clearInFlight(nodeID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight, nodeID)
+}
+
+// dueForProbe 判断节点是否已过退避期。
+func (s *HealthCheckService) dueForProbe(nodeID string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[nodeID]
+	return !ok || !now.Before(state.nextProbe)
+}
+
+// probe 向节点 agent 请求指标。传输错误、非 200 响应和解码失败都视为探测失败。
+func (s *HealthCheckService) probe(ctx context.Context, node *models.Node) (*models.NodeMetrics, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.health.Timeout)*time.Second)
+	defer cancel()
+
+	url, err := client.MetricsURL(node)
 	if err != nil {
-		log.Printf("failed to create health check request for node %d: %v", node.ID, err)
-		return
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := s.auth.Authenticate(req, node, nil); err != nil {
+		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+s.config.AgentToken)
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
+	transport, err := s.tls.RoundTripper(node)
 	if err != nil {
-		log.Printf("Node %s (%d) is offline: %v", node.Hostname, node.ID, err)
-		node.Status = models.NodeStatusOffline
-		node.ControlPort = 0 // 清空控制端口
-		node.LastSeen = time.Now()
-		if err := s.store.UpdateNode(node); err != nil {
-			log.Printf("Error updating node %d to offline: %v", node.ID, err)
+		return nil, err
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		if ctx.Err() != context.Canceled { // 服务关闭导致的取消不计为 agent 错误
+			metrics.AgentRequestErrors.Inc("health_probe", "transport")
 		}
-		return
+		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Node %s (%d) returned non-OK status: %s", node.Hostname, node.ID, resp.Status)
-		return
+		metrics.AgentRequestErrors.Inc("health_probe", "status")
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
 	}
 
-	var metrics models.NodeMetrics
-	if err := json.NewDecoder(resp.Body).Decode(&metrics); err != nil {
-		log.Printf("Error decoding metrics from node %s (%d): %v", node.Hostname, node.ID, err)
-		return
+	var nodeMetrics models.NodeMetrics
+	if err := json.NewDecoder(resp.Body).Decode(&nodeMetrics); err != nil {
+		metrics.AgentRequestErrors.Inc("health_probe", "decode")
+		return nil, fmt.Errorf("failed to decode metrics: %w", err)
+	}
+	return &nodeMetrics, nil
+}
+
+// checkNode 探测节点并根据结果更新 node 的健康状态，返回交给 writer 的写回：
+// 只有探测成功时才写回指标，状态变化以探测前读到的状态和控制端口为条件。
+// 如果探测因服务关闭而被取消则返回 false，此时 node 保持不变。
+func (s *HealthCheckService) checkNode(ctx context.Context, node *models.Node) (HealthUpdate, bool) {
+	update := newHealthUpdate(node)
+	metrics, err := s.probe(ctx, node)
+	if ctx.Err() != nil {
+		return HealthUpdate{}, false // 探测被取消不代表节点不健康
 	}
+	if err != nil {
+		s.recordFailure(node, err)
+	} else {
+		s.recordSuccess(node, metrics)
+		update.Metrics = true
+	}
+	return update, true
+}
+
+func (s *HealthCheckService) recordSuccess(node *models.Node, metrics *models.NodeMetrics) {
+	s.mu.Lock()
+	state := s.stateFor(node.ID)
+	state.failures = 0
+	state.successes++
+	state.nextProbe = time.Time{}
+	successes := state.successes
+	s.latest[node.ID] = metrics
+	s.mu.Unlock()
 
 	node.Gpus = metrics.Gpus
+	system := metrics.System
+	node.System = &system
+	if metrics.Inventory != nil {
+		node.Inventory = metrics.Inventory
+	}
+	if metrics.Images != nil {
+		node.Images = metrics.Images
+	}
 	node.LastSeen = time.Now()
-	if err := s.store.UpdateNode(node); err != nil {
-		log.Printf("Error updating node %d with new status: %v", node.ID, err)
+	s.evaluateGpus(node, node.LastSeen)
+
+	if s.recorder != nil {
+		if err := s.recorder.Record(node.ID, metrics, node.LastSeen); err != nil {
+			log.Printf("Error recording metrics history for node %s: %v", node.ID, err)
+		}
+	}
+
+	if node.IsDirect() && node.Status != models.NodeStatusOnline && node.Status != models.NodeStatusUnknown {
+		node.Status = models.NodeStatusUnknown // 直连节点可达，开始恢复计数
+	}
+	if node.Status == models.NodeStatusUnknown && successes >= s.health.SuccessThreshold {
+		log.Printf("Node %s (%s) recovered after %d successful checks", node.Hostname, node.ID, successes)
+		node.Status = models.NodeStatusOnline
+	}
+}
+
+func (s *HealthCheckService) recordFailure(node *models.Node, cause error) {
+	s.mu.Lock()
+	state := s.stateFor(node.ID)
+	state.successes = 0
+	state.failures++
+	failures := state.failures
+	offline := failures >= s.health.FailureThreshold
+	if offline {
+		delete(s.latest, node.ID)
+	}
+	if offline && !node.IsDirect() {
+		delete(s.states, node.ID) // 隧道节点离线后不再探测，等待发现服务
+	} else {
+		state.nextProbe = time.Now().Add(s.backoff(failures))
+	}
+	s.mu.Unlock()
+
+	if node.Status != models.NodeStatusOnline && node.Status != models.NodeStatusUnknown {
+		return // 尚未上线的直连节点只做退避
+	}
+
+	if offline {
+		log.Printf("Node %s (%s) is offline after %d failed checks: %v", node.Hostname, node.ID, failures, cause)
+		node.Status = models.NodeStatusOffline
+		if !node.IsDirect() {
+			node.ControlPort = 0 // 清空控制端口，等待发现服务重新发现隧道
+		}
+		return
+	}
+
+	log.Printf("Node %s (%s) failed health check (%d/%d): %v", node.Hostname, node.ID, failures, s.health.FailureThreshold, cause)
+	node.Status = models.NodeStatusUnknown
+}
+
+// stateFor 返回节点的探测状态，调用方必须持有 s.mu。
+func (s *HealthCheckService) stateFor(nodeID string) *probeState {
+	state, ok := s.states[nodeID]
+	if !ok {
+		state = &probeState{}
+		s.states[nodeID] = state
+	}
+	return state
+}
+
+// backoff 计算第 failures 次连续失败后的退避时间：BackoffBase * 2^(failures-1)，不超过 BackoffMax。
+func (s *HealthCheckService) backoff(failures int) time.Duration {
+	base := time.Duration(s.health.BackoffBase) * time.Second
+	max := time.Duration(s.health.BackoffMax) * time.Second
+
+	delay := base
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
 	}
+	return delay
 }
@@ -0,0 +1,71 @@
+package node
+
+import (
+	"log"
+	"strconv"
+
+	"utopia-server/internal/metrics"
+	"utopia-server/internal/models"
+)
+
+const bytesPerMB = 1024 * 1024
+
+// Collect 实现 metrics.Collector，导出各节点及其 GPU 的最新健康检查结果。
+// 节点列表在抓取时读取，因此被删除的节点不会留下过期的序列。
+func (s *HealthCheckService) Collect() []*metrics.Family {
+	nodes, err := s.store.ListNodes()
+	if err != nil {
+		log.Printf("Error listing nodes for metrics: %v", err)
+		return nil
+	}
+
+	up := metrics.NewFamily("utopia_node_up", "Whether the node is Online (1) or not (0).", metrics.TypeGauge)
+	cpu := metrics.NewFamily("utopia_node_cpu_usage_percent", "Node CPU utilization from the last successful health check.", metrics.TypeGauge)
+	memory := metrics.NewFamily("utopia_node_memory_usage_percent", "Node memory utilization from the last successful health check.", metrics.TypeGauge)
+	gpuUtil := metrics.NewFamily("utopia_gpu_utilization_percent", "GPU utilization from the last successful health check.", metrics.TypeGauge)
+	gpuMemUsed := metrics.NewFamily("utopia_gpu_memory_used_bytes", "GPU memory in use.", metrics.TypeGauge)
+	gpuMemTotal := metrics.NewFamily("utopia_gpu_memory_total_bytes", "Total GPU memory.", metrics.TypeGauge)
+	gpuTemp := metrics.NewFamily("utopia_gpu_temperature_celsius", "GPU temperature.", metrics.TypeGauge)
+	gpuBusy := metrics.NewFamily("utopia_gpu_busy", "Whether the GPU is allocated to a workload (1) or free (0).", metrics.TypeGauge)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, node := range nodes {
+		up.Add(boolToFloat(node.Status == models.NodeStatusOnline), "node_id", node.ID, "hostname", node.Hostname, "status", node.Status)
+
+		if node.Status != models.NodeStatusOnline && node.Status != models.NodeStatusUnknown {
+			continue // 离线节点的指标已过期
+		}
+
+		if latest, ok := s.latest[node.ID]; ok {
+			cpuUsage, memoryUsage := latest.System.CPUUsagePercent, latest.System.MemoryUsagePercent
+			if cpuUsage == 0 {
+				cpuUsage = latest.CPUUsagePercent
+			}
+			if memoryUsage == 0 {
+				memoryUsage = latest.MemoryUsagePercent
+			}
+			cpu.Add(cpuUsage, "node_id", node.ID, "hostname", node.Hostname)
+			memory.Add(memoryUsage, "node_id", node.ID, "hostname", node.Hostname)
+		}
+
+		for _, gpu := range node.Gpus {
+			labels := []string{"node_id", node.ID, "hostname", node.Hostname, "gpu", strconv.Itoa(gpu.ID), "uuid", gpu.UUID}
+			gpuUtil.Add(float64(gpu.UsagePercent), labels...)
+			gpuMemUsed.Add(float64(gpu.MemoryUsedMB)*bytesPerMB, labels...)
+			gpuMemTotal.Add(float64(gpu.MemoryTotalMB)*bytesPerMB, labels...)
+			gpuTemp.Add(float64(gpu.TemperatureC), labels...)
+			gpuBusy.Add(boolToFloat(gpu.Busy), labels...)
+		}
+	}
+
+	return []*metrics.Family{up, cpu, memory, gpuUtil, gpuMemUsed, gpuMemTotal, gpuTemp, gpuBusy}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,408 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"utopia-server/internal/client"
+	"utopia-server/internal/config"
+	"utopia-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAgentServer starts a fake node agent and returns its port. The status
+// code it responds with can be changed through the returned pointer.
+func newAgentServer(t *testing.T) (int, *int) {
+	t.Helper()
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		json.NewEncoder(w).Encode(models.NodeMetrics{Gpus: []models.GpuInfo{{ID: 0, UUID: "GPU-0"}}})
+	}))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+	return port, &status
+}
+
+func newTestHealthCheckService(store Store) *HealthCheckService {
+	return NewHealthCheckService(store, nil, nil, config.HealthConfig{
+		Timeout:              1,
+		FailureThreshold:     3,
+		SuccessThreshold:     2,
+		BackoffBase:          10,
+		BackoffMax:           30,
+		HeartbeatTimeout:     30,
+		GpuSuspectThreshold:  2,
+		GpuRecoveryThreshold: 2,
+	}, nil)
+}
+
+func TestHealthCheck_FailureHysteresis(t *testing.T) {
+	port, status := newAgentServer(t)
+	store := NewMemStore()
+	node := &models.Node{Hostname: "gpu-node-01", Status: models.NodeStatusOnline, ControlPort: port}
+	require.NoError(t, store.CreateNode(node))
+	service := newTestHealthCheckService(store)
+
+	*status = http.StatusInternalServerError
+	service.checkNode(context.Background(), node)
+	assert.Equal(t, models.NodeStatusUnknown, node.Status)
+	assert.Equal(t, port, node.ControlPort)
+	assert.False(t, service.dueForProbe(node.ID, time.Now()), "unhealthy node should be backed off")
+
+	service.checkNode(context.Background(), node)
+	assert.Equal(t, models.NodeStatusUnknown, node.Status)
+
+	service.checkNode(context.Background(), node)
+	assert.Equal(t, models.NodeStatusOffline, node.Status)
+	assert.Zero(t, node.ControlPort)
+}
+
+func TestHealthCheck_RecoveryRequiresSuccessThreshold(t *testing.T) {
+	port, status := newAgentServer(t)
+	store := NewMemStore()
+	node := &models.Node{Hostname: "gpu-node-01", Status: models.NodeStatusOnline, ControlPort: port}
+	require.NoError(t, store.CreateNode(node))
+	service := newTestHealthCheckService(store)
+
+	*status = http.StatusServiceUnavailable
+	service.checkNode(context.Background(), node)
+	require.Equal(t, models.NodeStatusUnknown, node.Status)
+
+	*status = http.StatusOK
+	service.checkNode(context.Background(), node)
+	assert.Equal(t, models.NodeStatusUnknown, node.Status)
+	assert.Len(t, node.Gpus, 1)
+
+	service.checkNode(context.Background(), node)
+	assert.Equal(t, models.NodeStatusOnline, node.Status)
+}
+
+func TestHealthCheck_Backoff(t *testing.T) {
+	service := newTestHealthCheckService(NewMemStore())
+
+	assert.Equal(t, 10*time.Second, service.backoff(1))
+	assert.Equal(t, 20*time.Second, service.backoff(2))
+	assert.Equal(t, 30*time.Second, service.backoff(3))
+	assert.Equal(t, 30*time.Second, service.backoff(10))
+}
+
+func TestHealthCheck_DedupesInFlightProbes(t *testing.T) {
+	store := NewMemStore()
+	node := &models.Node{Hostname: "gpu-node-01", Status: models.NodeStatusOnline, ControlPort: 1}
+	require.NoError(t, store.CreateNode(node))
+	service := NewHealthCheckService(store, nil, nil, config.HealthConfig{Workers: 4, BatchSize: 10}, nil)
+
+	// No workers are running, so the first probe stays in flight.
+	service.performCheck(context.Background())
+	service.performCheck(context.Background())
+	assert.Len(t, service.jobs, 1)
+
+	queued := <-service.jobs
+	assert.NotSame(t, node, queued, "workers must operate on a copy of the node")
+}
+
+func TestHealthCheck_MissedHeartbeats(t *testing.T) {
+	store := NewMemStore()
+	node := &models.Node{Hostname: "gpu-node-01", Status: models.NodeStatusOnline, ControlPort: 1}
+	require.NoError(t, store.CreateNode(node))
+	service := newTestHealthCheckService(store)
+
+	reported, err := service.ReportHeartbeat(node, &models.NodeMetrics{Gpus: []models.GpuInfo{{ID: 0}}})
+	require.NoError(t, err)
+	require.NotNil(t, reported.LastHeartbeat)
+	start := *reported.LastHeartbeat
+
+	current := func() *models.Node {
+		n, err := store.GetNode(node.ID)
+		require.NoError(t, err)
+		return n
+	}
+	require.True(t, service.inPushMode(current(), start), "push-mode nodes are not polled")
+
+	service.performCheck(context.Background())
+	assert.Empty(t, service.jobs)
+
+	// One missed heartbeat window is counted once, however often the checker runs.
+	assert.True(t, service.checkHeartbeat(current(), start.Add(31*time.Second)))
+	assert.False(t, service.checkHeartbeat(current(), start.Add(45*time.Second)))
+
+	missed := current()
+	require.True(t, service.checkHeartbeat(missed, start.Add(61*time.Second)))
+	assert.Equal(t, models.NodeStatusUnknown, missed.Status)
+	require.True(t, service.checkHeartbeat(missed, start.Add(91*time.Second)))
+	assert.Equal(t, models.NodeStatusOffline, missed.Status)
+}
+
+func TestHealthCheck_HeartbeatRecovery(t *testing.T) {
+	store := NewMemStore()
+	node := &models.Node{Hostname: "gpu-node-01", Status: models.NodeStatusUnknown, ControlPort: 1}
+	require.NoError(t, store.CreateNode(node))
+	service := newTestHealthCheckService(store)
+
+	inventory := &models.NodeInventory{AgentVersion: "1.4.0", CudaVersion: "12.2"}
+	reported, err := service.ReportHeartbeat(node, &models.NodeMetrics{Inventory: inventory})
+	require.NoError(t, err)
+	assert.Equal(t, models.NodeStatusUnknown, reported.Status)
+
+	reported, err = service.ReportHeartbeat(reported, &models.NodeMetrics{System: models.SystemMetrics{Uptime: 60}})
+	require.NoError(t, err)
+	assert.Equal(t, models.NodeStatusOnline, reported.Status)
+
+	stored, err := store.GetNode(node.ID)
+	require.NoError(t, err)
+	assert.Equal(t, inventory, stored.Inventory, "the inventory is kept when a report omits it")
+	require.NotNil(t, stored.System)
+	assert.Equal(t, 60, stored.System.Uptime)
+
+	offline := &models.Node{Hostname: "gpu-node-02", Status: models.NodeStatusOffline}
+	require.NoError(t, store.CreateNode(offline))
+	for i := 0; i < 3; i++ {
+		reported, err = service.ReportHeartbeat(offline, &models.NodeMetrics{})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, models.NodeStatusOffline, reported.Status, "heartbeats alone do not bring a node online")
+}
+
+func TestHealthCheck_DirectNode(t *testing.T) {
+	port, status := newAgentServer(t)
+	store := NewMemStore()
+	node := &models.Node{Hostname: "gpu-node-01", Status: models.NodeStatusOffline, Address: "127.0.0.1:" + strconv.Itoa(port)}
+	require.NoError(t, store.CreateNode(node))
+	service := newTestHealthCheckService(store)
+
+	// Offline direct nodes are still probed and recover without a tunnel.
+	service.performCheck(context.Background())
+	require.Len(t, service.jobs, 1)
+	probed := <-service.jobs
+	service.clearInFlight(probed.ID)
+
+	service.checkNode(context.Background(), probed)
+	assert.Equal(t, models.NodeStatusUnknown, probed.Status)
+	service.checkNode(context.Background(), probed)
+	assert.Equal(t, models.NodeStatusOnline, probed.Status)
+
+	*status = http.StatusInternalServerError
+	for i := 0; i < 3; i++ {
+		service.checkNode(context.Background(), probed)
+	}
+	assert.Equal(t, models.NodeStatusOffline, probed.Status)
+	assert.False(t, service.dueForProbe(probed.ID, time.Now()), "offline direct nodes are probed with backoff")
+
+	service.checkNode(context.Background(), probed)
+	assert.Equal(t, models.NodeStatusOffline, probed.Status)
+}
+
+func TestHealthCheck_GpuHealthStateMachine(t *testing.T) {
+	store := NewMemStore()
+	node := &models.Node{Hostname: "gpu-node-01", Status: models.NodeStatusOnline, ControlPort: 1}
+	require.NoError(t, store.CreateNode(node))
+	service := newTestHealthCheckService(store)
+
+	report := func(gpus ...models.GpuInfo) []models.GpuInfo {
+		t.Helper()
+		reported, err := service.ReportHeartbeat(node, &models.NodeMetrics{Gpus: gpus})
+		require.NoError(t, err)
+		node = reported
+		return reported.Gpus
+	}
+	clean := models.GpuInfo{ID: 0, EccErrorsCorrected: 5}
+	throttled := models.GpuInfo{ID: 0, EccErrorsCorrected: 5, ThrottleReasons: []string{"hw_slowdown"}}
+
+	gpus := report(clean, models.GpuInfo{ID: 1})
+	assert.Equal(t, models.GpuHealthHealthy, gpus[0].Health, "the first ECC count is only a baseline")
+
+	assert.Equal(t, models.GpuHealthSuspect, report(throttled, models.GpuInfo{ID: 1})[0].Health)
+	assert.Equal(t, models.GpuHealthSuspect, report(clean, models.GpuInfo{ID: 1})[0].Health)
+	assert.Equal(t, models.GpuHealthHealthy, report(clean, models.GpuInfo{ID: 1})[0].Health, "suspect GPUs recover after clean reports")
+
+	report(throttled, models.GpuInfo{ID: 1})
+	gpus = report(throttled, models.GpuInfo{ID: 1, XidErrors: []int{79}})
+	assert.Equal(t, models.GpuHealthQuarantined, gpus[0].Health, "repeated anomalies quarantine the GPU")
+	assert.Equal(t, models.GpuHealthQuarantined, gpus[1].Health, "critical XIDs quarantine immediately")
+
+	gpus = report(clean, models.GpuInfo{ID: 1})
+	assert.Equal(t, models.GpuHealthQuarantined, gpus[0].Health, "quarantine is only lifted by an admin")
+
+	cleared, err := service.ClearGpuQuarantine(node.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, models.GpuHealthHealthy, cleared.State)
+	stored, err := store.GetNode(node.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.GpuHealthHealthy, stored.Gpus[1].Health)
+
+	_, err = service.ClearGpuQuarantine(node.ID, 7)
+	assert.ErrorIs(t, err, ErrGpuHealthNotFound)
+
+	quarantined, err := NewService(store, nil, nil, 0).ListGpuHealth(node.ID)
+	require.NoError(t, err)
+	require.Len(t, quarantined, 2)
+	assert.Equal(t, models.GpuHealthQuarantined, quarantined[0].State)
+	assert.Equal(t, "throttled: hw_slowdown", quarantined[0].Reason)
+}
+
+func TestHealthCheck_StaleResultDoesNotOverwriteTransition(t *testing.T) {
+	port, status := newAgentServer(t)
+	store := NewMemStore()
+	node := &models.Node{Hostname: "gpu-node-01", Status: models.NodeStatusOnline, ControlPort: port, LastSeen: time.Now().Add(-time.Minute)}
+	require.NoError(t, store.CreateNode(node))
+	service := newTestHealthCheckService(store)
+
+	// 探测进行期间发现服务把节点标记为离线。
+	snapshot := *node
+	node.Status = models.NodeStatusOffline
+	node.ControlPort = 0
+
+	*status = http.StatusInternalServerError
+	update, ok := service.checkNode(context.Background(), &snapshot)
+	require.True(t, ok)
+	assert.False(t, update.Metrics, "failed probes must not write metrics")
+	require.NoError(t, store.UpdateNodeHealth([]HealthUpdate{update}))
+
+	stored, err := store.GetNode(node.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.NodeStatusOffline, stored.Status)
+	assert.Zero(t, stored.ControlPort)
+
+	// 成功的探测只写回指标，同样不会让节点重新上线。
+	*status = http.StatusOK
+	snapshot = models.Node{ID: node.ID, Hostname: node.Hostname, Status: models.NodeStatusOnline, ControlPort: port}
+	update, ok = service.checkNode(context.Background(), &snapshot)
+	require.True(t, ok)
+	require.NoError(t, store.UpdateNodeHealth([]HealthUpdate{update}))
+
+	assert.Equal(t, models.NodeStatusOffline, stored.Status)
+	assert.Len(t, stored.Gpus, 1)
+}
+
+// countingStore counts health write-backs and GPU health reads.
+type countingStore struct {
+	Store
+	healthWrites   int
+	gpuHealthReads int
+}
+
+func (s *countingStore) ListGpuHealth(nodeID string) ([]*models.GpuHealth, error) {
+	s.gpuHealthReads++
+	return s.Store.ListGpuHealth(nodeID)
+}
+
+func (s *countingStore) UpdateNodeHealth(updates []HealthUpdate) error {
+	s.healthWrites++
+	return s.Store.UpdateNodeHealth(updates)
+}
+
+func TestHealthCheck_MissedHeartbeatWriteBack(t *testing.T) {
+	store := &countingStore{Store: NewMemStore()}
+	heartbeat := time.Now()
+	node := &models.Node{Hostname: "gpu-node-01", Status: models.NodeStatusOnline, ControlPort: 1, LastHeartbeat: &heartbeat}
+	require.NoError(t, store.CreateNode(node))
+	service := newTestHealthCheckService(store)
+
+	// 没有缺失的心跳时不写数据库。
+	service.performCheck(context.Background())
+	assert.Zero(t, store.healthWrites)
+
+	// 缺失心跳的判定以检查前的状态为条件，不会覆盖期间发现服务的修改。
+	snapshot := *node
+	update := newHealthUpdate(&snapshot)
+	require.True(t, service.checkHeartbeat(&snapshot, heartbeat.Add(31*time.Second)))
+	node.Status = models.NodeStatusOffline
+	node.ControlPort = 0
+	require.NoError(t, store.UpdateNodeHealth([]HealthUpdate{update}))
+	assert.Equal(t, models.NodeStatusOffline, node.Status)
+	assert.Zero(t, node.ControlPort)
+}
+
+func TestHealthCheck_GpuHealthFollowsUUID(t *testing.T) {
+	store := &countingStore{Store: NewMemStore()}
+	node := &models.Node{Hostname: "gpu-node-01", Status: models.NodeStatusOnline, ControlPort: 1}
+	require.NoError(t, store.CreateNode(node))
+	service := newTestHealthCheckService(store)
+
+	report := func(gpus ...models.GpuInfo) []models.GpuInfo {
+		t.Helper()
+		reported, err := service.ReportHeartbeat(node, &models.NodeMetrics{Gpus: gpus})
+		require.NoError(t, err)
+		node = reported
+		return reported.Gpus
+	}
+
+	gpus := report(models.GpuInfo{ID: 0, UUID: "GPU-a", XidErrors: []int{79}}, models.GpuInfo{ID: 1, UUID: "GPU-b"})
+	assert.Equal(t, models.GpuHealthQuarantined, gpus[0].Health)
+
+	// 重启后 GPU 序号互换，状态跟随 UUID。
+	gpus = report(models.GpuInfo{ID: 0, UUID: "GPU-b"}, models.GpuInfo{ID: 1, UUID: "GPU-a"})
+	assert.Equal(t, models.GpuHealthHealthy, gpus[0].Health)
+	assert.Equal(t, models.GpuHealthQuarantined, gpus[1].Health)
+	assert.Equal(t, 1, store.gpuHealthReads, "GPU health is loaded once")
+
+	stored, err := store.ListGpuHealth(node.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored, "GPU health is written back by the writer")
+
+	require.NoError(t, service.flushGpuHealth())
+	stored, err = store.ListGpuHealth(node.ID)
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Equal(t, "GPU-a", stored[1].GpuUUID)
+	assert.Equal(t, models.GpuHealthQuarantined, stored[1].State)
+
+	// 解除隔离时按当前上报的 GPU 列表把序号解析为 UUID。
+	cleared, err := service.ClearGpuQuarantine(node.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "GPU-a", cleared.GpuUUID)
+	assert.Equal(t, models.GpuHealthHealthy, cleared.State)
+}
+
+func TestHealthCheck_GpuHealthAdoptsIndexKeyedState(t *testing.T) {
+	store := NewMemStore()
+	node := &models.Node{Hostname: "gpu-node-01", Status: models.NodeStatusOnline, ControlPort: 1}
+	require.NoError(t, store.CreateNode(node))
+	service := newTestHealthCheckService(store)
+
+	reported, err := service.ReportHeartbeat(node, &models.NodeMetrics{Gpus: []models.GpuInfo{{ID: 0, XidErrors: []int{79}}}})
+	require.NoError(t, err)
+	require.NoError(t, service.flushGpuHealth())
+
+	// agent 升级后开始上报 UUID，沿用按序号记录的状态，并替换数据库中的旧记录。
+	reported, err = service.ReportHeartbeat(reported, &models.NodeMetrics{Gpus: []models.GpuInfo{{ID: 0, UUID: "GPU-a"}}})
+	require.NoError(t, err)
+	assert.Equal(t, models.GpuHealthQuarantined, reported.Gpus[0].Health)
+	require.NoError(t, service.flushGpuHealth())
+
+	stored, err := store.ListGpuHealth(node.ID)
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.Equal(t, "GPU-a", stored[0].GpuUUID)
+	assert.Equal(t, models.GpuHealthQuarantined, stored[0].State)
+}
+
+func TestHealthCheck_ProbeUsesNegotiatedAgentAPI(t *testing.T) {
+	port, _ := newAgentServer(t)
+	service := newTestHealthCheckService(NewMemStore())
+
+	node := &models.Node{ControlPort: port, Inventory: &models.NodeInventory{APIVersions: []string{models.AgentAPIV1}}}
+	_, err := service.probe(context.Background(), node)
+	require.NoError(t, err)
+
+	// 没有共同 API 版本的 agent 不知道指标接口在哪里，不发送请求。
+	node.Inventory.APIVersions = []string{"v9"}
+	_, err = service.probe(context.Background(), node)
+	assert.ErrorIs(t, err, client.ErrIncompatibleAgent)
+}
@@ -0,0 +1,55 @@
+package node
+
+import (
+	"fmt"
+	"time"
+
+	"utopia-server/internal/models"
+)
+
+// ReportHeartbeat 处理 agent 主动上报的心跳，按一次成功的健康检查更新节点并立即写回。
+// 心跳不会让隧道节点从 Offline 上线，其上线仍由发现服务根据隧道状态决定。
+func (s *HealthCheckService) ReportHeartbeat(node *models.Node, metrics *models.NodeMetrics) (*models.Node, error) {
+	snapshot := *node
+	update := newHealthUpdate(&snapshot)
+	update.Metrics = true
+	s.recordSuccess(&snapshot, metrics)
+	heartbeat := snapshot.LastSeen
+	snapshot.LastHeartbeat = &heartbeat
+
+	if err := s.store.UpdateNodeHealth([]HealthUpdate{update}); err != nil {
+		return nil, fmt.Errorf("failed to record heartbeat: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// inPushMode 判断节点是否处于推送模式：最近的心跳足够新，足以在判定 Offline 之前被发现缺失。
+// 心跳停止足够久之后节点回退为轮询模式。
+func (s *HealthCheckService) inPushMode(node *models.Node, now time.Time) bool {
+	if node.LastHeartbeat == nil || s.health.HeartbeatTimeout <= 0 {
+		return false
+	}
+	window := time.Duration(s.health.HeartbeatTimeout*(s.health.FailureThreshold+1)) * time.Second
+	return now.Sub(*node.LastHeartbeat) < window
+}
+
+// checkHeartbeat 将每个 HeartbeatTimeout 内缺失的心跳计为一次失败。
+// 节点状态发生变化时返回 true，调用方负责以检查前的状态为条件写回。
+func (s *HealthCheckService) checkHeartbeat(node *models.Node, now time.Time) bool {
+	timeout := time.Duration(s.health.HeartbeatTimeout) * time.Second
+	since := now.Sub(*node.LastHeartbeat)
+	missed := int(since / timeout)
+	if missed == 0 {
+		return false
+	}
+
+	s.mu.Lock()
+	counted := s.stateFor(node.ID).failures
+	s.mu.Unlock()
+	if missed <= counted {
+		return false // 这个窗口的缺失已经计过
+	}
+
+	s.recordFailure(node, fmt.Errorf("no heartbeat for %s", since.Round(time.Second)))
+	return true
+}
@@ -0,0 +1,199 @@
+package node
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"utopia-server/internal/models"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Inventory 是静态节点清单，用于初始化集群或灾难恢复后重建节点记录。
+//
+//	nodes:
+//	  - hostname: gpu-node-01
+//	    machine_id: 4c4c4544-0042-3510-8052-b4c04f4d3232
+//	    address: 10.0.0.5:8080
+//	    expected_gpus: 8
+//	    labels:
+//	      rack: a1
+type Inventory struct {
+	Nodes []InventoryNode `yaml:"nodes"`
+}
+
+// InventoryNode 声明清单中的一个节点，节点按主机名与已有记录匹配。
+type InventoryNode struct {
+	Hostname     string            `yaml:"hostname"`
+	Address      string            `yaml:"address"`       // 可选的直连地址 host:port
+	ExpectedGpus int               `yaml:"expected_gpus"` // 期望的 GPU 数量，0 表示不检查
+	Labels       map[string]string `yaml:"labels"`        // 未设置时保留节点现有的标签
+	// MachineID 是节点的机器标识（见 MachineIdentity）。agent 只有上报了清单中声明的机器标识才能接管该节点，
+	// 未声明时需由管理员把 agent 注册出的新节点合并到清单节点。
+	MachineID string `yaml:"machine_id"`
+}
+
+// 清单报告中的问题类型。
+const (
+	// InventoryNeverOnline 表示清单中的节点自声明以来从未上线。
+	InventoryNeverOnline = "NeverOnline"
+	// InventoryGpuMismatch 表示在线节点上报的 GPU 数量与清单声明的不一致。
+	InventoryGpuMismatch = "GpuCountMismatch"
+)
+
+// InventoryResult 汇总一次清单导入的结果。
+type InventoryResult struct {
+	Created []string          `json:"created"`
+	Updated []string          `json:"updated"`
+	Skipped map[string]string `json:"skipped,omitempty"` // 主机名 -> 跳过原因
+}
+
+// InventoryStatus 描述一个由清单声明的节点及其存在的问题。
+type InventoryStatus struct {
+	Node     *models.Node `json:"node"`
+	Problems []string     `json:"problems"`
+}
+
+// LoadInventory 从 YAML 文件读取并校验节点清单。
+func LoadInventory(path string) (*Inventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory: %w", err)
+	}
+	return ParseInventory(data)
+}
+
+// ParseInventory 解析并校验 YAML 格式的节点清单。
+func ParseInventory(data []byte) (*Inventory, error) {
+	var inventory Inventory
+	if err := yaml.Unmarshal(data, &inventory); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory: %w", err)
+	}
+
+	seen := make(map[string]bool, len(inventory.Nodes))
+	for i, entry := range inventory.Nodes {
+		if entry.Hostname == "" {
+			return nil, fmt.Errorf("inventory node %d: hostname is required", i)
+		}
+		if seen[entry.Hostname] {
+			return nil, fmt.Errorf("inventory node %s: duplicate hostname", entry.Hostname)
+		}
+		seen[entry.Hostname] = true
+		if entry.Address != "" && !IsValidAddress(entry.Address) {
+			return nil, fmt.Errorf("inventory node %s: address must be in host:port form", entry.Hostname)
+		}
+		if entry.ExpectedGpus < 0 {
+			return nil, fmt.Errorf("inventory node %s: expected_gpus must not be negative", entry.Hostname)
+		}
+	}
+	return &inventory, nil
+}
+
+// IsValidAddress reports whether address is a host:port with a valid port number.
+func IsValidAddress(address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// ImportInventory 按清单创建或更新节点。
+// 清单中的节点按主机名匹配已有节点；不存在的节点以 Registering 状态创建，
+// 等待 agent 以声明的机器标识注册或（直连节点）被健康检查探测到后上线。
+// 同一主机名对应多个已有节点时跳过该条目，需先由管理员合并重复节点。
+func (s *Service) ImportInventory(inventory *Inventory) (*InventoryResult, error) {
+	nodes, err := s.store.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+	byHostname := make(map[string][]*models.Node)
+	for _, node := range nodes {
+		byHostname[node.Hostname] = append(byHostname[node.Hostname], node)
+	}
+
+	now := time.Now()
+	result := &InventoryResult{Created: []string{}, Updated: []string{}}
+	for _, entry := range inventory.Nodes {
+		matches := byHostname[entry.Hostname]
+		switch len(matches) {
+		case 0:
+			node := &models.Node{
+				Hostname:     entry.Hostname,
+				MachineID:    entry.MachineID,
+				Address:      entry.Address,
+				Labels:       entry.Labels,
+				ExpectedGpus: entry.ExpectedGpus,
+				Status:       models.NodeStatusRegistering,
+				LastSeen:     now,
+				DeclaredAt:   &now,
+			}
+			if err := s.store.CreateNode(node); err != nil {
+				return result, fmt.Errorf("failed to create inventory node %s: %w", entry.Hostname, err)
+			}
+			result.Created = append(result.Created, node.ID)
+
+		case 1:
+			node := matches[0]
+			if entry.Address != "" {
+				node.Address = entry.Address
+			}
+			if entry.MachineID != "" && node.CredentialHash == "" {
+				node.MachineID = entry.MachineID // 已注册节点的机器标识由 agent 决定
+			}
+			if entry.Labels != nil {
+				node.Labels = entry.Labels
+			}
+			node.ExpectedGpus = entry.ExpectedGpus
+			if node.DeclaredAt == nil {
+				node.DeclaredAt = &now
+			}
+			if err := s.store.UpdateNode(node); err != nil {
+				return result, fmt.Errorf("failed to update inventory node %s: %w", entry.Hostname, err)
+			}
+			result.Updated = append(result.Updated, node.ID)
+
+		default:
+			if result.Skipped == nil {
+				result.Skipped = make(map[string]string)
+			}
+			result.Skipped[entry.Hostname] = fmt.Sprintf("%d nodes share this hostname", len(matches))
+		}
+	}
+	return result, nil
+}
+
+// InventoryReport 列出由清单声明且存在问题的节点：从未上线的节点，
+// 以及在线但 GPU 数量与声明不一致的节点。结果按声明时间排序，最早的在前。
+func (s *Service) InventoryReport() ([]InventoryStatus, error) {
+	nodes, err := s.store.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	report := []InventoryStatus{}
+	for _, node := range nodes {
+		if node.DeclaredAt == nil {
+			continue
+		}
+		var problems []string
+		if node.Status == models.NodeStatusRegistering {
+			problems = append(problems, InventoryNeverOnline)
+		}
+		if node.Status == models.NodeStatusOnline && node.ExpectedGpus > 0 && len(node.Gpus) != node.ExpectedGpus {
+			problems = append(problems, InventoryGpuMismatch)
+		}
+		if len(problems) > 0 {
+			report = append(report, InventoryStatus{Node: node, Problems: problems})
+		}
+	}
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Node.DeclaredAt.Before(*report[j].Node.DeclaredAt)
+	})
+	return report, nil
+}
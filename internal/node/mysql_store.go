@@ -4,9 +4,14 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"time"
 	"utopia-server/internal/models"
+
+	"github.com/google/uuid"
 )
 
+const nodeColumns = "id, legacy_id, hostname, status, labels, gpus, control_port, last_seen, credential_hash, machine_id, last_heartbeat, address, expected_gpus, declared_at, inventory, `system`, agent_secret, credentials_issued_at, certificate_serial, certificate_expires_at, images, claimed_machine_id, requested_address"
+
 type mysqlStore struct {
 	db *sql.DB
 }
@@ -15,50 +20,154 @@ func NewMySQLStore(db *sql.DB) Store {
 	return &mysqlStore{db: db}
 }
 
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanNode(row rowScanner) (*models.Node, error) {
+	var node models.Node
+	var labels, gpus, inventory, system, images []byte
+	var legacyID sql.NullInt64
+	var credentialHash, machineID, address, agentSecret, certificateSerial, claimedMachineID, requestedAddress sql.NullString
+	var lastHeartbeat, declaredAt, credentialsIssuedAt, certificateExpiresAt sql.NullTime
+	if err := row.Scan(&node.ID, &legacyID, &node.Hostname, &node.Status, &labels, &gpus, &node.ControlPort, &node.LastSeen, &credentialHash, &machineID, &lastHeartbeat, &address, &node.ExpectedGpus, &declaredAt, &inventory, &system, &agentSecret, &credentialsIssuedAt, &certificateSerial, &certificateExpiresAt, &images, &claimedMachineID, &requestedAddress); err != nil {
+		return nil, err
+	}
+	if lastHeartbeat.Valid {
+		node.LastHeartbeat = &lastHeartbeat.Time
+	}
+	if declaredAt.Valid {
+		node.DeclaredAt = &declaredAt.Time
+	}
+	if credentialsIssuedAt.Valid {
+		node.CredentialsIssuedAt = &credentialsIssuedAt.Time
+	}
+	if certificateExpiresAt.Valid {
+		node.CertificateExpiresAt = &certificateExpiresAt.Time
+	}
+	node.LegacyID = legacyID.Int64
+	node.CredentialHash = credentialHash.String
+	node.MachineID = machineID.String
+	node.Address = address.String
+	node.AgentSecret = agentSecret.String
+	node.CertificateSerial = certificateSerial.String
+	node.ClaimedMachineID = claimedMachineID.String
+	node.RequestedAddress = requestedAddress.String
+
+	if len(labels) > 0 {
+		if err := json.Unmarshal(labels, &node.Labels); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal labels: %w", err)
+		}
+	}
+	if err := json.Unmarshal(gpus, &node.Gpus); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal gpus: %w", err)
+	}
+	if len(inventory) > 0 {
+		if err := json.Unmarshal(inventory, &node.Inventory); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal inventory: %w", err)
+		}
+	}
+	if len(system) > 0 {
+		if err := json.Unmarshal(system, &node.System); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal system metrics: %w", err)
+		}
+	}
+	if len(images) > 0 {
+		if err := json.Unmarshal(images, &node.Images); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal images: %w", err)
+		}
+	}
+	return &node, nil
+}
+
+// nullIfEmpty 将空字符串映射为 NULL，使唯一索引允许多个未设置的值。
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// marshalOptional 将可选的 JSON 列编码，nil 映射为 NULL。
+func marshalOptional[T any](value *T) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
+
+func marshalLabels(labels map[string]string) ([]byte, error) {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return json.Marshal(labels)
+}
+
 func (s *mysqlStore) CreateNode(node *models.Node) error {
 	gpus, err := json.Marshal(node.Gpus)
 	if err != nil {
 		return fmt.Errorf("failed to marshal gpus: %w", err)
 	}
-
-	query := "INSERT INTO nodes (hostname, status, gpus, control_port, last_seen) VALUES (?, ?, ?, ?, ?)"
-	result, err := s.db.Exec(query, node.Hostname, node.Status, gpus, node.ControlPort, node.LastSeen)
+	labels, err := marshalLabels(node.Labels)
 	if err != nil {
-		return fmt.Errorf("failed to create node: %w", err)
+		return fmt.Errorf("failed to marshal labels: %w", err)
+	}
+	inventory, err := marshalOptional(node.Inventory)
+	if err != nil {
+		return fmt.Errorf("failed to marshal inventory: %w", err)
 	}
 
-	id, err := result.LastInsertId()
+	if node.ID == "" {
+		node.ID = uuid.NewString()
+	}
+
+	query := "INSERT INTO nodes (id, hostname, status, labels, gpus, control_port, last_seen, credential_hash, machine_id, address, expected_gpus, declared_at, inventory, agent_secret, credentials_issued_at, certificate_serial, certificate_expires_at, claimed_machine_id, requested_address) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err = s.db.Exec(query, node.ID, node.Hostname, node.Status, labels, gpus, node.ControlPort, node.LastSeen, node.CredentialHash, nullIfEmpty(node.MachineID), nullIfEmpty(node.Address), node.ExpectedGpus, node.DeclaredAt, inventory, nullIfEmpty(node.AgentSecret), node.CredentialsIssuedAt, nullIfEmpty(node.CertificateSerial), node.CertificateExpiresAt, nullIfEmpty(node.ClaimedMachineID), nullIfEmpty(node.RequestedAddress))
 	if err != nil {
-		return fmt.Errorf("failed to get last insert ID: %w", err)
+		return fmt.Errorf("failed to create node: %w", err)
 	}
-	node.ID = id
 	return nil
 }
 
-func (s *mysqlStore) GetNode(id int64) (*models.Node, error) {
-	query := "SELECT id, hostname, status, gpus, control_port, last_seen FROM nodes WHERE id = ?"
-	row := s.db.QueryRow(query, id)
-
-	var node models.Node
-	var gpus []byte
-	err := row.Scan(&node.ID, &node.Hostname, &node.Status, &gpus, &node.ControlPort, &node.LastSeen)
+func (s *mysqlStore) GetNode(id string) (*models.Node, error) {
+	query := "SELECT " + nodeColumns + " FROM nodes WHERE id = ?"
+	node, err := scanNode(s.db.QueryRow(query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("node not found")
+			return nil, ErrNodeNotFound
 		}
 		return nil, fmt.Errorf("failed to get node: %w", err)
 	}
+	return node, nil
+}
 
-	err = json.Unmarshal(gpus, &node.Gpus)
+func (s *mysqlStore) GetNodeByLegacyID(legacyID int64) (*models.Node, error) {
+	query := "SELECT " + nodeColumns + " FROM nodes WHERE legacy_id = ?"
+	node, err := scanNode(s.db.QueryRow(query, legacyID))
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal gpus: %w", err)
+		if err == sql.ErrNoRows {
+			return nil, ErrNodeNotFound
+		}
+		return nil, fmt.Errorf("failed to get node by legacy ID: %w", err)
 	}
+	return node, nil
+}
 
-	return &node, nil
+func (s *mysqlStore) GetNodeByMachineID(machineID string) (*models.Node, error) {
+	query := "SELECT " + nodeColumns + " FROM nodes WHERE machine_id = ?"
+	node, err := scanNode(s.db.QueryRow(query, machineID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNodeNotFound
+		}
+		return nil, fmt.Errorf("failed to get node by machine ID: %w", err)
+	}
+	return node, nil
 }
 
 func (s *mysqlStore) ListNodes() ([]*models.Node, error) {
-	query := "SELECT id, hostname, status, gpus, control_port, last_seen FROM nodes"
+	query := "SELECT " + nodeColumns + " FROM nodes"
 	rows, err := s.db.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list nodes: %w", err)
@@ -67,33 +176,362 @@ func (s *mysqlStore) ListNodes() ([]*models.Node, error) {
 
 	var nodes []*models.Node
 	for rows.Next() {
-		var node models.Node
-		var gpus []byte
-		err := rows.Scan(&node.ID, &node.Hostname, &node.Status, &gpus, &node.ControlPort, &node.LastSeen)
+		node, err := scanNode(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan node: %w", err)
 		}
-
-		err = json.Unmarshal(gpus, &node.Gpus)
-		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshal gpus: %w", err)
-		}
-		nodes = append(nodes, &node)
+		nodes = append(nodes, node)
 	}
 
 	return nodes, nil
 }
 
+// UpdateNode 写回节点的注册信息与元数据。状态、控制端口、最近在线时间和 GPU 只由 UpdateNodeHealth 写入，
+// 避免注册或管理员修改时用读到的旧值覆盖健康检查与发现服务的结果。
 func (s *mysqlStore) UpdateNode(node *models.Node) error {
-	gpus, err := json.Marshal(node.Gpus)
+	return updateNode(s.db, node)
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func updateNode(db execer, node *models.Node) error {
+	labels, err := marshalLabels(node.Labels)
 	if err != nil {
-		return fmt.Errorf("failed to marshal gpus for update: %w", err)
+		return fmt.Errorf("failed to marshal labels for update: %w", err)
+	}
+	inventory, err := marshalOptional(node.Inventory)
+	if err != nil {
+		return fmt.Errorf("failed to marshal inventory for update: %w", err)
 	}
 
-	query := "UPDATE nodes SET status = ?, control_port = ?, last_seen = ?, gpus = ? WHERE id = ?"
-	_, err = s.db.Exec(query, node.Status, node.ControlPort, node.LastSeen, gpus, node.ID)
+	query := "UPDATE nodes SET hostname = ?, labels = ?, credential_hash = ?, machine_id = ?, address = ?, expected_gpus = ?, declared_at = ?, inventory = ?, agent_secret = ?, credentials_issued_at = ?, certificate_serial = ?, certificate_expires_at = ?, claimed_machine_id = ?, requested_address = ? WHERE id = ?"
+	_, err = db.Exec(query, node.Hostname, labels, node.CredentialHash, nullIfEmpty(node.MachineID), nullIfEmpty(node.Address), node.ExpectedGpus, node.DeclaredAt, inventory, nullIfEmpty(node.AgentSecret), node.CredentialsIssuedAt, nullIfEmpty(node.CertificateSerial), node.CertificateExpiresAt, nullIfEmpty(node.ClaimedMachineID), nullIfEmpty(node.RequestedAddress), node.ID)
 	if err != nil {
 		return fmt.Errorf("failed to update node: %w", err)
 	}
 	return nil
 }
+
+// MergeNode 在单个事务中把重复节点上的 GpuClaim 与指标历史迁移到 target、删除重复节点并写回 target，
+// 任何一步失败都不会留下一半迁移的数据。
+func (s *mysqlStore) MergeNode(target *models.Node, duplicateID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE gpu_claims SET status = JSON_SET(status, '$.nodeName', ?) WHERE status->>"$.nodeName" = ?`, target.ID, duplicateID); err != nil {
+		return fmt.Errorf("failed to reassign gpu claims: %w", err)
+	}
+	// 指标历史归入目标节点；与目标节点同一时刻的样本冲突时保留目标节点的，其余删除。
+	for _, table := range metricHistoryTables {
+		if _, err := tx.Exec("UPDATE IGNORE "+table+" SET node_id = ? WHERE node_id = ?", target.ID, duplicateID); err != nil {
+			return fmt.Errorf("failed to move %s to node %s: %w", table, target.ID, err)
+		}
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE node_id = ?", duplicateID); err != nil {
+			return fmt.Errorf("failed to delete %s of node %s: %w", table, duplicateID, err)
+		}
+	}
+	// 先删除重复节点，以释放其机器标识上的唯一约束。
+	result, err := tx.Exec("DELETE FROM nodes WHERE id = ?", duplicateID)
+	if err != nil {
+		return fmt.Errorf("failed to delete duplicate node %s: %w", duplicateID, err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	} else if affected == 0 {
+		return ErrNodeNotFound
+	}
+	if err := updateNode(tx, target); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit node merge: %w", err)
+	}
+	return nil
+}
+
+// UpdateNodeHealth 在单个事务中写回一批健康检查结果。
+// 只更新健康相关的列，避免覆盖检查期间管理员对主机名或标签的修改；
+// 指标和状态分别按 HealthUpdate 的条件写入，避免旧快照覆盖检查期间发生的变化。
+func (s *mysqlStore) UpdateNodeHealth(updates []HealthUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	metricsStmt, err := tx.Prepare("UPDATE nodes SET last_seen = ?, last_heartbeat = ?, gpus = ?, inventory = ?, `system` = ?, images = ? WHERE id = ? AND (last_seen IS NULL OR last_seen <= ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare health update: %w", err)
+	}
+	defer metricsStmt.Close()
+
+	statusStmt, err := tx.Prepare("UPDATE nodes SET status = ?, control_port = ?, last_seen = IF(last_seen IS NULL OR last_seen < ?, ?, last_seen) WHERE id = ? AND status = ? AND control_port = ?")
+	if err != nil {
+		return fmt.Errorf("failed to prepare status update: %w", err)
+	}
+	defer statusStmt.Close()
+
+	for _, update := range updates {
+		node := update.Node
+		if update.Metrics {
+			gpus, err := json.Marshal(node.Gpus)
+			if err != nil {
+				return fmt.Errorf("failed to marshal gpus for node %s: %w", node.ID, err)
+			}
+			inventory, err := marshalOptional(node.Inventory)
+			if err != nil {
+				return fmt.Errorf("failed to marshal inventory for node %s: %w", node.ID, err)
+			}
+			system, err := marshalOptional(node.System)
+			if err != nil {
+				return fmt.Errorf("failed to marshal system metrics for node %s: %w", node.ID, err)
+			}
+			images, err := json.Marshal(node.Images)
+			if err != nil {
+				return fmt.Errorf("failed to marshal images for node %s: %w", node.ID, err)
+			}
+			if _, err := metricsStmt.Exec(node.LastSeen, node.LastHeartbeat, gpus, inventory, system, images, node.ID, node.LastSeen); err != nil {
+				return fmt.Errorf("failed to update health of node %s: %w", node.ID, err)
+			}
+		}
+		if update.transition() {
+			if _, err := statusStmt.Exec(node.Status, node.ControlPort, node.LastSeen, node.LastSeen, node.ID, update.ExpectedStatus, update.ExpectedPort); err != nil {
+				return fmt.Errorf("failed to update status of node %s: %w", node.ID, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit health updates: %w", err)
+	}
+	return nil
+}
+
+// DeleteNode 在单个事务中删除节点及其指标历史。指标历史表没有外键，不会随节点级联删除。
+func (s *mysqlStore) DeleteNode(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM nodes WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete node: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return ErrNodeNotFound
+	}
+	for _, table := range metricHistoryTables {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE node_id = ?", id); err != nil {
+			return fmt.Errorf("failed to delete %s of node %s: %w", table, id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit node deletion: %w", err)
+	}
+	return nil
+}
+
+// metricHistoryTables 是以 node_id 记录节点指标历史的表（见 history 包）。
+var metricHistoryTables = []string{"node_metric_samples", "gpu_metric_samples"}
+
+const bootstrapTokenColumns = "id, token_hash, description, single_use, expires_at, use_count, last_used_at, created_by, created_at"
+
+func scanBootstrapToken(row rowScanner) (*models.BootstrapToken, error) {
+	var token models.BootstrapToken
+	var expiresAt, lastUsedAt sql.NullTime
+	if err := row.Scan(&token.ID, &token.TokenHash, &token.Description, &token.SingleUse, &expiresAt, &token.UseCount, &lastUsedAt, &token.CreatedBy, &token.CreatedAt); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	return &token, nil
+}
+
+func (s *mysqlStore) CreateBootstrapToken(token *models.BootstrapToken) error {
+	query := "INSERT INTO bootstrap_tokens (token_hash, description, single_use, expires_at, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?)"
+	result, err := s.db.Exec(query, token.TokenHash, token.Description, token.SingleUse, token.ExpiresAt, token.CreatedBy, token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create bootstrap token: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	token.ID = id
+	return nil
+}
+
+func (s *mysqlStore) ListBootstrapTokens() ([]*models.BootstrapToken, error) {
+	query := "SELECT " + bootstrapTokenColumns + " FROM bootstrap_tokens ORDER BY id"
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bootstrap tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []*models.BootstrapToken
+	for rows.Next() {
+		token, err := scanBootstrapToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan bootstrap token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// ConsumeBootstrapToken 原子地校验并记录一次令牌使用，避免单次令牌被并发重复使用。
+func (s *mysqlStore) ConsumeBootstrapToken(tokenHash string, now time.Time) (*models.BootstrapToken, error) {
+	query := `
+		UPDATE bootstrap_tokens SET use_count = use_count + 1, last_used_at = ?
+		WHERE token_hash = ?
+		  AND (expires_at IS NULL OR expires_at > ?)
+		  AND (single_use = FALSE OR use_count = 0)
+	`
+	result, err := s.db.Exec(query, now, tokenHash, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume bootstrap token: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return nil, ErrInvalidBootstrapToken
+	}
+
+	token, err := scanBootstrapToken(s.db.QueryRow("SELECT "+bootstrapTokenColumns+" FROM bootstrap_tokens WHERE token_hash = ?", tokenHash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bootstrap token: %w", err)
+	}
+	return token, nil
+}
+
+// ReleaseBootstrapToken 撤销一次 ConsumeBootstrapToken 记录的使用。
+func (s *mysqlStore) ReleaseBootstrapToken(tokenHash string) error {
+	if _, err := s.db.Exec("UPDATE bootstrap_tokens SET use_count = use_count - 1 WHERE token_hash = ? AND use_count > 0", tokenHash); err != nil {
+		return fmt.Errorf("failed to release bootstrap token: %w", err)
+	}
+	return nil
+}
+
+func (s *mysqlStore) DeleteBootstrapToken(id int64) error {
+	result, err := s.db.Exec("DELETE FROM bootstrap_tokens WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete bootstrap token: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("bootstrap token not found")
+	}
+	return nil
+}
+
+const gpuHealthColumns = "node_id, gpu_index, gpu_uuid, state, reason, suspect_count, clean_count, ecc_corrected, ecc_uncorrected, quarantined_at, updated_at"
+
+func (s *mysqlStore) ListGpuHealth(nodeID string) ([]*models.GpuHealth, error) {
+	query := "SELECT " + gpuHealthColumns + " FROM gpu_health"
+	var args []interface{}
+	if nodeID != "" {
+		query += " WHERE node_id = ?"
+		args = append(args, nodeID)
+	}
+	query += " ORDER BY node_id, gpu_index, gpu_uuid"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list gpu health: %w", err)
+	}
+	defer rows.Close()
+
+	states := make([]*models.GpuHealth, 0)
+	for rows.Next() {
+		var state models.GpuHealth
+		var gpuUUID, reason sql.NullString
+		var quarantinedAt sql.NullTime
+		if err := rows.Scan(&state.NodeID, &state.GpuIndex, &gpuUUID, &state.State, &reason, &state.SuspectCount, &state.CleanCount, &state.EccCorrected, &state.EccUncorrected, &quarantinedAt, &state.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan gpu health: %w", err)
+		}
+		state.GpuUUID = gpuUUID.String
+		state.Reason = reason.String
+		if quarantinedAt.Valid {
+			state.QuarantinedAt = &quarantinedAt.Time
+		}
+		states = append(states, &state)
+	}
+	return states, rows.Err()
+}
+
+// SaveGpuHealth 在单个事务中插入或更新一批 GPU 健康状态。
+func (s *mysqlStore) SaveGpuHealth(states []*models.GpuHealth) error {
+	if len(states) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 之前没有上报 UUID 时按序号记录的状态已被沿用，删除旧记录。
+	legacy, err := tx.Prepare("DELETE FROM gpu_health WHERE node_id = ? AND gpu_key = ?")
+	if err != nil {
+		return fmt.Errorf("failed to prepare legacy gpu health delete: %w", err)
+	}
+	defer legacy.Close()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO gpu_health (gpu_key, ` + gpuHealthColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE gpu_index = VALUES(gpu_index), gpu_uuid = VALUES(gpu_uuid), state = VALUES(state), reason = VALUES(reason),
+			suspect_count = VALUES(suspect_count), clean_count = VALUES(clean_count), ecc_corrected = VALUES(ecc_corrected),
+			ecc_uncorrected = VALUES(ecc_uncorrected), quarantined_at = VALUES(quarantined_at), updated_at = VALUES(updated_at)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare gpu health upsert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, state := range states {
+		if state.GpuUUID != "" {
+			if _, err := legacy.Exec(state.NodeID, gpuHealthKey("", state.GpuIndex)); err != nil {
+				return fmt.Errorf("failed to delete legacy health of gpu %d on node %s: %w", state.GpuIndex, state.NodeID, err)
+			}
+		}
+		if _, err := stmt.Exec(gpuHealthKey(state.GpuUUID, state.GpuIndex), state.NodeID, state.GpuIndex, nullIfEmpty(state.GpuUUID), state.State, nullIfEmpty(state.Reason), state.SuspectCount, state.CleanCount, state.EccCorrected, state.EccUncorrected, state.QuarantinedAt, state.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to save health of gpu %d on node %s: %w", state.GpuIndex, state.NodeID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit gpu health: %w", err)
+	}
+	return nil
+}
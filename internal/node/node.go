@@ -1,20 +1,37 @@
 package node
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"utopia-server/internal/models"
+	"utopia-server/internal/pki"
+	"utopia-server/internal/secrets"
+
+	"github.com/google/uuid"
 )
 
 // Service 封装了节点管理的业务逻辑。
 type Service struct {
-	store Store
+	store   Store
+	box     *secrets.Box  // 加密节点的 agent 密钥；为 nil 时不签发 agent 密钥
+	ca      *pki.CA       // 为节点签发证书；为 nil 时不启用双向 TLS
+	certTTL time.Duration // 节点证书的有效期
 }
 
-// NewService 创建一个新的节点服务实例。
-func NewService(store Store) *Service {
+// NewService 创建一个新的节点服务实例。box 为 nil 时节点只获得节点凭据，服务器使用共享的 frp.agent_token 调用 agent；
+// ca 为 nil 时节点不能申请证书，服务器通过 HTTP 访问所有 agent。
+func NewService(store Store, box *secrets.Box, ca *pki.CA, certTTL time.Duration) *Service {
 	return &Service{
-		store: store,
+		store:   store,
+		box:     box,
+		ca:      ca,
+		certTTL: certTTL,
 	}
 }
 
@@ -33,7 +50,409 @@ func (s *Service) CreateNode(hostname string) (*models.Node, error) {
 	return newNode, nil
 }
 
-// GetNode retrieves a node by its ID.
-func (s *Service) GetNode(id int64) (*models.Node, error) {
-	return s.store.GetNode(id)
+// MachineIdentity 计算节点的稳定机器标识。
+// 优先使用 agent 上报的机器 ID（如 /etc/machine-id）；否则退化为 GPU UUID 集合的摘要。
+// 两者都没有时返回空字符串，此时无法进行幂等注册。
+func MachineIdentity(machineID string, gpuUUIDs []string) string {
+	if machineID = strings.TrimSpace(machineID); machineID != "" {
+		return machineID
+	}
+	if len(gpuUUIDs) == 0 {
+		return ""
+	}
+
+	uuids := append([]string(nil), gpuUUIDs...)
+	sort.Strings(uuids)
+	return "gpu:" + hashSecret(strings.Join(uuids, ","))
+}
+
+// Registration 是 agent 注册时上报的节点信息。
+type Registration struct {
+	Hostname  string
+	MachineID string                // 稳定的机器标识，见 MachineIdentity
+	Address   string                // 可选的直连地址 host:port，只记录为 RequestedAddress，由管理员确认后生效
+	Inventory *models.NodeInventory // 可选的软硬件清单，为 nil 时保留节点已有的清单
+	CSR       []byte                // 可选的 PEM 证书签名请求，提供后为 agent 签发双向 TLS 证书
+	// NodeToken 是 agent 重新注册时出示的当前节点凭据。只有凭据属于机器标识匹配的节点时才复用该节点。
+	NodeToken string
+}
+
+// Credentials 是签发给节点的凭据明文，只在注册或轮换时返回一次。
+type Credentials struct {
+	NodeToken   string // agent 调用服务器、登录隧道时使用，服务器只保存摘要
+	AgentSecret string // 服务器调用 agent 时的签名密钥，服务器加密保存；未配置主密钥时为空
+	// Certificate 是为注册请求中的 CSR 签发的证书，没有提交 CSR 时为 nil。
+	Certificate *pki.Certificate
+}
+
+// RegisterNode 使用管理员签发的注册令牌注册节点，并为其签发专属凭据。
+// 如果机器标识与已有节点匹配且请求出示了该节点当前的凭据，则复用该节点并轮换其凭据，而不是创建重复节点；
+// 静态清单声明了该机器标识、尚未注册的节点无需凭据即可接管。
+// 机器标识可以伪造，没有出示凭据时创建一个记录了所声称标识（ClaimedMachineID）的新节点，由管理员确认后合并。
+// created 表示是否新建了节点。
+// 返回的凭据明文只出现这一次，之后节点需用它进行 agent 与隧道通信的认证。
+func (s *Service) RegisterNode(bootstrapToken string, reg Registration) (node *models.Node, creds Credentials, created bool, err error) {
+	if bootstrapToken == "" {
+		return nil, creds, false, ErrInvalidBootstrapToken
+	}
+	// 先校验 CSR，避免无效请求消耗一次性注册令牌。
+	if len(reg.CSR) > 0 {
+		if s.ca == nil {
+			return nil, creds, false, ErrCertificatesDisabled
+		}
+		if _, err := pki.ParseCSR(reg.CSR); err != nil {
+			return nil, creds, false, err
+		}
+	}
+	tokenHash := hashSecret(bootstrapToken)
+	if _, err := s.store.ConsumeBootstrapToken(tokenHash, time.Now()); err != nil {
+		return nil, creds, false, err
+	}
+	// 令牌的使用与节点写入不在同一事务中：写入失败时归还令牌，避免一次性令牌被白白消耗。
+	defer func() {
+		if err != nil {
+			if releaseErr := s.store.ReleaseBootstrapToken(tokenHash); releaseErr != nil {
+				log.Printf("Error releasing bootstrap token after failed registration: %v", releaseErr)
+			}
+		}
+	}()
+
+	if reg.MachineID != "" {
+		if existing, err := s.store.GetNodeByMachineID(reg.MachineID); err == nil {
+			declared := existing.DeclaredAt != nil && existing.CredentialHash == ""
+			if !declared && !secretMatchesHash(reg.NodeToken, existing.CredentialHash) {
+				log.Printf("Registration of %s claims the machine ID of node %s (%s) without its credential; creating a node pending admin merge", reg.Hostname, existing.Hostname, existing.ID)
+				return s.createNode(reg, "", reg.MachineID)
+			}
+			existing.Hostname = reg.Hostname
+			requestAddress(existing, reg.Address)
+			if reg.Inventory != nil {
+				existing.Inventory = reg.Inventory
+			}
+			if creds, err = s.issueCredentials(existing); err != nil {
+				return nil, creds, false, err
+			}
+			if creds.Certificate, err = s.issueCertificate(existing, reg.CSR); err != nil {
+				return nil, creds, false, err
+			}
+			existing.LastSeen = time.Now()
+			if err := s.store.UpdateNode(existing); err != nil {
+				return nil, creds, false, err
+			}
+			return existing, creds, false, nil
+		}
+	}
+
+	return s.createNode(reg, reg.MachineID, "")
+}
+
+// createNode 为注册请求创建新节点并签发凭据。claimedMachineID 非空时节点不持有机器标识，
+// 只记录它声称的标识，等待管理员合并。
+func (s *Service) createNode(reg Registration, machineID, claimedMachineID string) (*models.Node, Credentials, bool, error) {
+	node := &models.Node{
+		ID:               uuid.NewString(), // 证书需要在保存前确定节点 ID
+		Hostname:         reg.Hostname,
+		MachineID:        machineID,
+		ClaimedMachineID: claimedMachineID,
+		RequestedAddress: reg.Address,
+		Inventory:        reg.Inventory,
+		Status:           models.NodeStatusRegistering,
+		LastSeen:         time.Now(),
+	}
+	creds, err := s.issueCredentials(node)
+	if err != nil {
+		return nil, creds, false, err
+	}
+	if creds.Certificate, err = s.issueCertificate(node, reg.CSR); err != nil {
+		return nil, creds, false, err
+	}
+	if err := s.store.CreateNode(node); err != nil {
+		return nil, creds, false, err
+	}
+
+	return node, creds, true, nil
+}
+
+// requestAddress 记录 agent 请求的直连地址。直连地址决定服务器把节点凭据、agent 签名和镜像仓库凭据发往何处，
+// 因此只能由管理员（PATCH 或静态清单）设置，agent 上报的地址不会直接生效。
+func requestAddress(node *models.Node, address string) {
+	if address == node.Address {
+		address = ""
+	}
+	node.RequestedAddress = address
+}
+
+// RotateCredentials 为节点重新签发节点凭据和 agent 密钥，旧的凭据与密钥立即失效。
+// 管理员需要把返回的明文配置到节点 agent 上，在此之前服务器与 agent 之间的调用都会失败。
+func (s *Service) RotateCredentials(ref string) (*models.Node, Credentials, error) {
+	node, err := ResolveNode(s.store, ref)
+	if err != nil {
+		return nil, Credentials{}, err
+	}
+	creds, err := s.issueCredentials(node)
+	if err != nil {
+		return nil, Credentials{}, err
+	}
+	if err := s.store.UpdateNode(node); err != nil {
+		return nil, Credentials{}, err
+	}
+	return node, creds, nil
+}
+
+// RenewCertificate 为节点重新签发证书，agent 应在证书的 RenewAfter 之后、到期之前调用。
+// 服务器从此只接受新证书（见 pki.Transports），agent 拿到新证书后应立即切换。
+func (s *Service) RenewCertificate(ref string, csrPEM []byte) (*models.Node, *pki.Certificate, error) {
+	if s.ca == nil {
+		return nil, nil, ErrCertificatesDisabled
+	}
+	node, err := ResolveNode(s.store, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := s.issueCertificate(node, csrPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.store.UpdateNode(node); err != nil {
+		return nil, nil, err
+	}
+	return node, cert, nil
+}
+
+// CACertificate 返回 PEM 编码的内部 CA 证书，未配置 CA 时返回 nil。
+func (s *Service) CACertificate() []byte {
+	if s.ca == nil {
+		return nil
+	}
+	return s.ca.CertPEM()
+}
+
+// issueCertificate 为 CSR 签发节点证书并记录到 node（尚未保存）。csrPEM 为空时清除节点的证书，
+// 表示 agent 不再使用 TLS。
+func (s *Service) issueCertificate(node *models.Node, csrPEM []byte) (*pki.Certificate, error) {
+	if len(csrPEM) == 0 {
+		node.CertificateSerial = ""
+		node.CertificateExpiresAt = nil
+		return nil, nil
+	}
+	if s.ca == nil {
+		return nil, ErrCertificatesDisabled
+	}
+	cert, err := s.ca.SignNodeCSR(csrPEM, node.ID, s.certTTL, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	node.CertificateSerial = cert.Serial
+	node.CertificateExpiresAt = &cert.NotAfter
+	return cert, nil
+}
+
+// issueCredentials 生成新的节点凭据和 agent 密钥并写入 node（尚未保存），返回它们的明文。
+func (s *Service) issueCredentials(node *models.Node) (Credentials, error) {
+	var creds Credentials
+	var err error
+	if creds.NodeToken, err = generateSecret(); err != nil {
+		return Credentials{}, err
+	}
+	node.CredentialHash = hashSecret(creds.NodeToken)
+
+	node.AgentSecret = ""
+	if s.box != nil {
+		if creds.AgentSecret, err = generateSecret(); err != nil {
+			return Credentials{}, err
+		}
+		if node.AgentSecret, err = s.box.Seal([]byte(creds.AgentSecret), secrets.AgentSecretAD(node.ID)); err != nil {
+			return Credentials{}, fmt.Errorf("failed to encrypt agent secret: %w", err)
+		}
+	}
+
+	now := time.Now()
+	node.CredentialsIssuedAt = &now
+	return creds, nil
+}
+
+// AuthenticateNode 校验节点提交的凭据，成功时返回该节点。
+func (s *Service) AuthenticateNode(ref string, credential string) (*models.Node, error) {
+	return authenticateNode(s.store, ref, credential)
+}
+
+func authenticateNode(store Store, ref string, credential string) (*models.Node, error) {
+	node, err := ResolveNode(store, ref)
+	if err != nil {
+		return nil, err
+	}
+	if !secretMatchesHash(credential, node.CredentialHash) {
+		return nil, ErrInvalidNodeCredential
+	}
+	return node, nil
+}
+
+// CreateBootstrapToken 签发一个新的节点注册令牌。
+// 令牌必须是一次性的或带有有效期，返回的明文令牌只出现这一次。
+func (s *Service) CreateBootstrapToken(description, createdBy string, singleUse bool, ttl time.Duration) (string, *models.BootstrapToken, error) {
+	if !singleUse && ttl <= 0 {
+		return "", nil, errors.New("bootstrap token must be single-use or have an expiry")
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	token := &models.BootstrapToken{
+		TokenHash:   hashSecret(secret),
+		Description: description,
+		SingleUse:   singleUse,
+		CreatedBy:   createdBy,
+		CreatedAt:   now,
+	}
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := s.store.CreateBootstrapToken(token); err != nil {
+		return "", nil, err
+	}
+	return secret, token, nil
+}
+
+// ListBootstrapTokens 返回所有注册令牌，不包含令牌明文。
+func (s *Service) ListBootstrapTokens() ([]*models.BootstrapToken, error) {
+	return s.store.ListBootstrapTokens()
+}
+
+// RevokeBootstrapToken 删除注册令牌，使其无法再被使用。
+func (s *Service) RevokeBootstrapToken(id int64) error {
+	return s.store.DeleteBootstrapToken(id)
+}
+
+// ResolveNode 按节点引用查找节点。
+// 引用通常是节点的 UUID；为兼容仍以整数 ID 运行的旧 agent，纯数字引用会按旧 ID 查找。
+// 节点不存在（包括引用格式无效）时返回的错误包装了 ErrNodeNotFound。
+func ResolveNode(store Store, ref string) (*models.Node, error) {
+	if legacyID, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		return store.GetNodeByLegacyID(legacyID)
+	}
+	if _, err := uuid.Parse(ref); err != nil {
+		return nil, fmt.Errorf("%w: invalid node ID %q", ErrNodeNotFound, ref)
+	}
+	return store.GetNode(ref)
+}
+
+// IsValidNodeRef 判断 ref 是否为节点 UUID 或旧的整数 ID。
+func IsValidNodeRef(ref string) bool {
+	if _, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		return true
+	}
+	_, err := uuid.Parse(ref)
+	return err == nil
+}
+
+// GetNode 按 UUID 或旧的整数 ID 检索节点。
+func (s *Service) GetNode(ref string) (*models.Node, error) {
+	return ResolveNode(s.store, ref)
+}
+
+// ListNodes 返回所有已注册的节点。
+func (s *Service) ListNodes() ([]*models.Node, error) {
+	return s.store.ListNodes()
+}
+
+// UpdateNodeMetadata 更新节点的主机名、标签和/或直连地址。
+// 传入 nil 的字段保持不变；labels 非 nil 时会整体替换现有标签；address 为空字符串时清除直连地址。
+// 设置的地址与 agent 请求的地址相同时视为批准了该请求。
+func (s *Service) UpdateNodeMetadata(id string, hostname *string, labels map[string]string, address *string) (*models.Node, error) {
+	node, err := s.store.GetNode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if hostname != nil {
+		node.Hostname = *hostname
+	}
+	if labels != nil {
+		node.Labels = labels
+	}
+	if address != nil {
+		node.Address = *address
+		requestAddress(node, node.RequestedAddress)
+	}
+
+	if err := s.store.UpdateNode(node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// DeleteNode 下线并删除一个节点。
+func (s *Service) DeleteNode(id string) error {
+	return s.store.DeleteNode(id)
+}
+
+// MergeNode 将重复节点 duplicateID 合并到 targetID 并删除重复节点。
+// 目标节点已有的标签优先；目标节点没有机器标识时继承重复节点的标识。
+// 目标节点尚未签发凭据（清单声明后未注册）或重复节点声称的正是目标节点的机器标识时（见 RegisterNode），
+// 合并即表示管理员确认了这次注册：目标节点接管重复节点的节点凭据，旧凭据失效；
+// agent 之后出示该凭据重新注册即可取回目标节点并获得新的密钥与证书。
+// 引用重复节点的 GpuClaim 在同一事务中迁移到目标节点。
+func (s *Service) MergeNode(targetID, duplicateID string) (*models.Node, error) {
+	if targetID == duplicateID {
+		return nil, errors.New("cannot merge a node into itself")
+	}
+
+	target, err := s.store.GetNode(targetID)
+	if err != nil {
+		return nil, err
+	}
+	duplicate, err := s.store.GetNode(duplicateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Labels == nil {
+		target.Labels = make(map[string]string)
+	}
+	for key, value := range duplicate.Labels {
+		if _, exists := target.Labels[key]; !exists {
+			target.Labels[key] = value
+		}
+	}
+	if target.MachineID == "" {
+		target.MachineID = duplicate.MachineID
+	}
+	if target.CredentialHash == "" || (duplicate.ClaimedMachineID != "" && duplicate.ClaimedMachineID == target.MachineID) {
+		target.CredentialHash = duplicate.CredentialHash
+		target.CredentialsIssuedAt = duplicate.CredentialsIssuedAt
+	}
+
+	if err := s.store.MergeNode(target, duplicateID); err != nil {
+		return nil, fmt.Errorf("failed to merge node %s into %s: %w", duplicateID, targetID, err)
+	}
+	return target, nil
+}
+
+// StaleNodes 返回处于 Offline 或 Registering 状态且超过 olderThan 未出现的节点。
+// 由静态清单声明的节点不会被当作过期节点，需由管理员显式删除。
+func (s *Service) StaleNodes(olderThan time.Duration) ([]*models.Node, error) {
+	nodes, err := s.store.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	var stale []*models.Node
+	for _, node := range nodes {
+		if node.Status != models.NodeStatusOffline && node.Status != models.NodeStatusRegistering {
+			continue
+		}
+		if node.DeclaredAt != nil {
+			continue
+		}
+		if node.LastSeen.Before(cutoff) {
+			stale = append(stale, node)
+		}
+	}
+	return stale, nil
 }
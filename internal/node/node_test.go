@@ -0,0 +1,410 @@
+package node
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+	"utopia-server/internal/models"
+	"utopia-server/internal/pki"
+	"utopia-server/internal/secrets"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterNode_SingleUseToken(t *testing.T) {
+	service := NewService(NewMemStore(), nil, nil, 0)
+
+	token, _, err := service.CreateBootstrapToken("rack-1", "admin", true, 0)
+	require.NoError(t, err)
+
+	node, credential, _, err := service.RegisterNode(token, Registration{Hostname: "gpu-node-01"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, credential.NodeToken)
+	assert.NotEqual(t, credential, node.CredentialHash, "credential must not be stored in plain text")
+
+	_, _, _, err = service.RegisterNode(token, Registration{Hostname: "gpu-node-02"})
+	assert.ErrorIs(t, err, ErrInvalidBootstrapToken)
+}
+
+// failingCreateStore fails every node creation.
+type failingCreateStore struct {
+	Store
+}
+
+func (s failingCreateStore) CreateNode(*models.Node) error {
+	return errors.New("database unavailable")
+}
+
+func TestRegisterNode_ReleasesTokenOnFailure(t *testing.T) {
+	service := NewService(failingCreateStore{NewMemStore()}, nil, nil, 0)
+
+	token, info, err := service.CreateBootstrapToken("rack-1", "admin", true, 0)
+	require.NoError(t, err)
+
+	_, _, _, err = service.RegisterNode(token, Registration{Hostname: "gpu-node-01"})
+	require.Error(t, err)
+	assert.Zero(t, info.UseCount, "a failed registration must not use up a single-use token")
+}
+
+func TestRegisterNode_ExpiredToken(t *testing.T) {
+	service := NewService(NewMemStore(), nil, nil, 0)
+
+	token, info, err := service.CreateBootstrapToken("", "admin", false, time.Hour)
+	require.NoError(t, err)
+	expired := time.Now().Add(-time.Minute)
+	info.ExpiresAt = &expired
+
+	_, _, _, err = service.RegisterNode(token, Registration{Hostname: "gpu-node-01"})
+	assert.ErrorIs(t, err, ErrInvalidBootstrapToken)
+}
+
+func TestRegisterNode_UnknownToken(t *testing.T) {
+	service := NewService(NewMemStore(), nil, nil, 0)
+
+	_, _, _, err := service.RegisterNode("not-a-token", Registration{Hostname: "gpu-node-01"})
+	assert.ErrorIs(t, err, ErrInvalidBootstrapToken)
+}
+
+func TestCreateBootstrapToken_RequiresLimit(t *testing.T) {
+	service := NewService(NewMemStore(), nil, nil, 0)
+
+	_, _, err := service.CreateBootstrapToken("", "admin", false, 0)
+	assert.Error(t, err)
+}
+
+func TestAuthenticateNode(t *testing.T) {
+	service := NewService(NewMemStore(), nil, nil, 0)
+
+	token, _, err := service.CreateBootstrapToken("", "admin", true, 0)
+	require.NoError(t, err)
+	node, credential, _, err := service.RegisterNode(token, Registration{Hostname: "gpu-node-01"})
+	require.NoError(t, err)
+
+	authenticated, err := service.AuthenticateNode(node.ID, credential.NodeToken)
+	require.NoError(t, err)
+	assert.Equal(t, node.ID, authenticated.ID)
+
+	_, err = service.AuthenticateNode(node.ID, "wrong")
+	assert.ErrorIs(t, err, ErrInvalidNodeCredential)
+}
+
+func TestRotateCredentials(t *testing.T) {
+	box, err := secrets.NewBox(make([]byte, secrets.KeySize))
+	require.NoError(t, err)
+	service := NewService(NewMemStore(), box, nil, 0)
+
+	token, _, err := service.CreateBootstrapToken("", "admin", true, 0)
+	require.NoError(t, err)
+	node, old, _, err := service.RegisterNode(token, Registration{Hostname: "gpu-node-01"})
+	require.NoError(t, err)
+	require.NotEmpty(t, old.AgentSecret)
+	require.NotNil(t, node.CredentialsIssuedAt)
+	assert.NotContains(t, node.AgentSecret, old.AgentSecret, "agent secret must be stored encrypted")
+
+	rotated, creds, err := service.RotateCredentials(node.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, old.NodeToken, creds.NodeToken)
+	assert.NotEqual(t, old.AgentSecret, creds.AgentSecret)
+
+	opened, err := box.Open(rotated.AgentSecret, secrets.AgentSecretAD(node.ID))
+	require.NoError(t, err)
+	assert.Equal(t, creds.AgentSecret, string(opened))
+
+	_, err = service.AuthenticateNode(node.ID, old.NodeToken)
+	assert.ErrorIs(t, err, ErrInvalidNodeCredential)
+	_, err = service.AuthenticateNode(node.ID, creds.NodeToken)
+	assert.NoError(t, err)
+}
+
+func newTestCSR(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestRegisterNode_IssuesCertificate(t *testing.T) {
+	ca, err := pki.GenerateCA("test CA")
+	require.NoError(t, err)
+	service := NewService(NewMemStore(), nil, ca, time.Hour)
+
+	token, _, err := service.CreateBootstrapToken("", "admin", false, time.Hour)
+	require.NoError(t, err)
+
+	_, _, _, err = service.RegisterNode(token, Registration{Hostname: "gpu-node-01", CSR: []byte("garbage")})
+	assert.ErrorIs(t, err, pki.ErrInvalidCSR)
+
+	node, creds, _, err := service.RegisterNode(token, Registration{Hostname: "gpu-node-01", MachineID: "m-1", Address: "10.0.0.5:8080", CSR: newTestCSR(t)})
+	require.NoError(t, err)
+	require.NotNil(t, creds.Certificate)
+	assert.Equal(t, creds.Certificate.Serial, node.CertificateSerial)
+	address := "10.0.0.5:8080"
+	node, err = service.UpdateNodeMetadata(node.ID, nil, nil, &address)
+	require.NoError(t, err)
+	assert.Equal(t, "https://10.0.0.5:8080", node.Endpoint())
+
+	renewed, cert, err := service.RenewCertificate(node.ID, newTestCSR(t))
+	require.NoError(t, err)
+	assert.NotEqual(t, creds.Certificate.Serial, cert.Serial)
+	assert.Equal(t, cert.Serial, renewed.CertificateSerial)
+
+	// 重新注册时没有提交 CSR，说明 agent 不再使用 TLS。
+	node, _, _, err = service.RegisterNode(token, Registration{Hostname: "gpu-node-01", MachineID: "m-1", Address: "10.0.0.5:8080", NodeToken: creds.NodeToken})
+	require.NoError(t, err)
+	assert.False(t, node.HasCertificate())
+	assert.Equal(t, "http://10.0.0.5:8080", node.Endpoint())
+}
+
+func TestRegisterNode_CertificatesDisabled(t *testing.T) {
+	service := NewService(NewMemStore(), nil, nil, 0)
+
+	token, _, err := service.CreateBootstrapToken("", "admin", true, 0)
+	require.NoError(t, err)
+	_, _, _, err = service.RegisterNode(token, Registration{Hostname: "gpu-node-01", CSR: newTestCSR(t)})
+	assert.ErrorIs(t, err, ErrCertificatesDisabled)
+
+	// 被拒绝的请求不消耗一次性令牌。
+	_, _, _, err = service.RegisterNode(token, Registration{Hostname: "gpu-node-01"})
+	assert.NoError(t, err)
+}
+
+func TestRegisterNode_ReusesNodeWithSameMachineID(t *testing.T) {
+	service := NewService(NewMemStore(), nil, nil, 0)
+
+	token, _, err := service.CreateBootstrapToken("", "admin", false, time.Hour)
+	require.NoError(t, err)
+
+	first, firstCredential, created, err := service.RegisterNode(token, Registration{Hostname: "gpu-node-01", MachineID: "machine-a"})
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	second, secondCredential, created, err := service.RegisterNode(token, Registration{Hostname: "gpu-node-01-renamed", MachineID: "machine-a", NodeToken: firstCredential.NodeToken})
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, "gpu-node-01-renamed", second.Hostname)
+
+	// The previous credential is rotated out.
+	_, err = service.AuthenticateNode(first.ID, firstCredential.NodeToken)
+	assert.ErrorIs(t, err, ErrInvalidNodeCredential)
+	_, err = service.AuthenticateNode(first.ID, secondCredential.NodeToken)
+	assert.NoError(t, err)
+
+	nodes, err := service.ListNodes()
+	require.NoError(t, err)
+	assert.Len(t, nodes, 1)
+}
+
+func TestRegisterNode_MachineIDWithoutCredentialNeedsMerge(t *testing.T) {
+	service := NewService(NewMemStore(), nil, nil, 0)
+
+	token, _, err := service.CreateBootstrapToken("", "admin", false, time.Hour)
+	require.NoError(t, err)
+
+	original, originalCredential, _, err := service.RegisterNode(token, Registration{Hostname: "gpu-node-01", MachineID: "machine-a"})
+	require.NoError(t, err)
+
+	// 只知道机器标识的注册不能接管已有节点。
+	pending, pendingCredential, created, err := service.RegisterNode(token, Registration{Hostname: "evil", MachineID: "machine-a", NodeToken: "guess"})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.NotEqual(t, original.ID, pending.ID)
+	assert.Empty(t, pending.MachineID)
+	assert.Equal(t, "machine-a", pending.ClaimedMachineID)
+
+	current, err := service.GetNode(original.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "gpu-node-01", current.Hostname)
+	_, err = service.AuthenticateNode(original.ID, originalCredential.NodeToken)
+	assert.NoError(t, err)
+
+	// 管理员确认后合并，原节点接管新注册的凭据。
+	merged, err := service.MergeNode(original.ID, pending.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "machine-a", merged.MachineID)
+	_, err = service.AuthenticateNode(original.ID, originalCredential.NodeToken)
+	assert.ErrorIs(t, err, ErrInvalidNodeCredential)
+	_, err = service.AuthenticateNode(original.ID, pendingCredential.NodeToken)
+	assert.NoError(t, err)
+
+	reused, _, created, err := service.RegisterNode(token, Registration{Hostname: "gpu-node-01", MachineID: "machine-a", NodeToken: pendingCredential.NodeToken})
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, original.ID, reused.ID)
+}
+
+func TestRegisterNode_AddressRequiresApproval(t *testing.T) {
+	service := NewService(NewMemStore(), nil, nil, 0)
+
+	token, _, err := service.CreateBootstrapToken("", "admin", false, time.Hour)
+	require.NoError(t, err)
+
+	node, creds, _, err := service.RegisterNode(token, Registration{Hostname: "gpu-node-01", MachineID: "machine-a", Address: "10.0.0.5:8080"})
+	require.NoError(t, err)
+	assert.False(t, node.IsDirect(), "the agent-chosen address must not take effect")
+	assert.Equal(t, "10.0.0.5:8080", node.RequestedAddress)
+
+	address := "10.0.0.5:8080"
+	node, err = service.UpdateNodeMetadata(node.ID, nil, nil, &address)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.5:8080", node.Address)
+	assert.Empty(t, node.RequestedAddress)
+
+	// 重新注册不会修改已批准的地址。
+	node, _, _, err = service.RegisterNode(token, Registration{Hostname: "gpu-node-01", MachineID: "machine-a", Address: "169.254.169.254:80", NodeToken: creds.NodeToken})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.5:8080", node.Address)
+	assert.Equal(t, "169.254.169.254:80", node.RequestedAddress)
+}
+
+func TestMachineIdentity(t *testing.T) {
+	assert.Equal(t, "abc", MachineIdentity(" abc\n", []string{"GPU-1"}))
+	assert.Equal(t, MachineIdentity("", []string{"GPU-1", "GPU-2"}), MachineIdentity("", []string{"GPU-2", "GPU-1"}))
+	assert.Empty(t, MachineIdentity("", nil))
+}
+
+func TestMergeNode(t *testing.T) {
+	store := NewMemStore()
+	service := NewService(store, nil, nil, 0)
+
+	target := &models.Node{Hostname: "gpu-node-01", Labels: map[string]string{"zone": "a"}}
+	duplicate := &models.Node{Hostname: "gpu-node-01", MachineID: "machine-a", Labels: map[string]string{"zone": "b", "rack": "3"}}
+	require.NoError(t, store.CreateNode(target))
+	require.NoError(t, store.CreateNode(duplicate))
+
+	merged, err := service.MergeNode(target.ID, duplicate.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"zone": "a", "rack": "3"}, merged.Labels)
+	assert.Equal(t, "machine-a", merged.MachineID)
+
+	_, err = store.GetNode(duplicate.ID)
+	assert.Error(t, err)
+}
+
+func TestResolveNode_LegacyID(t *testing.T) {
+	store := NewMemStore()
+
+	legacy := &models.Node{Hostname: "gpu-node-01", LegacyID: 4}
+	require.NoError(t, store.CreateNode(legacy))
+
+	byLegacyID, err := ResolveNode(store, "4")
+	require.NoError(t, err)
+	assert.Equal(t, legacy.ID, byLegacyID.ID)
+
+	byUUID, err := ResolveNode(store, legacy.ID)
+	require.NoError(t, err)
+	assert.Equal(t, legacy.ID, byUUID.ID)
+
+	_, err = ResolveNode(store, "control_4")
+	assert.Error(t, err)
+}
+
+func TestParseInventory(t *testing.T) {
+	inventory, err := ParseInventory([]byte(`
+nodes:
+  - hostname: gpu-node-01
+    address: 10.0.0.5:8080
+    expected_gpus: 8
+    labels:
+      rack: a1
+`))
+	require.NoError(t, err)
+	require.Len(t, inventory.Nodes, 1)
+	assert.Equal(t, "10.0.0.5:8080", inventory.Nodes[0].Address)
+	assert.Equal(t, 8, inventory.Nodes[0].ExpectedGpus)
+	assert.Equal(t, "a1", inventory.Nodes[0].Labels["rack"])
+
+	_, err = ParseInventory([]byte("nodes:\n  - hostname: a\n  - hostname: a\n"))
+	assert.Error(t, err, "duplicate hostnames are rejected")
+	_, err = ParseInventory([]byte("nodes:\n  - hostname: a\n    address: 10.0.0.5\n"))
+	assert.Error(t, err, "address without a port is rejected")
+}
+
+func TestImportInventory(t *testing.T) {
+	store := NewMemStore()
+	service := NewService(store, nil, nil, 0)
+	existing := &models.Node{Hostname: "gpu-node-02", Status: models.NodeStatusOnline, Labels: map[string]string{"zone": "b"}}
+	require.NoError(t, store.CreateNode(existing))
+	require.NoError(t, store.CreateNode(&models.Node{Hostname: "dup", Status: models.NodeStatusOffline}))
+	require.NoError(t, store.CreateNode(&models.Node{Hostname: "dup", Status: models.NodeStatusOffline}))
+
+	inventory := &Inventory{Nodes: []InventoryNode{
+		{Hostname: "gpu-node-01", ExpectedGpus: 8, Labels: map[string]string{"rack": "a1"}},
+		{Hostname: "gpu-node-02", ExpectedGpus: 4},
+		{Hostname: "dup"},
+	}}
+	result, err := service.ImportInventory(inventory)
+	require.NoError(t, err)
+	require.Len(t, result.Created, 1)
+	assert.Equal(t, []string{existing.ID}, result.Updated)
+	assert.Contains(t, result.Skipped, "dup")
+
+	declared, err := store.GetNode(result.Created[0])
+	require.NoError(t, err)
+	assert.Equal(t, models.NodeStatusRegistering, declared.Status)
+	assert.Equal(t, 8, declared.ExpectedGpus)
+	assert.Equal(t, map[string]string{"zone": "b"}, existing.Labels, "labels are kept when the inventory omits them")
+
+	// Re-importing is idempotent.
+	result, err = service.ImportInventory(inventory)
+	require.NoError(t, err)
+	assert.Empty(t, result.Created)
+
+	// gpu-node-01 never came online; gpu-node-02 reports the wrong GPU count.
+	existing.Gpus = []models.GpuInfo{{ID: 0}}
+	report, err := service.InventoryReport()
+	require.NoError(t, err)
+	require.Len(t, report, 2)
+	problems := map[string][]string{}
+	for _, status := range report {
+		problems[status.Node.Hostname] = status.Problems
+	}
+	assert.Equal(t, []string{InventoryNeverOnline}, problems["gpu-node-01"])
+	assert.Equal(t, []string{InventoryGpuMismatch}, problems["gpu-node-02"])
+
+	// A hostname match alone does not take over the declared node.
+	token, _, err := service.CreateBootstrapToken("", "admin", false, time.Hour)
+	require.NoError(t, err)
+	other, otherCredential, created, err := service.RegisterNode(token, Registration{Hostname: "gpu-node-01", MachineID: "machine-b"})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.NotEqual(t, declared.ID, other.ID)
+
+	// Merging confirms it: the declared node takes the new node's identity and credential.
+	merged, err := service.MergeNode(declared.ID, other.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "machine-b", merged.MachineID)
+	_, err = service.AuthenticateNode(declared.ID, otherCredential.NodeToken)
+	assert.NoError(t, err)
+}
+
+func TestRegisterNode_TakesOverDeclaredMachineID(t *testing.T) {
+	store := NewMemStore()
+	service := NewService(store, nil, nil, 0)
+	result, err := service.ImportInventory(&Inventory{Nodes: []InventoryNode{{Hostname: "gpu-node-01", MachineID: "machine-a"}}})
+	require.NoError(t, err)
+	require.Len(t, result.Created, 1)
+
+	token, _, err := service.CreateBootstrapToken("", "admin", false, time.Hour)
+	require.NoError(t, err)
+	node, credential, created, err := service.RegisterNode(token, Registration{Hostname: "gpu-node-01", MachineID: "machine-a"})
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, result.Created[0], node.ID)
+
+	// Once registered, the machine ID alone is no longer enough.
+	other, _, created, err := service.RegisterNode(token, Registration{Hostname: "gpu-node-01", MachineID: "machine-a"})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.NotEqual(t, node.ID, other.ID)
+	_, err = service.AuthenticateNode(node.ID, credential.NodeToken)
+	assert.NoError(t, err)
+}
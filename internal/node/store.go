@@ -1,32 +1,95 @@
 package node
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"utopia-server/internal/models"
+
+	"github.com/google/uuid"
 )
 
+// ErrNodeNotFound 表示节点不存在。
+var ErrNodeNotFound = errors.New("node not found")
+
 // Store 定义了节点数据的持久化接口。
 type Store interface {
 	CreateNode(node *models.Node) error
-	GetNode(id int64) (*models.Node, error)
+	GetNode(id string) (*models.Node, error)
+	GetNodeByLegacyID(legacyID int64) (*models.Node, error)
+	GetNodeByMachineID(machineID string) (*models.Node, error)
 	ListNodes() ([]*models.Node, error)
 	UpdateNode(node *models.Node) error
+	// UpdateNodeHealth 批量写回健康检查结果，只更新状态、控制端口、最近在线时间、最近心跳时间、
+	// GPU 信息、软硬件清单、系统指标和镜像缓存。写入规则见 HealthUpdate。
+	UpdateNodeHealth(updates []HealthUpdate) error
+	DeleteNode(id string) error
+	// MergeNode 原子地将引用重复节点的 GpuClaim 与指标历史迁移到 target、删除重复节点并保存 target。
+	MergeNode(target *models.Node, duplicateID string) error
+
+	CreateBootstrapToken(token *models.BootstrapToken) error
+	ListBootstrapTokens() ([]*models.BootstrapToken, error)
+	ConsumeBootstrapToken(tokenHash string, now time.Time) (*models.BootstrapToken, error)
+	// ReleaseBootstrapToken 撤销一次使用，用于使用令牌的注册最终失败时归还令牌。
+	ReleaseBootstrapToken(tokenHash string) error
+	DeleteBootstrapToken(id int64) error
+
+	// ListGpuHealth 返回节点各 GPU 的健康状态，按 GPU 序号排序；nodeID 为空时返回所有节点的。
+	ListGpuHealth(nodeID string) ([]*models.GpuHealth, error)
+	// SaveGpuHealth 插入或更新一批 GPU 健康状态。状态按节点与 GPU UUID 记录，没有 UUID 时按序号；
+	// 有 UUID 的状态会替换同一序号上按序号记录的旧状态。
+	SaveGpuHealth(states []*models.GpuHealth) error
+}
+
+// HealthUpdate 是一次健康检查或隧道状态变化的写回。
+//
+// Node 是检查开始时读到的节点快照，检查期间其他组件（发现服务、管理员、心跳）可能已经修改了数据库中的记录，
+// 因此写回分两部分，都不会用旧快照覆盖更新的数据：
+//   - Metrics 为 true 时写回采集到的指标（最近在线时间、最近心跳时间、GPU、软硬件清单、系统指标、镜像），
+//     数据库中的最近在线时间比快照更新时跳过；
+//   - 状态或控制端口相对 ExpectedStatus、ExpectedPort 发生变化时，只有数据库中的值仍等于这两个期望值才写入。
+type HealthUpdate struct {
+	Node           *models.Node
+	Metrics        bool
+	ExpectedStatus string
+	ExpectedPort   int
+}
+
+// newHealthUpdate 以节点当前的状态和控制端口作为期望值创建写回，调用方随后修改 node。
+func newHealthUpdate(node *models.Node) HealthUpdate {
+	return HealthUpdate{Node: node, ExpectedStatus: node.Status, ExpectedPort: node.ControlPort}
+}
+
+// transition 判断写回是否改变了节点的状态或控制端口。
+func (u HealthUpdate) transition() bool {
+	return u.Node.Status != u.ExpectedStatus || u.Node.ControlPort != u.ExpectedPort
 }
 
 // memStore 是 Store 接口的一个内存实现，主要用于测试。
 type memStore struct {
-	mu     sync.RWMutex
-	nodes  map[int64]*models.Node
-	nextID int64
+	mu          sync.RWMutex
+	nodes       map[string]*models.Node
+	tokens      map[int64]*models.BootstrapToken
+	nextTokenID int64
+	gpuHealth   map[gpuKey]*models.GpuHealth
+}
+
+// gpuKey 标识一个节点上的一块 GPU，gpu 为 gpuHealthKey 的结果。
+type gpuKey struct {
+	nodeID string
+	gpu    string
 }
 
 // NewMemStore 创建一个新的 memStore 实例。
 func NewMemStore() Store {
 	return &memStore{
-		nodes:  make(map[int64]*models.Node),
-		nextID: 1,
+		nodes:       make(map[string]*models.Node),
+		tokens:      make(map[int64]*models.BootstrapToken),
+		gpuHealth:   make(map[gpuKey]*models.GpuHealth),
+		nextTokenID: 1,
 	}
 }
 
@@ -35,24 +98,57 @@ func (s *memStore) CreateNode(node *models.Node) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	node.ID = s.nextID
-	s.nextID++
+	for _, existing := range s.nodes {
+		if node.MachineID != "" && existing.MachineID == node.MachineID {
+			return fmt.Errorf("node with machine id %s already exists", node.MachineID)
+		}
+	}
+
+	if node.ID == "" {
+		node.ID = uuid.NewString()
+	}
 	s.nodes[node.ID] = node
 	return nil
 }
 
 // GetNode 从内存中检索一个节点。
-func (s *memStore) GetNode(id int64) (*models.Node, error) {
+func (s *memStore) GetNode(id string) (*models.Node, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	node, exists := s.nodes[id]
 	if !exists {
-		return nil, fmt.Errorf("node with id %d not found", id)
+		return nil, fmt.Errorf("%w: id %s", ErrNodeNotFound, id)
 	}
 	return node, nil
 }
 
+// GetNodeByLegacyID 按旧的整数 ID 从内存中检索一个节点。
+func (s *memStore) GetNodeByLegacyID(legacyID int64) (*models.Node, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, node := range s.nodes {
+		if legacyID != 0 && node.LegacyID == legacyID {
+			return node, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: legacy id %d", ErrNodeNotFound, legacyID)
+}
+
+// GetNodeByMachineID 按机器标识从内存中检索一个节点。
+func (s *memStore) GetNodeByMachineID(machineID string) (*models.Node, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, node := range s.nodes {
+		if machineID != "" && node.MachineID == machineID {
+			return node, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: machine id %s", ErrNodeNotFound, machineID)
+}
+
 // ListNodes returns all nodes from the store.
 func (s *memStore) ListNodes() ([]*models.Node, error) {
 	s.mu.RLock()
@@ -65,14 +161,182 @@ func (s *memStore) ListNodes() ([]*models.Node, error) {
 	return nodes, nil
 }
 
-// UpdateNode updates a node in the store.
+// UpdateNode updates a node in the store. Like the MySQL store it keeps the
+// health fields, which are only written by UpdateNodeHealth.
 func (s *memStore) UpdateNode(node *models.Node) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.nodes[node.ID]; !exists {
-		return fmt.Errorf("node with id %d not found", node.ID)
+	existing, exists := s.nodes[node.ID]
+	if !exists {
+		return fmt.Errorf("%w: id %s", ErrNodeNotFound, node.ID)
+	}
+	status, controlPort, lastSeen, gpus := existing.Status, existing.ControlPort, existing.LastSeen, existing.Gpus
+	*existing = *node
+	existing.Status, existing.ControlPort, existing.LastSeen, existing.Gpus = status, controlPort, lastSeen, gpus
+	return nil
+}
+
+// UpdateNodeHealth 批量更新内存中节点的健康检查字段。
+func (s *memStore) UpdateNodeHealth(updates []HealthUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, update := range updates {
+		node := update.Node
+		existing, exists := s.nodes[node.ID]
+		if !exists {
+			continue // 节点可能已在检查期间被删除
+		}
+		if update.Metrics && !existing.LastSeen.After(node.LastSeen) {
+			existing.LastSeen = node.LastSeen
+			existing.LastHeartbeat = node.LastHeartbeat
+			existing.Gpus = node.Gpus
+			existing.Inventory = node.Inventory
+			existing.System = node.System
+			existing.Images = node.Images
+		}
+		if update.transition() && existing.Status == update.ExpectedStatus && existing.ControlPort == update.ExpectedPort {
+			existing.Status = node.Status
+			existing.ControlPort = node.ControlPort
+			if node.LastSeen.After(existing.LastSeen) {
+				existing.LastSeen = node.LastSeen
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteNode removes a node from the store.
+func (s *memStore) DeleteNode(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.nodes[id]; !exists {
+		return fmt.Errorf("%w: id %s", ErrNodeNotFound, id)
+	}
+	delete(s.nodes, id)
+	for key := range s.gpuHealth {
+		if key.nodeID == id {
+			delete(s.gpuHealth, key)
+		}
+	}
+	return nil
+}
+
+// MergeNode 删除重复节点并保存目标节点。内存实现不保存 GpuClaim，因此没有需要迁移的引用。
+func (s *memStore) MergeNode(target *models.Node, duplicateID string) error {
+	if err := s.DeleteNode(duplicateID); err != nil {
+		return err
+	}
+	return s.UpdateNode(target)
+}
+
+// CreateBootstrapToken 将一个新的注册令牌存储在内存中。
+func (s *memStore) CreateBootstrapToken(token *models.BootstrapToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token.ID = s.nextTokenID
+	s.nextTokenID++
+	s.tokens[token.ID] = token
+	return nil
+}
+
+// ListBootstrapTokens returns all bootstrap tokens ordered by ID.
+func (s *memStore) ListBootstrapTokens() ([]*models.BootstrapToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := make([]*models.BootstrapToken, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+// ConsumeBootstrapToken records a use of a valid token.
+func (s *memStore) ConsumeBootstrapToken(tokenHash string, now time.Time) (*models.BootstrapToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.tokens {
+		if token.TokenHash != tokenHash {
+			continue
+		}
+		if token.ExpiresAt != nil && !token.ExpiresAt.After(now) {
+			return nil, ErrInvalidBootstrapToken
+		}
+		if token.SingleUse && token.UseCount > 0 {
+			return nil, ErrInvalidBootstrapToken
+		}
+		token.UseCount++
+		usedAt := now
+		token.LastUsedAt = &usedAt
+		return token, nil
+	}
+	return nil, ErrInvalidBootstrapToken
+}
+
+// ReleaseBootstrapToken undoes one recorded use of a token.
+func (s *memStore) ReleaseBootstrapToken(tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.tokens {
+		if token.TokenHash == tokenHash && token.UseCount > 0 {
+			token.UseCount--
+		}
+	}
+	return nil
+}
+
+// DeleteBootstrapToken removes a bootstrap token from the store.
+func (s *memStore) DeleteBootstrapToken(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.tokens[id]; !exists {
+		return fmt.Errorf("bootstrap token with id %d not found", id)
+	}
+	delete(s.tokens, id)
+	return nil
+}
+
+// ListGpuHealth 从内存中返回 GPU 健康状态。
+func (s *memStore) ListGpuHealth(nodeID string) ([]*models.GpuHealth, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	states := make([]*models.GpuHealth, 0)
+	for key, state := range s.gpuHealth {
+		if nodeID == "" || key.nodeID == nodeID {
+			states = append(states, state)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].NodeID != states[j].NodeID {
+			return states[i].NodeID < states[j].NodeID
+		}
+		if states[i].GpuIndex != states[j].GpuIndex {
+			return states[i].GpuIndex < states[j].GpuIndex
+		}
+		return states[i].GpuUUID < states[j].GpuUUID
+	})
+	return states, nil
+}
+
+// SaveGpuHealth 将 GPU 健康状态保存在内存中。
+func (s *memStore) SaveGpuHealth(states []*models.GpuHealth) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, state := range states {
+		if state.GpuUUID != "" {
+			delete(s.gpuHealth, gpuKey{state.NodeID, gpuHealthKey("", state.GpuIndex)})
+		}
+		s.gpuHealth[gpuKey{state.NodeID, gpuHealthKey(state.GpuUUID, state.GpuIndex)}] = state
 	}
-	s.nodes[node.ID] = node
 	return nil
 }
@@ -0,0 +1,168 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+	"utopia-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCSR 生成一个私钥和对应的 PEM CSR，模拟 agent 的注册请求。
+func newCSR(t *testing.T, commonName string) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: commonName}}, key)
+	require.NoError(t, err)
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+// newAgentServer 启动一个使用节点证书、要求客户端证书的 TLS 服务器，返回访问它的节点。
+func newAgentServer(t *testing.T, ca *CA, nodeID string) *models.Node {
+	t.Helper()
+	key, csr := newCSR(t, "ignored")
+	cert, err := ca.SignNodeCSR(csr, nodeID, time.Hour, time.Now())
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	pair, err := tls.X509KeyPair(cert.PEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    ca.Pool(),
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return &models.Node{ID: nodeID, Address: server.Listener.Addr().String(), CertificateSerial: cert.Serial}
+}
+
+func TestLoadOrCreateCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+
+	created, err := LoadOrCreateCA(certFile, keyFile)
+	require.NoError(t, err)
+	loaded, err := LoadOrCreateCA(certFile, keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, created.CertPEM(), loaded.CertPEM())
+
+	_, err = LoadOrCreateCA(certFile, filepath.Join(dir, "missing.key"))
+	assert.Error(t, err, "a CA certificate without its key must not be replaced")
+}
+
+func TestSignNodeCSR_IdentityComesFromNodeID(t *testing.T) {
+	ca, err := GenerateCA("test CA")
+	require.NoError(t, err)
+	_, csr := newCSR(t, "other-node")
+
+	now := time.Now()
+	cert, err := ca.SignNodeCSR(csr, "node-1", 30*time.Hour, now)
+	require.NoError(t, err)
+
+	block, _ := pem.Decode(cert.PEM)
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, "node-1", parsed.Subject.CommonName)
+	assert.Equal(t, []string{NodeDNSName("node-1")}, parsed.DNSNames)
+	assert.Equal(t, parsed.SerialNumber.Text(16), cert.Serial)
+	assert.WithinDuration(t, now.Add(20*time.Hour), cert.RenewAfter(), time.Minute)
+
+	_, err = parsed.Verify(x509.VerifyOptions{DNSName: NodeDNSName("node-1"), Roots: ca.Pool()})
+	assert.NoError(t, err)
+
+	_, err = ca.SignNodeCSR([]byte("not a csr"), "node-1", time.Hour, now)
+	assert.ErrorIs(t, err, ErrInvalidCSR)
+}
+
+func TestTransports_MutualTLSPinnedToNodeID(t *testing.T) {
+	ca, err := GenerateCA("test CA")
+	require.NoError(t, err)
+	transports := NewTransports(ca, time.Hour)
+	node := newAgentServer(t, ca, "node-1")
+
+	transport, err := transports.RoundTripper(node)
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: transport}).Get(node.Endpoint())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// 另一个节点占用了该地址：证书中的节点 ID 不匹配，握手失败。
+	impostor := *node
+	impostor.ID = "node-2"
+	transport, err = transports.RoundTripper(&impostor)
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: transport}).Get(impostor.Endpoint())
+	assert.Error(t, err)
+}
+
+func TestTransports_RejectsStaleCertificate(t *testing.T) {
+	ca, err := GenerateCA("test CA")
+	require.NoError(t, err)
+	transports := NewTransports(ca, time.Hour)
+	node := newAgentServer(t, ca, "node-1")
+
+	// 证书续期后，仍出示旧证书的 agent 不被信任。
+	renewed := *node
+	renewed.CertificateSerial = "1"
+	transport, err := transports.RoundTripper(&renewed)
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: transport}).Get(renewed.Endpoint())
+	assert.ErrorIs(t, err, ErrStaleCertificate)
+
+	transport, err = transports.RoundTripper(node)
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: transport}).Get(node.Endpoint())
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	transports.Retain(nil)
+	assert.Empty(t, transports.transports, "deleted nodes are dropped from the cache")
+}
+
+func TestTransports_PlainHTTPAndMissingCA(t *testing.T) {
+	var transports *Transports
+	transport, err := transports.RoundTripper(&models.Node{ID: "node-1"})
+	require.NoError(t, err)
+	assert.Equal(t, http.DefaultTransport, transport)
+
+	_, err = transports.RoundTripper(&models.Node{ID: "node-1", CertificateSerial: "abc"})
+	assert.ErrorIs(t, err, ErrNoCA)
+}
+
+func TestTransports_RotatesClientCertificate(t *testing.T) {
+	ca, err := GenerateCA("test CA")
+	require.NoError(t, err)
+	transports := NewTransports(ca, 3*time.Hour)
+
+	now := time.Now()
+	first, err := transports.ClientCertificate(now)
+	require.NoError(t, err)
+	assert.Equal(t, ServerCommonName, first.Leaf.Subject.CommonName)
+
+	same, err := transports.ClientCertificate(now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Same(t, first, same)
+
+	rotated, err := transports.ClientCertificate(now.Add(2*time.Hour + time.Minute))
+	require.NoError(t, err)
+	assert.NotSame(t, first, rotated)
+}
@@ -0,0 +1,124 @@
+package pki
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"utopia-server/internal/models"
+)
+
+// ErrNoCA 表示节点持有证书、必须通过双向 TLS 访问，但服务器没有配置 CA。
+var ErrNoCA = errors.New("node requires mutual TLS but no CA is configured")
+
+// ErrStaleCertificate 表示 agent 出示的证书不是服务器为该节点记录的当前证书，例如续期前的旧证书。
+var ErrStaleCertificate = errors.New("agent presented a certificate other than the node's current one")
+
+// Transports 为持有证书的节点提供双向 TLS 的 http.RoundTripper。
+// 服务器用自己的客户端证书认证，并要求 agent 出示签发给该节点 ID、序列号为节点当前记录的证书，
+// 续期或重新注册后旧证书即被拒绝；客户端证书在有效期过去三分之二后自动轮换。
+type Transports struct {
+	ca            *CA
+	clientCertTTL time.Duration
+
+	mu         sync.Mutex
+	clientCert *tls.Certificate
+	transports map[string]*nodeTransport // 按节点 ID 缓存，复用连接
+}
+
+// nodeTransport 是为某个节点证书建立的 Transport。
+type nodeTransport struct {
+	serial    string
+	transport *http.Transport
+}
+
+// NewTransports 创建 Transports。ca 为 nil 时返回 nil，此时所有节点都通过 HTTP 访问。
+func NewTransports(ca *CA, clientCertTTL time.Duration) *Transports {
+	if ca == nil {
+		return nil
+	}
+	return &Transports{
+		ca:            ca,
+		clientCertTTL: clientCertTTL,
+		transports:    make(map[string]*nodeTransport),
+	}
+}
+
+// RoundTripper 返回访问 node 的 agent 时使用的 RoundTripper。
+// 没有证书的节点返回 http.DefaultTransport；t 可以为 nil。
+func (t *Transports) RoundTripper(node *models.Node) (http.RoundTripper, error) {
+	if !node.HasCertificate() {
+		return http.DefaultTransport, nil
+	}
+	if t == nil {
+		return nil, ErrNoCA
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cached, ok := t.transports[node.ID]; ok {
+		if cached.serial == node.CertificateSerial {
+			return cached.transport, nil
+		}
+		// 证书已续期或节点已重新注册，旧证书建立的连接不再复用。
+		cached.transport.CloseIdleConnections()
+	}
+	serial := node.CertificateSerial
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		RootCAs:              t.ca.Pool(),
+		ServerName:           NodeDNSName(node.ID),
+		GetClientCertificate: t.getClientCertificate,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 || state.PeerCertificates[0].SerialNumber.Text(16) != serial {
+				return ErrStaleCertificate
+			}
+			return nil
+		},
+	}
+	t.transports[node.ID] = &nodeTransport{serial: serial, transport: transport}
+	return transport, nil
+}
+
+// Retain 丢弃不在 nodes 中的节点（已删除或已合并）的 Transport 并关闭其空闲连接。t 可以为 nil。
+func (t *Transports) Retain(nodes []*models.Node) {
+	if t == nil {
+		return
+	}
+	exists := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		exists[node.ID] = true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for nodeID, cached := range t.transports {
+		if !exists[nodeID] {
+			cached.transport.CloseIdleConnections()
+			delete(t.transports, nodeID)
+		}
+	}
+}
+
+func (t *Transports) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return t.ClientCertificate(time.Now())
+}
+
+// ClientCertificate 返回服务器当前的客户端证书，必要时签发新证书。
+func (t *Transports) ClientCertificate(now time.Time) (*tls.Certificate, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.clientCert != nil && now.Before(renewAfter(t.clientCert.Leaf.NotBefore, t.clientCert.Leaf.NotAfter)) {
+		return t.clientCert, nil
+	}
+	cert, err := t.ca.issueClientCertificate(t.clientCertTTL, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue client certificate: %w", err)
+	}
+	t.clientCert = cert
+	return cert, nil
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"database/sql"
+	"fmt"
+
+	"utopia-server/internal/models"
+)
+
+type mysqlStore struct {
+	db *sql.DB
+}
+
+func NewMySQLStore(db *sql.DB) Store {
+	return &mysqlStore{db: db}
+}
+
+func (s *mysqlStore) CreateCredential(cred *models.RegistryCredential) error {
+	query := `
+		INSERT INTO registry_credentials (id, registry, username, password, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	if _, err := s.db.Exec(query, cred.ID, cred.Registry, cred.Username, cred.Password, cred.CreatedAt, cred.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to create registry credential: %w", err)
+	}
+	return nil
+}
+
+func (s *mysqlStore) GetCredential(id string) (*models.RegistryCredential, error) {
+	query := "SELECT id, registry, username, password, created_at, updated_at FROM registry_credentials WHERE id = ?"
+	var cred models.RegistryCredential
+	err := s.db.QueryRow(query, id).Scan(&cred.ID, &cred.Registry, &cred.Username, &cred.Password, &cred.CreatedAt, &cred.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrCredentialNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get registry credential: %w", err)
+	}
+	return &cred, nil
+}
+
+func (s *mysqlStore) ListCredentials() ([]models.RegistryCredential, error) {
+	query := "SELECT id, registry, username, password, created_at, updated_at FROM registry_credentials ORDER BY registry"
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list registry credentials: %w", err)
+	}
+	defer rows.Close()
+
+	result := []models.RegistryCredential{}
+	for rows.Next() {
+		var cred models.RegistryCredential
+		if err := rows.Scan(&cred.ID, &cred.Registry, &cred.Username, &cred.Password, &cred.CreatedAt, &cred.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan registry credential: %w", err)
+		}
+		result = append(result, cred)
+	}
+	return result, rows.Err()
+}
+
+func (s *mysqlStore) UpdateCredential(cred *models.RegistryCredential) error {
+	query := "UPDATE registry_credentials SET username = ?, password = ?, updated_at = ? WHERE id = ?"
+	result, err := s.db.Exec(query, cred.Username, cred.Password, cred.UpdatedAt, cred.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update registry credential: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrCredentialNotFound
+	}
+	return nil
+}
+
+func (s *mysqlStore) DeleteCredential(id string) error {
+	result, err := s.db.Exec("DELETE FROM registry_credentials WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete registry credential: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrCredentialNotFound
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Package registry 管理私有镜像仓库的凭据，并为镜像查找 agent 拉取时所需的凭据。
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"utopia-server/internal/models"
+	"utopia-server/internal/secrets"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrCredentialExists 表示该仓库已经有凭据。
+	ErrCredentialExists = errors.New("registry credential already exists")
+	// ErrInvalidRegistry 表示仓库地址为空或格式错误。
+	ErrInvalidRegistry = errors.New("registry must be a host or repository prefix such as nvcr.io or ghcr.io/acme")
+	// ErrNoSecretsKey 表示服务器没有配置主密钥，无法加密或解密仓库密码。
+	ErrNoSecretsKey = errors.New("registry credentials require secrets.key to be configured")
+)
+
+// Service 管理仓库凭据。密码用 secrets.Box 加密后保存，只在发给 agent 时解密。
+type Service struct {
+	store Store
+	box   *secrets.Box // 为 nil 时无法保存或使用凭据
+}
+
+// NewService 创建仓库凭据服务。box 可以为 nil，此时不能创建凭据。
+func NewService(store Store, box *secrets.Box) *Service {
+	return &Service{store: store, box: box}
+}
+
+// CreateCredential 为仓库保存凭据。registry 可以是仓库地址或路径前缀，匹配规则同 models.ImageMatches。
+func (s *Service) CreateCredential(registry, username, password string) (*models.RegistryCredential, error) {
+	registry, err := normalizeRegistry(registry)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.store.ListCredentials()
+	if err != nil {
+		return nil, err
+	}
+	for _, cred := range existing {
+		if cred.Registry == registry {
+			return nil, ErrCredentialExists
+		}
+	}
+
+	id := uuid.NewString()
+	sealed, err := s.seal(id, password)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	cred := &models.RegistryCredential{
+		ID:        id,
+		Registry:  registry,
+		Username:  username,
+		Password:  sealed,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.store.CreateCredential(cred); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+func (s *Service) GetCredential(id string) (*models.RegistryCredential, error) {
+	return s.store.GetCredential(id)
+}
+
+func (s *Service) ListCredentials() ([]models.RegistryCredential, error) {
+	return s.store.ListCredentials()
+}
+
+// UpdateCredential 替换凭据的用户名和密码，仓库地址不可修改。
+func (s *Service) UpdateCredential(id, username, password string) (*models.RegistryCredential, error) {
+	cred, err := s.store.GetCredential(id)
+	if err != nil {
+		return nil, err
+	}
+	if cred.Password, err = s.seal(cred.ID, password); err != nil {
+		return nil, err
+	}
+	cred.Username = username
+	cred.UpdatedAt = time.Now()
+	if err := s.store.UpdateCredential(cred); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+func (s *Service) DeleteCredential(id string) error {
+	return s.store.DeleteCredential(id)
+}
+
+// PullSecret 返回拉取 image 所需的凭据；有多个凭据匹配时使用最具体的一个。
+// 没有匹配的凭据时返回 nil，agent 以匿名方式拉取。
+func (s *Service) PullSecret(image string) (*models.PullSecret, error) {
+	creds, err := s.store.ListCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list registry credentials: %w", err)
+	}
+	var match *models.RegistryCredential
+	for i := range creds {
+		if models.ImageMatches(creds[i].Registry, image) && (match == nil || len(creds[i].Registry) > len(match.Registry)) {
+			match = &creds[i]
+		}
+	}
+	if match == nil {
+		return nil, nil
+	}
+
+	if s.box == nil {
+		return nil, ErrNoSecretsKey
+	}
+	password, err := s.box.Open(match.Password, secrets.RegistryPasswordAD(match.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credential for %s: %w", match.Registry, err)
+	}
+	return &models.PullSecret{
+		Registry: models.ImageRegistry(image),
+		Username: match.Username,
+		Password: string(password),
+	}, nil
+}
+
+// seal 加密凭据 id 的密码，密文绑定到该凭据。
+func (s *Service) seal(id, password string) (string, error) {
+	if s.box == nil {
+		return "", ErrNoSecretsKey
+	}
+	return s.box.Seal([]byte(password), secrets.RegistryPasswordAD(id))
+}
+
+// normalizeRegistry 去掉首尾空白和末尾的 "/"，并拒绝带协议或通配符的地址。
+func normalizeRegistry(registry string) (string, error) {
+	registry = strings.TrimSuffix(strings.TrimSpace(registry), "/")
+	if registry == "" || strings.Contains(registry, "://") || strings.ContainsAny(registry, "* \t@") {
+		return "", ErrInvalidRegistry
+	}
+	return registry, nil
+}
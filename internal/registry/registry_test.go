@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"testing"
+	"utopia-server/internal/models"
+	"utopia-server/internal/secrets"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	box, err := secrets.NewBox(make([]byte, secrets.KeySize))
+	require.NoError(t, err)
+	return NewService(NewMemStore(), box)
+}
+
+func TestCreateCredential_EncryptsPassword(t *testing.T) {
+	service := newTestService(t)
+
+	cred, err := service.CreateCredential(" nvcr.io/ ", "$oauthtoken", "s3cret")
+	require.NoError(t, err)
+	assert.Equal(t, "nvcr.io", cred.Registry)
+	assert.NotContains(t, cred.Password, "s3cret")
+
+	_, err = service.CreateCredential("nvcr.io", "other", "other")
+	assert.ErrorIs(t, err, ErrCredentialExists)
+	_, err = service.CreateCredential("https://nvcr.io", "user", "pass")
+	assert.ErrorIs(t, err, ErrInvalidRegistry)
+}
+
+func TestCreateCredential_RequiresSecretsKey(t *testing.T) {
+	service := NewService(NewMemStore(), nil)
+
+	_, err := service.CreateCredential("nvcr.io", "user", "pass")
+	assert.ErrorIs(t, err, ErrNoSecretsKey)
+
+	secret, err := service.PullSecret("nvcr.io/nvidia/pytorch:24.01-py3")
+	require.NoError(t, err, "images without credentials can still be pulled")
+	assert.Nil(t, secret)
+}
+
+func TestPullSecret_UsesMostSpecificCredential(t *testing.T) {
+	service := newTestService(t)
+	_, err := service.CreateCredential("ghcr.io", "org-bot", "org-pass")
+	require.NoError(t, err)
+	team, err := service.CreateCredential("ghcr.io/acme", "acme-bot", "acme-pass")
+	require.NoError(t, err)
+
+	secret, err := service.PullSecret("ghcr.io/acme/trainer:v2")
+	require.NoError(t, err)
+	assert.Equal(t, &models.PullSecret{Registry: "ghcr.io", Username: "acme-bot", Password: "acme-pass"}, secret)
+
+	secret, err = service.PullSecret("ghcr.io/acme-labs/trainer:v2")
+	require.NoError(t, err)
+	assert.Equal(t, "org-bot", secret.Username, "path prefixes match whole segments")
+
+	secret, err = service.PullSecret("pytorch/pytorch")
+	require.NoError(t, err)
+	assert.Nil(t, secret)
+
+	_, err = service.UpdateCredential(team.ID, "acme-bot", "rotated")
+	require.NoError(t, err)
+	secret, err = service.PullSecret("ghcr.io/acme/trainer:v2")
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", secret.Password)
+
+	require.NoError(t, service.DeleteCredential(team.ID))
+	assert.ErrorIs(t, service.DeleteCredential(team.ID), ErrCredentialNotFound)
+}
+
+func TestPolicies_AllowsImage(t *testing.T) {
+	unrestricted := models.Policies{"max_gpu_count": float64(2)}
+	assert.True(t, unrestricted.AllowsImage("anything:latest"))
+
+	// Policies read from the database hold []interface{}.
+	policies := models.Policies{models.PolicyAllowedRegistries: []interface{}{"nvcr.io/nvidia", "docker.io/library/*"}}
+	assert.True(t, policies.AllowsImage("nvcr.io/nvidia/pytorch:24.01-py3"))
+	assert.True(t, policies.AllowsImage("ubuntu:22.04"))
+	assert.False(t, policies.AllowsImage("nvcr.io/nvidia-private/pytorch"))
+	assert.False(t, policies.AllowsImage("ghcr.io/acme/trainer"))
+
+	none := models.Policies{models.PolicyAllowedRegistries: []string{}}
+	assert.False(t, none.AllowsImage("ubuntu"))
+	all := models.Policies{models.PolicyAllowedRegistries: []string{"*"}}
+	assert.True(t, all.AllowsImage("ghcr.io/acme/trainer"))
+}
+
+func TestPullSecret_RejectsPasswordCopiedFromOtherCredential(t *testing.T) {
+	service := newTestService(t)
+	public, err := service.CreateCredential("ghcr.io/acme", "acme-bot", "acme-pass")
+	require.NoError(t, err)
+	attacker, err := service.CreateCredential("evil.example.com", "bot", "unused")
+	require.NoError(t, err)
+
+	attacker.Password = public.Password
+	require.NoError(t, service.store.UpdateCredential(attacker))
+	_, err = service.PullSecret("evil.example.com/trainer")
+	assert.ErrorIs(t, err, secrets.ErrInvalidCiphertext)
+}
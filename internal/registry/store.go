@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"utopia-server/internal/models"
+)
+
+// ErrCredentialNotFound 表示仓库凭据不存在。
+var ErrCredentialNotFound = errors.New("registry credential not found")
+
+// Store 定义了仓库凭据的持久化接口。凭据按 Registry 唯一，密码以密文形式保存。
+type Store interface {
+	CreateCredential(cred *models.RegistryCredential) error
+	GetCredential(id string) (*models.RegistryCredential, error)
+	// ListCredentials 按 Registry 排序返回所有凭据。
+	ListCredentials() ([]models.RegistryCredential, error)
+	UpdateCredential(cred *models.RegistryCredential) error
+	DeleteCredential(id string) error
+}
+
+// memStore 是 Store 接口的一个内存实现，主要用于测试。
+type memStore struct {
+	mu          sync.RWMutex
+	credentials map[string]models.RegistryCredential
+}
+
+// NewMemStore 创建一个新的 memStore 实例。
+func NewMemStore() Store {
+	return &memStore{credentials: make(map[string]models.RegistryCredential)}
+}
+
+func (s *memStore) CreateCredential(cred *models.RegistryCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.credentials[cred.ID] = *cred
+	return nil
+}
+
+func (s *memStore) GetCredential(id string) (*models.RegistryCredential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cred, ok := s.credentials[id]
+	if !ok {
+		return nil, ErrCredentialNotFound
+	}
+	return &cred, nil
+}
+
+func (s *memStore) ListCredentials() ([]models.RegistryCredential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []models.RegistryCredential{}
+	for _, cred := range s.credentials {
+		result = append(result, cred)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Registry < result[j].Registry })
+	return result, nil
+}
+
+func (s *memStore) UpdateCredential(cred *models.RegistryCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.credentials[cred.ID]; !ok {
+		return ErrCredentialNotFound
+	}
+	s.credentials[cred.ID] = *cred
+	return nil
+}
+
+func (s *memStore) DeleteCredential(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.credentials[id]; !ok {
+		return ErrCredentialNotFound
+	}
+	delete(s.credentials, id)
+	return nil
+}
@@ -2,6 +2,8 @@ package scheduler
 
 import (
 	"errors"
+	"time"
+	"utopia-server/internal/metrics"
 	"utopia-server/internal/models"
 )
 
@@ -24,8 +26,24 @@ func NewScheduler(nodeStore NodeStore) *Scheduler {
 
 // Schedule finds a suitable node for the given GpuClaim.
 // The current algorithm is a simple first-fit: it finds the first online node
-// that has enough available GPUs to satisfy the claim.
-func (s *Scheduler) Schedule(claim *models.GpuClaim) (*models.Node, error) {
+// that has enough available GPUs to satisfy the claim. Quarantined GPUs are
+// never counted as available, and nodes whose agent shares no API version with
+// the server are skipped. When the claim sets
+// MinCudaVersion, nodes that have not reported a CUDA version, or report an
+// older one, are skipped. Among the nodes that fit, the first one that already
+// has the claim's image cached wins, so the container does not wait for a pull.
+func (s *Scheduler) Schedule(claim *models.GpuClaim) (selected *models.Node, err error) {
+	start := time.Now()
+	defer func() {
+		result := "scheduled"
+		if errors.Is(err, ErrNoSuitableNodeFound) {
+			result = "unschedulable"
+		} else if err != nil {
+			result = "error"
+		}
+		metrics.SchedulingDuration.Observe(time.Since(start).Seconds(), result)
+	}()
+
 	nodes, err := s.nodeStore.ListNodes()
 	if err != nil {
 		return nil, err
@@ -33,22 +51,53 @@ func (s *Scheduler) Schedule(claim *models.GpuClaim) (*models.Node, error) {
 
 	requiredGpuCount := claim.Spec.Resources.GpuCount
 
+	var firstFit *models.Node
 	for _, node := range nodes {
 		if node.Status != "Online" {
 			continue
 		}
+		if _, ok := node.AgentAPI(); !ok {
+			continue // the agent speaks no API version the server understands
+		}
+		if !meetsCudaVersion(node, claim.Spec.MinCudaVersion) {
+			continue
+		}
 
 		availableGpuCount := 0
 		for _, gpu := range node.Gpus {
-			if !gpu.Busy {
+			if !gpu.Busy && gpu.Health != models.GpuHealthQuarantined {
 				availableGpuCount++
 			}
 		}
 
-		if availableGpuCount >= requiredGpuCount {
+		if availableGpuCount < requiredGpuCount {
+			continue
+		}
+		if claim.Spec.Image != "" && node.HasImage(claim.Spec.Image) {
+			metrics.ImageCacheHits.Inc("hit")
 			return node, nil
 		}
+		if firstFit == nil {
+			firstFit = node
+		}
 	}
 
-	return nil, ErrNoSuitableNodeFound
+	if firstFit == nil {
+		return nil, ErrNoSuitableNodeFound
+	}
+	if claim.Spec.Image != "" {
+		metrics.ImageCacheHits.Inc("miss")
+	}
+	return firstFit, nil
+}
+
+// meetsCudaVersion reports whether the node's CUDA version satisfies minimum.
+func meetsCudaVersion(node *models.Node, minimum string) bool {
+	if minimum == "" {
+		return true
+	}
+	if node.Inventory == nil {
+		return false
+	}
+	return models.VersionAtLeast(node.Inventory.CudaVersion, minimum)
 }
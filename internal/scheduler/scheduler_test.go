@@ -0,0 +1,118 @@
+package scheduler
+
+import (
+	"testing"
+	"utopia-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeNodeStore []*models.Node
+
+func (s fakeNodeStore) ListNodes() ([]*models.Node, error) {
+	return s, nil
+}
+
+func TestSchedule_MinCudaVersion(t *testing.T) {
+	gpus := []models.GpuInfo{{ID: 0}}
+	unknown := &models.Node{ID: "unknown", Status: models.NodeStatusOnline, Gpus: gpus}
+	old := &models.Node{ID: "old", Status: models.NodeStatusOnline, Gpus: gpus, Inventory: &models.NodeInventory{CudaVersion: "11.8"}}
+	recent := &models.Node{ID: "recent", Status: models.NodeStatusOnline, Gpus: gpus, Inventory: &models.NodeInventory{CudaVersion: "12.2"}}
+	sched := NewScheduler(fakeNodeStore{unknown, old, recent})
+
+	claim := &models.GpuClaim{}
+	claim.Spec.Resources.GpuCount = 1
+
+	selected, err := sched.Schedule(claim)
+	require.NoError(t, err)
+	assert.Equal(t, "unknown", selected.ID, "without a requirement the first fit wins")
+
+	claim.Spec.MinCudaVersion = "12"
+	selected, err = sched.Schedule(claim)
+	require.NoError(t, err)
+	assert.Equal(t, "recent", selected.ID)
+
+	claim.Spec.MinCudaVersion = "12.4"
+	_, err = sched.Schedule(claim)
+	assert.ErrorIs(t, err, ErrNoSuitableNodeFound)
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	assert.True(t, models.VersionAtLeast("12.2", "12.2"))
+	assert.True(t, models.VersionAtLeast("12.10", "12.2"))
+	assert.True(t, models.VersionAtLeast("12", "12.0"))
+	assert.False(t, models.VersionAtLeast("11.8", "12"))
+	assert.False(t, models.VersionAtLeast("", "12"))
+	assert.False(t, models.IsValidVersion("12.x"))
+}
+
+func TestSchedule_SkipsQuarantinedGpus(t *testing.T) {
+	faulty := &models.Node{ID: "faulty", Status: models.NodeStatusOnline, Gpus: []models.GpuInfo{
+		{ID: 0, Health: models.GpuHealthQuarantined},
+		{ID: 1, Health: models.GpuHealthSuspect},
+	}}
+	healthy := &models.Node{ID: "healthy", Status: models.NodeStatusOnline, Gpus: []models.GpuInfo{{ID: 0}, {ID: 1}}}
+	sched := NewScheduler(fakeNodeStore{faulty, healthy})
+
+	claim := &models.GpuClaim{}
+	claim.Spec.Resources.GpuCount = 2
+	selected, err := sched.Schedule(claim)
+	require.NoError(t, err)
+	assert.Equal(t, "healthy", selected.ID)
+}
+
+func TestSchedule_SkipsIncompatibleAgents(t *testing.T) {
+	gpus := []models.GpuInfo{{ID: 0}}
+	future := &models.Node{ID: "future", Status: models.NodeStatusOnline, Gpus: gpus, Inventory: &models.NodeInventory{APIVersions: []string{"v7"}}}
+	current := &models.Node{ID: "current", Status: models.NodeStatusOnline, Gpus: gpus, Inventory: &models.NodeInventory{APIVersions: []string{"v7", "v1"}}}
+	sched := NewScheduler(fakeNodeStore{future, current})
+
+	claim := &models.GpuClaim{}
+	claim.Spec.Resources.GpuCount = 1
+	selected, err := sched.Schedule(claim)
+	require.NoError(t, err)
+	assert.Equal(t, "current", selected.ID)
+
+	version, ok := selected.AgentAPI()
+	assert.True(t, ok)
+	assert.Equal(t, models.AgentAPIV1, version)
+	version, _ = (&models.Node{}).AgentAPI()
+	assert.Equal(t, models.AgentAPIV0, version, "agents that report no versions are treated as v0")
+}
+
+func TestSchedule_PrefersNodeWithCachedImage(t *testing.T) {
+	gpus := []models.GpuInfo{{ID: 0}}
+	cold := &models.Node{ID: "cold", Status: models.NodeStatusOnline, Gpus: gpus}
+	full := &models.Node{ID: "full", Status: models.NodeStatusOnline, Gpus: []models.GpuInfo{{ID: 0, Busy: true}},
+		Images: []models.CachedImage{{Reference: "pytorch/pytorch:2.3"}}}
+	warm := &models.Node{ID: "warm", Status: models.NodeStatusOnline, Gpus: gpus,
+		Images: []models.CachedImage{{Reference: "docker.io/pytorch/pytorch:2.3", Digest: "sha256:abc"}}}
+	sched := NewScheduler(fakeNodeStore{cold, full, warm})
+
+	claim := &models.GpuClaim{}
+	claim.Spec.Resources.GpuCount = 1
+
+	claim.Spec.Image = "pytorch/pytorch:2.3"
+	selected, err := sched.Schedule(claim)
+	require.NoError(t, err)
+	assert.Equal(t, "warm", selected.ID, "a fitting node with the image beats the first fit")
+
+	claim.Spec.Image = "pytorch/pytorch@sha256:abc"
+	selected, err = sched.Schedule(claim)
+	require.NoError(t, err)
+	assert.Equal(t, "warm", selected.ID, "digest references match the reported digest")
+
+	claim.Spec.Image = "tensorflow/tensorflow"
+	selected, err = sched.Schedule(claim)
+	require.NoError(t, err)
+	assert.Equal(t, "cold", selected.ID, "without a cached image the first fit wins")
+}
+
+func TestNormalizeImage(t *testing.T) {
+	assert.Equal(t, "docker.io/library/ubuntu:latest", models.NormalizeImage("ubuntu"))
+	assert.Equal(t, "docker.io/pytorch/pytorch:2.3", models.NormalizeImage("pytorch/pytorch:2.3"))
+	assert.Equal(t, "nvcr.io/nvidia/pytorch:24.01-py3", models.NormalizeImage("nvcr.io/nvidia/pytorch:24.01-py3"))
+	assert.Equal(t, "localhost:5000/train:latest", models.NormalizeImage("localhost:5000/train"))
+	assert.Equal(t, "docker.io/library/ubuntu@sha256:abc", models.NormalizeImage("ubuntu@sha256:abc"))
+}
@@ -0,0 +1,64 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"utopia-server/internal/models"
+)
+
+// ErrNoSecretsKey 表示节点有加密保存的 agent 密钥，但服务器没有配置主密钥，无法签名。
+var ErrNoSecretsKey = errors.New("node has an agent secret but no secrets key is configured")
+
+// ErrNoAgentSecret 表示节点没有 agent 密钥，而共享令牌已被禁用。
+var ErrNoAgentSecret = errors.New("node has no agent secret and the shared agent token is disabled")
+
+// AgentAuthenticator 为服务器发往节点 agent 的请求添加认证信息。
+// 拥有 agent 密钥的节点使用 HMAC 签名；在此之前注册、尚未轮换凭据的节点仍使用共享的 frp.agent_token。
+type AgentAuthenticator struct {
+	box         *Box // 为 nil 时无法解密节点密钥
+	sharedToken string
+}
+
+// NewAgentAuthenticator 创建 AgentAuthenticator。box 可以为 nil，此时所有节点都使用共享令牌。
+// sharedToken 为空表示禁用共享令牌，没有 agent 密钥的节点将无法被调用。
+func NewAgentAuthenticator(box *Box, sharedToken string) *AgentAuthenticator {
+	return &AgentAuthenticator{box: box, sharedToken: sharedToken}
+}
+
+// Authenticate 为发往 node 的请求签名或添加共享令牌。body 是请求体，没有请求体时为 nil。
+func (a *AgentAuthenticator) Authenticate(req *http.Request, node *models.Node, body []byte) error {
+	if a == nil {
+		return nil
+	}
+	if node.AgentSecret == "" {
+		if a.sharedToken == "" {
+			return ErrNoAgentSecret
+		}
+		req.Header.Set("Authorization", "Bearer "+a.sharedToken)
+		return nil
+	}
+	if a.box == nil {
+		return ErrNoSecretsKey
+	}
+
+	secret, err := a.box.Open(node.AgentSecret, AgentSecretAD(node.ID))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt agent secret of node %s: %w", node.ID, err)
+	}
+	return SignRequest(req, node.ID, secret, body, time.Now())
+}
+
+// AgentSecretAD 返回加密节点 agent 密钥时使用的附加数据，把密文绑定到节点 ID，
+// 使其不能被复制到其他节点的记录上使用。
+func AgentSecretAD(nodeID string) []byte {
+	return []byte("agent-secret:" + nodeID)
+}
+
+// RegistryPasswordAD 返回加密仓库凭据密码时使用的附加数据，把密文绑定到凭据 ID，
+// 使其不能被复制到其他仓库的凭据上使用。
+func RegistryPasswordAD(credentialID string) []byte {
+	return []byte("registry-password:" + credentialID)
+}
@@ -0,0 +1,85 @@
+// Package secrets 提供服务器保存敏感数据所需的加密工具，以及服务器调用节点 agent 时的请求签名。
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize 是主密钥的字节数（AES-256）。
+const KeySize = 32
+
+// sealedPrefix 标记密文格式的版本，便于以后更换算法。
+// v2 的密文绑定了附加数据（例如节点 ID），不能挪给其他记录使用。
+// 没有附加数据的 v1 密文可以被复制到其他记录上，因此不再接受。
+const sealedPrefix = "v2:"
+
+// ErrInvalidCiphertext 表示密文格式错误、被篡改或不是用当前主密钥加密的。
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
+// Box 使用 AES-256-GCM 加密需要由服务器还原明文的密钥，例如节点的 agent 签名密钥。
+type Box struct {
+	aead cipher.AEAD
+}
+
+// ParseKey 解码 base64 编码的主密钥，例如 `openssl rand -base64 32` 的输出。
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("secrets key is not valid base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("secrets key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// NewBox 使用 32 字节的主密钥创建 Box。
+func NewBox(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("secrets key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal 加密 plaintext，返回可直接存入数据库的字符串：版本前缀加 base64(nonce || 密文)。
+// additionalData 不会被加密，但解密时必须原样提供，用来把密文绑定到它所属的记录；可以为 nil。
+func (b *Box) Seal(plaintext, additionalData []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := b.aead.Seal(nonce, nonce, plaintext, additionalData)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open 解密 Seal 的输出，additionalData 必须与加密时相同。
+func (b *Box) Open(sealed string, additionalData []byte) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(sealed, sealedPrefix)
+	if !ok {
+		return nil, ErrInvalidCiphertext
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < b.aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	nonce, ciphertext := data[:b.aead.NonceSize()], data[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plaintext, nil
+}
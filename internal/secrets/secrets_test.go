@@ -0,0 +1,145 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"utopia-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBox(t *testing.T) *Box {
+	t.Helper()
+	key := make([]byte, KeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	box, err := NewBox(key)
+	require.NoError(t, err)
+	return box
+}
+
+func TestParseKey(t *testing.T) {
+	key := make([]byte, KeySize)
+	parsed, err := ParseKey(base64.StdEncoding.EncodeToString(key))
+	require.NoError(t, err)
+	assert.Equal(t, key, parsed)
+
+	_, err = ParseKey(base64.StdEncoding.EncodeToString(key[:16]))
+	assert.Error(t, err)
+	_, err = ParseKey("not base64!")
+	assert.Error(t, err)
+}
+
+func TestBox_SealOpen(t *testing.T) {
+	box := newTestBox(t)
+
+	sealed, err := box.Seal([]byte("agent-secret"), []byte("node-1"))
+	require.NoError(t, err)
+	assert.NotContains(t, sealed, "agent-secret")
+
+	opened, err := box.Open(sealed, []byte("node-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "agent-secret", string(opened))
+
+	_, err = box.Open(sealed, []byte("node-2"))
+	assert.ErrorIs(t, err, ErrInvalidCiphertext, "ciphertext is bound to its additional data")
+
+	again, err := box.Seal([]byte("agent-secret"), []byte("node-1"))
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again, "each seal must use a fresh nonce")
+}
+
+func TestBox_OpenRejectsLegacyCiphertext(t *testing.T) {
+	box := newTestBox(t)
+	nonce := make([]byte, box.aead.NonceSize())
+	legacy := "v1:" + base64.StdEncoding.EncodeToString(box.aead.Seal(nonce, nonce, []byte("agent-secret"), nil))
+
+	_, err := box.Open(legacy, []byte("node-1"))
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+	_, err = box.Open(legacy, nil)
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+}
+
+func TestBox_OpenRejectsTamperingAndWrongKey(t *testing.T) {
+	box := newTestBox(t)
+	sealed, err := box.Seal([]byte("agent-secret"), nil)
+	require.NoError(t, err)
+
+	raw, err := base64.StdEncoding.DecodeString(sealed[len(sealedPrefix):])
+	require.NoError(t, err)
+	raw[len(raw)-1] ^= 0xff
+	_, err = box.Open(sealedPrefix+base64.StdEncoding.EncodeToString(raw), nil)
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+
+	_, err = newTestBox(t).Open(sealed, nil)
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+
+	_, err = box.Open("plain", nil)
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+}
+
+func TestSignAndVerifyRequest(t *testing.T) {
+	secret := []byte("agent-secret")
+	now := time.Now()
+	body := []byte(`{"image":"pytorch"}`)
+
+	req := httptest.NewRequest("POST", "/api/v1/containers?x=1", bytes.NewReader(body))
+	require.NoError(t, SignRequest(req, "node-1", secret, body, now))
+
+	nonce, err := VerifyRequest(req, secret, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, req.Header.Get(HeaderNonce), nonce)
+
+	_, err = VerifyRequest(req, []byte("other"), now)
+	assert.ErrorIs(t, err, ErrInvalidSignature, "wrong secret")
+
+	_, err = VerifyRequest(req, secret, now.Add(MaxClockSkew+time.Second))
+	assert.ErrorIs(t, err, ErrInvalidSignature, "timestamp outside the allowed skew")
+
+	tampered := httptest.NewRequest("POST", "/api/v1/containers?x=1", bytes.NewReader([]byte(`{"image":"evil"}`)))
+	tampered.Header = req.Header.Clone()
+	_, err = VerifyRequest(tampered, secret, now)
+	assert.ErrorIs(t, err, ErrInvalidSignature, "changed body")
+
+	otherPath := httptest.NewRequest("POST", "/api/v1/containers/abc/stop", bytes.NewReader(body))
+	otherPath.Header = req.Header.Clone()
+	_, err = VerifyRequest(otherPath, secret, now)
+	assert.ErrorIs(t, err, ErrInvalidSignature, "changed path")
+}
+
+func TestAgentAuthenticator(t *testing.T) {
+	box := newTestBox(t)
+	sealed, err := box.Seal([]byte("agent-secret"), AgentSecretAD("node-1"))
+	require.NoError(t, err)
+
+	auth := NewAgentAuthenticator(box, "shared")
+
+	req := httptest.NewRequest("GET", "/api/v1/metrics", nil)
+	require.NoError(t, auth.Authenticate(req, &models.Node{ID: "legacy"}, nil))
+	assert.Equal(t, "Bearer shared", req.Header.Get("Authorization"))
+	assert.Empty(t, req.Header.Get(HeaderSignature))
+
+	req = httptest.NewRequest("GET", "/api/v1/metrics", nil)
+	require.NoError(t, auth.Authenticate(req, &models.Node{ID: "node-1", AgentSecret: sealed}, nil))
+	assert.Empty(t, req.Header.Get("Authorization"))
+	_, err = VerifyRequest(req, []byte("agent-secret"), time.Now())
+	assert.NoError(t, err)
+
+	req = httptest.NewRequest("GET", "/api/v1/metrics", nil)
+	err = NewAgentAuthenticator(nil, "shared").Authenticate(req, &models.Node{ID: "node-1", AgentSecret: sealed}, nil)
+	assert.ErrorIs(t, err, ErrNoSecretsKey)
+
+	req = httptest.NewRequest("GET", "/api/v1/metrics", nil)
+	err = auth.Authenticate(req, &models.Node{ID: "node-2", AgentSecret: sealed}, nil)
+	assert.ErrorIs(t, err, ErrInvalidCiphertext, "an agent secret copied to another node cannot be opened")
+
+	req = httptest.NewRequest("GET", "/api/v1/metrics", nil)
+	err = NewAgentAuthenticator(box, "").Authenticate(req, &models.Node{ID: "legacy"}, nil)
+	assert.ErrorIs(t, err, ErrNoAgentSecret, "the shared token can be disabled")
+	assert.Empty(t, req.Header.Get("Authorization"))
+}
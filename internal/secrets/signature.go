@@ -0,0 +1,107 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 服务器调用 agent 时携带的签名请求头。
+const (
+	HeaderNodeID    = "X-Utopia-Node-Id"
+	HeaderTimestamp = "X-Utopia-Timestamp" // Unix 秒
+	HeaderNonce     = "X-Utopia-Nonce"
+	HeaderSignature = "X-Utopia-Signature" // "v1=" + hex(HMAC-SHA256)
+)
+
+// signatureVersion 是签名格式的版本前缀。
+const signatureVersion = "v1="
+
+// MaxClockSkew 是 agent 接受的请求时间戳与本地时间的最大偏差。
+// agent 需要在该窗口内记住已见过的 nonce，以拒绝重放的请求。
+const MaxClockSkew = 5 * time.Minute
+
+// ErrInvalidSignature 表示请求缺少签名、签名不匹配或时间戳超出允许的偏差。
+var ErrInvalidSignature = errors.New("invalid request signature")
+
+// SignRequest 使用节点的 agent 密钥为请求签名。body 必须与请求实际发送的请求体一致。
+//
+// 签名覆盖以下内容，各字段以换行分隔：
+//
+//	METHOD
+//	path?query
+//	node ID
+//	timestamp
+//	nonce
+//	hex(SHA-256(body))
+func SignRequest(req *http.Request, nodeID string, secret, body []byte, now time.Time) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(HeaderNodeID, nodeID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, hex.EncodeToString(nonce))
+	req.Header.Set(HeaderSignature, signatureVersion+sign(req, nodeID, timestamp, hex.EncodeToString(nonce), secret, body))
+	return nil
+}
+
+// VerifyRequest 校验 SignRequest 生成的签名，供 agent 实现参考和测试使用，返回请求的 nonce。
+// 调用方还需确认 nonce 在 MaxClockSkew 内没有出现过。请求体会被读出后重新放回 req.Body。
+func VerifyRequest(req *http.Request, secret []byte, now time.Time) (string, error) {
+	nodeID := req.Header.Get(HeaderNodeID)
+	timestamp := req.Header.Get(HeaderTimestamp)
+	nonce := req.Header.Get(HeaderNonce)
+	signature, ok := strings.CutPrefix(req.Header.Get(HeaderSignature), signatureVersion)
+	if nodeID == "" || nonce == "" || !ok {
+		return "", ErrInvalidSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	skew := now.Sub(time.Unix(seconds, 0))
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		return "", ErrInvalidSignature
+	}
+
+	var body []byte
+	if req.Body != nil {
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return "", err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	expected := sign(req, nodeID, timestamp, nonce, secret, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return "", ErrInvalidSignature
+	}
+	return nonce, nil
+}
+
+func sign(req *http.Request, nodeID, timestamp, nonce string, secret, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{
+		req.Method,
+		req.URL.RequestURI(),
+		nodeID,
+		timestamp,
+		nonce,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
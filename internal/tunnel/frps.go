@@ -19,8 +19,17 @@ webServer.port = {{ .DashboardPort }}
 webServer.addr = "{{ .DashboardAddr }}"
 webServer.user = "{{ .DashboardUser }}"
 webServer.password = "{{ .DashboardPwd }}"
+
+[[httpPlugins]]
+name = "utopia-server"
+addr = "{{ .PluginAddr }}"
+path = "{{ .PluginPath }}"
+ops = ["Login", "NewProxy", "CloseProxy"]
 `
 
+// PluginPath is the path on utopia-server that handles frps server plugin requests.
+const PluginPath = "/internal/frps/handler"
+
 // Service manages the frps subprocess.
 type Service struct {
 	config     config.FRPConfig
@@ -43,7 +52,11 @@ func (s *Service) Start() error {
 	}
 
 	var configContent bytes.Buffer
-	if err := tmpl.Execute(&configContent, s.config); err != nil {
+	data := struct {
+		config.FRPConfig
+		PluginPath string
+	}{s.config, PluginPath}
+	if err := tmpl.Execute(&configContent, data); err != nil {
 		return fmt.Errorf("failed to execute frps config template: %w", err)
 	}
 